@@ -0,0 +1,221 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mandrakey/shoptrac/config"
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+const (
+	// Version of the archive layout, raised whenever it changes incompatibly
+	BACKUP_FORMAT_VERSION = 1
+
+	MANIFEST_NAME   = "manifest.json"
+	COLLECTIONS_DIR = "collections"
+	IMAGES_DIR      = "images"
+)
+
+/*
+A backup is a gzip compressed tar archive. The manifest comes first, followed by one NDJSON file per collection and,
+optionally, the image files.
+*/
+type Manifest struct {
+	Format        int          `json:"format"`
+	AppVersion    string       `json:"app_version"`
+	SchemaVersion int          `json:"schema_version"`
+	Created       string       `json:"created"`
+	Sessions      bool         `json:"sessions"`
+	Images        bool         `json:"images"`
+	Collections   []Collection `json:"collections"`
+}
+
+type Collection struct {
+	Name      string `json:"name"`
+	Documents int    `json:"documents"`
+}
+
+type Options struct {
+	Sessions bool
+	Images   bool
+}
+
+/*
+A file to add to the archive.
+*/
+type archiveFile struct {
+	name string
+	path string
+}
+
+/*
+Create writes a backup of all collections to w. Sessions are only included if requested, as they are of little use
+after a restore. Returns the manifest of the backup.
+*/
+func Create(w io.Writer, opts Options) (*Manifest, error) {
+	version, err := repository.SchemaVersion()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read schema version: %s", err)
+	}
+	names, err := repository.BackupCollections()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list collections: %s", err)
+	}
+
+	m := Manifest{
+		Format:        BACKUP_FORMAT_VERSION,
+		AppVersion:    config.AppVersion,
+		SchemaVersion: version,
+		Created:       repository.DateTimeToDb(time.Now().UTC()),
+		Sessions:      opts.Sessions,
+		Images:        opts.Images,
+		Collections:   make([]Collection, 0, len(names)),
+	}
+
+	// The size of every file must be known before it is added to the archive, so the collections are dumped into
+	// temporary files first
+	tmp, err := ioutil.TempDir("", "shoptrac-backup")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	files := make([]archiveFile, 0, len(names))
+	for _, name := range names {
+		if name == repository.COLLECTION_SESSIONS && !opts.Sessions {
+			continue
+		}
+
+		file := filepath.Join(tmp, name+".ndjson")
+		count, err := dumpCollection(name, file)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to dump collection '%s': %s", name, err)
+		}
+
+		m.Collections = append(m.Collections, Collection{Name: name, Documents: count})
+		files = append(files, archiveFile{name: collectionFile(name), path: file})
+	}
+
+	if opts.Images {
+		images, err := imageFiles(config.GetAppConfig().ImageDir)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, images...)
+	}
+
+	err = writeArchive(w, m, files)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+func dumpCollection(name string, file string) (int, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	count, err := repository.DumpCollection(name, f)
+	if err != nil {
+		return count, err
+	}
+	return count, f.Close()
+}
+
+/*
+Returns the image and thumbnail files of the image directory. A missing image directory just means there are no
+images.
+*/
+func imageFiles(dir string) ([]archiveFile, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to read image directory: %s", err)
+	}
+
+	res := make([]archiveFile, 0, len(entries))
+	for _, e := range entries {
+		if e.Mode().IsRegular() && isImageFileName(e.Name()) {
+			res = append(res, archiveFile{name: path.Join(IMAGES_DIR, e.Name()), path: filepath.Join(dir, e.Name())})
+		}
+	}
+	return res, nil
+}
+
+func isImageFileName(name string) bool {
+	return repository.IsImageKey(strings.TrimSuffix(name, "_thumb"))
+}
+
+func collectionFile(name string) string {
+	return path.Join(COLLECTIONS_DIR, name+".ndjson")
+}
+
+func writeArchive(w io.Writer, m Manifest, files []archiveFile) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{Name: MANIFEST_NAME, Mode: 0640, Size: int64(len(manifest)), ModTime: time.Now()})
+	if err == nil {
+		_, err = tw.Write(manifest)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write manifest: %s", err)
+	}
+
+	for _, f := range files {
+		err = addFile(tw, f)
+		if err != nil {
+			return fmt.Errorf("Failed to add '%s' to backup: %s", f.name, err)
+		}
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func addFile(tw *tar.Writer, f archiveFile) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0640, Size: info.Size(), ModTime: info.ModTime()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
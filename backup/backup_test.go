@@ -0,0 +1,215 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+const testImageKey = "0123456789abcdef0123456789abcdef01234567"
+
+func TestWriteArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shoptrac-backup-test")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "venues.ndjson")
+	ioutil.WriteFile(file, []byte("{\"_key\":\"1\",\"name\":\"Bakery\"}\n"), 0640)
+
+	m := Manifest{
+		Format:        BACKUP_FORMAT_VERSION,
+		SchemaVersion: 12,
+		Collections:   []Collection{{Name: "venues", Documents: 1}},
+	}
+	var buf bytes.Buffer
+	err = writeArchive(&buf, m, []archiveFile{{name: collectionFile("venues"), path: file}})
+	if err != nil {
+		t.Fatalf("writeArchive returns error: %s", err)
+	}
+
+	gr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("writeArchive does not write gzip data: %s", err)
+	}
+	tr := tar.NewReader(gr)
+
+	read, err := readManifest(tr)
+	if err != nil {
+		t.Fatalf("readManifest returns error: %s", err)
+	}
+	if read.SchemaVersion != 12 || len(read.Collections) != 1 || read.Collections[0].Name != "venues" {
+		t.Errorf("readManifest returns %+v instead of expected manifest", read)
+	}
+
+	h, err := tr.Next()
+	if err != nil || h.Name != "collections/venues.ndjson" {
+		t.Fatalf("writeArchive writes '%v' (%v) instead of expected collection file", h, err)
+	}
+	content, _ := ioutil.ReadAll(tr)
+	if string(content) != "{\"_key\":\"1\",\"name\":\"Bakery\"}\n" {
+		t.Errorf("writeArchive writes '%s' instead of expected collection content", content)
+	}
+}
+
+func TestReadManifestFirst(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: "collections/venues.ndjson", Mode: 0640, Size: 0})
+	tw.Close()
+	gw.Close()
+
+	gr, _ := gzip.NewReader(&buf)
+	if _, err := readManifest(tar.NewReader(gr)); err == nil {
+		t.Error("readManifest returns no error if the manifest is not the first file")
+	}
+}
+
+func TestStageArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shoptrac-backup-test")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	migrations := filepath.Join(dir, "shoptrac_migrations.ndjson")
+	ioutil.WriteFile(migrations, []byte("{\"version\":1}\n"), 0640)
+	venues := filepath.Join(dir, "venues.ndjson")
+	ioutil.WriteFile(venues, []byte("{\"_key\":\"1\"}\n\n{\"_key\":\"2\"}\n"), 0640)
+	image := filepath.Join(dir, "image")
+	ioutil.WriteFile(image, []byte("x"), 0640)
+
+	m := Manifest{
+		Format:        BACKUP_FORMAT_VERSION,
+		SchemaVersion: repository.LATEST_SCHEMA_VERSION,
+		Collections: []Collection{
+			{Name: repository.COLLECTION_SHOPTRAC_MIGRATIONS, Documents: 1},
+			{Name: "venues", Documents: 2},
+		},
+	}
+	files := []archiveFile{
+		{name: collectionFile(repository.COLLECTION_SHOPTRAC_MIGRATIONS), path: migrations},
+		{name: collectionFile("venues"), path: venues},
+		{name: "images/" + testImageKey, path: image},
+	}
+
+	stage := func(m Manifest, files []archiveFile) ([]string, error) {
+		var buf bytes.Buffer
+		err := writeArchive(&buf, m, files)
+		if err != nil {
+			t.Fatalf("writeArchive returns error: %s", err)
+		}
+		gr, _ := gzip.NewReader(&buf)
+		tr := tar.NewReader(gr)
+		readManifest(tr)
+
+		target, err := ioutil.TempDir(dir, "stage")
+		if err != nil {
+			t.Fatalf("Failed to create temporary directory: %s", err)
+		}
+		return stageArchive(tr, m, target)
+	}
+
+	images, err := stage(m, files)
+	if err != nil {
+		t.Fatalf("stageArchive returns error for valid backup: %s", err)
+	}
+	if len(images) != 1 || images[0] != testImageKey {
+		t.Errorf("stageArchive returns images %v instead of expected [%s]", images, testImageKey)
+	}
+
+	if _, err := stage(m, files[:1]); err == nil {
+		t.Error("stageArchive returns no error for missing collection")
+	}
+
+	mismatch := m
+	mismatch.Collections = []Collection{m.Collections[0], {Name: "venues", Documents: 3}}
+	if _, err := stage(mismatch, files); err == nil {
+		t.Error("stageArchive returns no error for wrong number of documents")
+	}
+
+	ioutil.WriteFile(venues, []byte("{\"_key\":\"1\"}\n{\"_key\":"), 0640)
+	if _, err := stage(m, files); err == nil {
+		t.Error("stageArchive returns no error for invalid document")
+	}
+}
+
+func TestCheckManifest(t *testing.T) {
+	valid := func() Manifest {
+		return Manifest{
+			Format:        BACKUP_FORMAT_VERSION,
+			SchemaVersion: repository.LATEST_SCHEMA_VERSION,
+			Collections:   []Collection{{Name: repository.COLLECTION_SHOPTRAC_MIGRATIONS}, {Name: "purchases"}},
+		}
+	}
+
+	if err := checkManifest(valid()); err != nil {
+		t.Errorf("checkManifest returns error for valid manifest: %s", err)
+	}
+	older := valid()
+	older.SchemaVersion = 3
+	if err := checkManifest(older); err != nil {
+		t.Errorf("checkManifest returns error for older schema version: %s", err)
+	}
+
+	invalid := map[string]func(*Manifest){
+		"newer schema version": func(m *Manifest) { m.SchemaVersion = repository.LATEST_SCHEMA_VERSION + 1 },
+		"missing version":      func(m *Manifest) { m.SchemaVersion = 0 },
+		"unknown format":       func(m *Manifest) { m.Format = BACKUP_FORMAT_VERSION + 1 },
+		"path in name":         func(m *Manifest) { m.Collections[1].Name = "../purchases" },
+		"system collection":    func(m *Manifest) { m.Collections[1].Name = "_users" },
+		"duplicate collection": func(m *Manifest) { m.Collections = append(m.Collections, Collection{Name: "purchases"}) },
+		"missing migrations":   func(m *Manifest) { m.Collections = m.Collections[1:] },
+	}
+	for name, change := range invalid {
+		m := valid()
+		change(&m)
+		if err := checkManifest(m); err == nil {
+			t.Errorf("checkManifest returns no error for %s", name)
+		}
+	}
+}
+
+func TestImageFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shoptrac-backup-test")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{testImageKey, testImageKey + "_thumb", "notes.txt"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte("x"), 0640)
+	}
+
+	files, err := imageFiles(dir)
+	if err != nil {
+		t.Fatalf("imageFiles returns error: %s", err)
+	}
+	names := make([]string, 0)
+	for _, f := range files {
+		names = append(names, f.name)
+	}
+	expected := "images/" + testImageKey + ",images/" + testImageKey + "_thumb"
+	if strings.Join(names, ",") != expected {
+		t.Errorf("imageFiles returns %v instead of expected %s", names, expected)
+	}
+
+	files, err = imageFiles(filepath.Join(dir, "missing"))
+	if err != nil || len(files) != 0 {
+		t.Errorf("imageFiles returns %v (%v) instead of no files for missing directory", files, err)
+	}
+}
@@ -0,0 +1,302 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mandrakey/shoptrac/config"
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+const (
+	MAX_MANIFEST_SIZE = 1024 * 1024
+)
+
+/*
+Restore reads a backup written by Create into the database and runs the migrations afterwards, so backups of older
+versions are brought up to date. The collections of the backup must not contain any documents, unless force is set, in
+which case they are emptied first. Collections which do not exist are created, so an empty database can be restored.
+The whole backup is read and verified before the database is changed. Returns the manifest of the backup.
+*/
+func Restore(r io.Reader, force bool) (*Manifest, error) {
+	log := config.Logger()
+
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid backup: %s", err)
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+
+	m, err := readManifest(tr)
+	if err != nil {
+		return nil, err
+	}
+	err = checkManifest(*m)
+	if err != nil {
+		return nil, err
+	}
+
+	// ----
+	// Read the backup into a temporary directory
+
+	tmp, err := ioutil.TempDir("", "shoptrac-restore")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	images, err := stageArchive(tr, *m, tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	// ----
+	// Check the target
+
+	for _, c := range m.Collections {
+		count, err := repository.CountDocuments(c.Name)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to check collection '%s': %s", c.Name, err)
+		}
+		if count == 0 {
+			continue
+		}
+		if !force {
+			return nil, fmt.Errorf("Collection '%s' is not empty, restore into an empty database or force overwriting", c.Name)
+		}
+
+		log.Infof("Removing %d documents from collection '%s' ...", count, c.Name)
+		err = repository.TruncateCollection(c.Name)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to empty collection '%s': %s", c.Name, err)
+		}
+	}
+
+	// ----
+	// Restore collections and images
+
+	for _, c := range m.Collections {
+		log.Infof("Restoring collection '%s' ...", c.Name)
+
+		count, err := restoreCollection(c.Name, filepath.Join(tmp, filepath.FromSlash(collectionFile(c.Name))))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to restore collection '%s': %s", c.Name, err)
+		}
+		if count != c.Documents {
+			return nil, fmt.Errorf("Restored %d documents into '%s' instead of expected %d", count, c.Name, c.Documents)
+		}
+	}
+
+	imageDir := config.GetAppConfig().ImageDir
+	for _, name := range images {
+		err = restoreImage(imageDir, name, filepath.Join(tmp, IMAGES_DIR, name))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// ----
+	// Bring the schema up to date
+
+	err = repository.RunMigrations()
+	if err != nil {
+		return m, fmt.Errorf("Failed to migrate restored database: %s", err)
+	}
+
+	return m, nil
+}
+
+/*
+stageArchive extracts the collections and images following the manifest into dir and verifies that every collection of
+the manifest is complete. Returns the names of the images.
+*/
+func stageArchive(tr *tar.Reader, m Manifest, dir string) ([]string, error) {
+	log := config.Logger()
+
+	expected := make(map[string]int)
+	for _, c := range m.Collections {
+		expected[collectionFile(c.Name)] = c.Documents
+	}
+	staged := make(map[string]bool)
+	images := make([]string, 0)
+
+	for _, d := range []string{COLLECTIONS_DIR, IMAGES_DIR} {
+		err := os.MkdirAll(filepath.Join(dir, d), 0700)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create temporary directory: %s", err)
+		}
+	}
+
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Invalid backup: %s", err)
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if _, ok := expected[h.Name]; ok && !staged[h.Name] {
+			err = writeFile(filepath.Join(dir, filepath.FromSlash(h.Name)), tr)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid backup: failed to read '%s': %s", h.Name, err)
+			}
+			staged[h.Name] = true
+			continue
+		}
+
+		if d, name := path.Split(h.Name); d == IMAGES_DIR+"/" && isImageFileName(name) {
+			err = writeFile(filepath.Join(dir, IMAGES_DIR, name), tr)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid backup: failed to read '%s': %s", h.Name, err)
+			}
+			images = append(images, name)
+			continue
+		}
+
+		log.Warningf("Ignoring unknown file '%s' in backup", h.Name)
+	}
+
+	for _, c := range m.Collections {
+		file := collectionFile(c.Name)
+		if !staged[file] {
+			return nil, fmt.Errorf("Invalid backup: '%s' is missing", file)
+		}
+
+		count, err := verifyCollection(c.Name, filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid backup: %s", err)
+		}
+		if count != c.Documents {
+			return nil, fmt.Errorf("Invalid backup: '%s' contains %d documents instead of expected %d", file, count, c.Documents)
+		}
+	}
+
+	return images, nil
+}
+
+func verifyCollection(name string, file string) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return repository.VerifyDocuments(name, f)
+}
+
+func restoreCollection(name string, file string) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return repository.RestoreCollection(name, f)
+}
+
+func readManifest(tr *tar.Reader) (*Manifest, error) {
+	h, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid backup: %s", err)
+	}
+	if h.Name != MANIFEST_NAME {
+		return nil, fmt.Errorf("Invalid backup: expected '%s' as first file instead of '%s'", MANIFEST_NAME, h.Name)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(tr, MAX_MANIFEST_SIZE))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read manifest: %s", err)
+	}
+
+	var m Manifest
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid manifest: %s", err)
+	}
+	return &m, nil
+}
+
+/*
+Backups of newer schema versions can not be restored, as this version of shoptrac does not know how to handle them.
+*/
+func checkManifest(m Manifest) error {
+	if m.Format != BACKUP_FORMAT_VERSION {
+		return fmt.Errorf("Unsupported backup format %d, expected %d", m.Format, BACKUP_FORMAT_VERSION)
+	}
+	if m.SchemaVersion < 1 {
+		return fmt.Errorf("Invalid schema version %d in backup", m.SchemaVersion)
+	}
+	if m.SchemaVersion > repository.LATEST_SCHEMA_VERSION {
+		return fmt.Errorf(
+			"Backup has schema version %d, which is newer than the latest version %d supported by shoptrac %s",
+			m.SchemaVersion, repository.LATEST_SCHEMA_VERSION, config.AppVersion,
+		)
+	}
+
+	hasMigrations := false
+	seen := make(map[string]bool)
+	for _, c := range m.Collections {
+		if c.Name == "" || strings.ContainsAny(c.Name, "/\\") || strings.HasPrefix(c.Name, "_") || seen[c.Name] {
+			return fmt.Errorf("Invalid collection name '%s' in backup", c.Name)
+		}
+		seen[c.Name] = true
+		if c.Name == repository.COLLECTION_SHOPTRAC_MIGRATIONS {
+			hasMigrations = true
+		}
+	}
+	if !hasMigrations {
+		return fmt.Errorf("Invalid backup: collection '%s' is missing", repository.COLLECTION_SHOPTRAC_MIGRATIONS)
+	}
+
+	return nil
+}
+
+func restoreImage(dir string, name string, file string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("Failed to create image directory: %s", err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("Failed to restore image '%s': %s", name, err)
+	}
+	defer f.Close()
+
+	err = writeFile(filepath.Join(dir, name), f)
+	if err != nil {
+		return fmt.Errorf("Failed to restore image '%s': %s", name, err)
+	}
+	return nil
+}
+
+func writeFile(file string, r io.Reader) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
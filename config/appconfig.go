@@ -16,6 +16,10 @@ const (
 
 	AccessAllow = 1
 	AccessDeny  = 0
+
+	PeriodTypeCalendar = "calendar"
+	PeriodTypeStartDay = "start-day"
+	PeriodTypeFourWeek = "four-week"
 )
 
 type AccessLevel int
@@ -46,6 +50,15 @@ type AppConfig struct {
 	SessionExpiry           int
 	SessionRememberMeExpiry int
 	PasswordCost            int
+	ImageDir                string `json:"image-dir"`
+	ThumbnailSize           int    `json:"thumbnail-size"`
+	Smtp                    Smtp
+	Webhook                 Webhook
+	ReportDir               string `json:"report-dir"`
+	TimeZone                string `json:"time-zone"`
+	Csv                     Csv
+	Ledger                  Ledger
+	Periods                 map[string]PeriodDefinition
 }
 
 type Database struct {
@@ -57,6 +70,51 @@ type Database struct {
 	DatabaseName string `json:"database"`
 }
 
+type Smtp struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	From     string
+}
+
+/*
+Webhooks are not delivered to loopback, private or link-local addresses, unless their host is one of the AllowedHosts.
+*/
+type Webhook struct {
+	AllowedHosts []string `json:"allowed-hosts"`
+}
+
+/*
+Default format of CSV exports, which may be overridden per request.
+*/
+type Csv struct {
+	Delimiter        string
+	DecimalSeparator string `json:"decimal-separator"`
+}
+
+/*
+Account names and payees of ledger, hledger and beancount exports. The account templates may contain the placeholders
+{category}, {venue} and {shopper}. Accounts maps categories and Payees maps venues, each by name or key, to fixed values.
+*/
+type Ledger struct {
+	Currency       string
+	ExpenseAccount string `json:"expense-account"`
+	PaymentAccount string `json:"payment-account"`
+	Accounts       map[string]string
+	Payees         map[string]string
+}
+
+/*
+Defines how the periods addressed by "<name>:<id>" are laid out. Custom start days must lie between 1 and 28, four-week
+periods are counted from the anchor date.
+*/
+type PeriodDefinition struct {
+	Type     string
+	StartDay int `json:"start-day"`
+	Anchor   string
+}
+
 type AccessPolicy struct {
 	Default AccessLevel
 	Rules   []AccessRule
@@ -91,6 +149,23 @@ func GetAppConfig() *AppConfig {
 			SessionExpiry:           30,           // 30 minutes
 			SessionRememberMeExpiry: 60 * 24 * 30, // 30 days
 			PasswordCost:            14,
+			ImageDir:                "./images",
+			ThumbnailSize:           128,
+			Smtp: Smtp{
+				Host: "localhost",
+				Port: 25,
+				From: "shoptrac@localhost",
+			},
+			ReportDir: "./reports",
+			Csv: Csv{
+				Delimiter:        ",",
+				DecimalSeparator: ".",
+			},
+			Ledger: Ledger{
+				Currency:       "EUR",
+				ExpenseAccount: "Expenses:{category}",
+				PaymentAccount: "Assets:Cash",
+			},
 		}
 	}
 
@@ -14,6 +14,7 @@ require (
 	github.com/unknwon/com v1.0.1 // indirect
 	github.com/urfave/cli v1.22.5
 	golang.org/x/crypto v0.0.0-20220112180741-5e0467b6c7ce
+	golang.org/x/image v0.0.0-20211028202545-6944b10bf410
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
 	gopkg.in/ini.v1 v1.66.2 // indirect
 	gopkg.in/macaron.v1 v1.4.0
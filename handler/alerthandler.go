@@ -0,0 +1,170 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/macaron.v1"
+
+	"github.com/mandrakey/shoptrac/config"
+	"github.com/mandrakey/shoptrac/notification"
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+func GetAlerts(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	sess := GetActiveSession(ctx)
+	rules, err := repository.GetAlertRulesForUser(sess.UserKey)
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(rules)
+}
+
+func PutAlert(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	sess := GetActiveSession(ctx)
+
+	body, err := ctx.Req.Body().Bytes()
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to read request: %s", err))
+	}
+
+	var data map[string]interface{}
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to parse JSON: %s", err))
+	}
+
+	// ----
+	// Extract data
+
+	rule := repository.AlertRule{UserKey: sess.UserKey}
+
+	ruleType, ok := data["type"].(string)
+	if !ok || (ruleType != repository.ALERT_TYPE_BUDGET && ruleType != repository.ALERT_TYPE_PURCHASE) {
+		return 400, ErrorResponse("Parameter 'type' is required and must be 'budget' or 'purchase'")
+	}
+	rule.Type = ruleType
+
+	if data["category"] != nil {
+		category, ok := data["category"].(string)
+		if !ok {
+			return 400, ErrorResponse("The parameter 'category' must be a string")
+		}
+		rule.Category = category
+	}
+
+	threshold, ok := data["threshold"].(float64)
+	if !ok || threshold <= 0 {
+		return 400, ErrorResponse("Parameter 'threshold' is required and must be a positive number")
+	}
+	rule.Threshold = threshold
+
+	channel, ok := data["channel"].(string)
+	if !ok {
+		return 400, ErrorResponse("Parameter 'channel' is required and must be a string")
+	}
+	_, err = notification.GetChannel(channel)
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+	rule.Channel = channel
+
+	target, ok := data["target"].(string)
+	if !ok || target == "" {
+		return 400, ErrorResponse("Parameter 'target' is required and must be a non-empty string")
+	}
+	err = notification.ValidateTarget(channel, target)
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+	rule.Target = target
+
+	// ----
+	// Create rule
+
+	created, err := repository.AddAlertRule(rule)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to add alert rule: %s", err))
+	}
+
+	return 200, SuccessResponse(created)
+}
+
+/*
+PostAlertTest delivers a test notification through the channel of the alert rule with the provided key.
+*/
+func PostAlertTest(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	log := config.Logger()
+	sess := GetActiveSession(ctx)
+
+	rule, err := repository.GetAlertRule(ctx.Params(":key"))
+	if err != nil || rule.UserKey != sess.UserKey {
+		return 404, ErrorResponse("Alert rule not found")
+	}
+
+	err = notification.ValidateTarget(rule.Channel, rule.Target)
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+
+	err = notification.Send(rule.Channel, rule.Target, notification.Notification{
+		Subject: "Shoptrac test notification",
+		Body:    "This is a test notification for one of your shoptrac alert rules.",
+		Data:    map[string]interface{}{"rule": rule.Key},
+	})
+	if err != nil {
+		log.Errorf("Failed to send test notification for alert rule %s: %s", rule.Key, err)
+		return 500, ErrorResponse(fmt.Sprintf("Failed to send test notification: %s", err))
+	}
+
+	return 204, ""
+}
+
+func DeleteAlert(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	sess := GetActiveSession(ctx)
+
+	key := ctx.Params(":key")
+	if key == "" {
+		return 400, ErrorResponse("No alert rule key specified")
+	}
+
+	err := repository.DeleteAlertRule(sess.UserKey, key)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to delete alert rule: %s", err))
+	}
+	return 200, SuccessResponse(nil)
+}
+
+func OptionsAlerts(ctx *macaron.Context) (int, string) {
+	ctx.Resp.Header().Add(
+		"Access-Control-Allow-Methods",
+		"GET, POST, PUT, DELETE, OPTIONS",
+	)
+	ctx.Resp.Header().Add(
+		"Access-Control-Allow-Headers",
+		"Content-Type, Authentication",
+	)
+	return 200, ""
+}
@@ -0,0 +1,237 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"gopkg.in/macaron.v1"
+
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+func GetBudgets(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	budgets, err := repository.GetBudgets()
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(budgets)
+}
+
+func GetBudgetReport(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	// A period replaces month and year
+	period, err := extractPeriodQuery(ctx)
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+	if period != nil {
+		report, err := repository.GetBudgetReportForRange(*period)
+		if err != nil {
+			return 500, ErrorResponse(err.Error())
+		}
+		return 200, SuccessResponse(report)
+	}
+
+	// ----
+	// Get month and year parameters
+
+	month, err := strconv.ParseInt(ctx.Params(":month"), 10, 0)
+	if err != nil || month < 1 || month > 12 {
+		return 400, ErrorResponse("Parameter 'month' is required and must be a number between 1 and 12")
+	}
+
+	year, err := strconv.ParseInt(ctx.Params(":year"), 10, 0)
+	if err != nil {
+		return 400, ErrorResponse(fmt.Sprintf("Failed to parse year value: %s", err))
+	}
+
+	// ----
+	// Get report
+
+	report, err := repository.GetBudgetReport(int(month), int(year))
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(report)
+}
+
+func PutBudget(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	body, err := ctx.Req.Body().Bytes()
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to read request: %s", err))
+	}
+
+	var data map[string]interface{}
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to parse JSON: %s", err))
+	}
+
+	// ----
+	// Extract data
+
+	budget := repository.Budget{}
+
+	// An empty or missing category denotes the overall budget
+	if data["category"] != nil {
+		category, ok := data["category"].(string)
+		if !ok {
+			return 400, ErrorResponse("The parameter 'category' must be a string")
+		}
+		budget.Category = category
+	}
+
+	amount, ok := data["amount"].(string)
+	if !ok {
+		return 400, ErrorResponse("Parameter 'amount' is required and must be a string")
+	}
+	budget.Amount, err = formatBudgetAmount(amount)
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+
+	budget.Template, _ = data["template"].(bool)
+	if !budget.Template {
+		month, ok := data["month"].(float64)
+		if !ok {
+			return 400, ErrorResponse("Parameter 'month' is required for non-template budgets and must be a number")
+		}
+		budget.Month = int(month)
+
+		year, ok := data["year"].(float64)
+		if !ok {
+			return 400, ErrorResponse("Parameter 'year' is required for non-template budgets and must be a number")
+		}
+		budget.Year = int(year)
+	}
+
+	// ----
+	// Create budget
+
+	created, err := repository.AddBudget(budget)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to add budget: %s", err))
+	}
+
+	return 200, SuccessResponse(created)
+}
+
+func PostBudget(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	key := ctx.Params(":key")
+	if key == "" {
+		return 400, ErrorResponse("No budget key specified")
+	}
+
+	body, err := ctx.Req.Body().Bytes()
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to read request: %s", err))
+	}
+
+	var data map[string]interface{}
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to parse JSON: %s", err))
+	}
+
+	// ----
+	// Extract updated data, only the amount of a budget may change
+
+	values := make(map[string]interface{})
+
+	if data["amount"] != nil {
+		amount, ok := data["amount"].(string)
+		if !ok {
+			return 400, ErrorResponse("The parameter 'amount' must be a string")
+		}
+		values["amount"], err = formatBudgetAmount(amount)
+		if err != nil {
+			return 400, ErrorResponse(err.Error())
+		}
+	}
+
+	if len(values) == 0 {
+		return 200, SuccessResponse(nil)
+	}
+
+	// ----
+	// Execute the update
+
+	err = repository.UpdateBudget(key, &values)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to update budget: %s", err))
+	}
+
+	budget, err := repository.GetBudget(key)
+	if err != nil {
+		return 200, SuccessResponse(nil)
+	}
+
+	return 200, SuccessResponse(budget)
+}
+
+func DeleteBudget(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	key := ctx.Params(":key")
+	if key == "" {
+		return 400, ErrorResponse("No budget key specified")
+	}
+
+	err := repository.DeleteBudget(key)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to delete budget: %s", err))
+	}
+	return 200, SuccessResponse(nil)
+}
+
+/*
+Formats the amount of a budget, which must be greater than zero for the percentage used to be meaningful.
+*/
+func formatBudgetAmount(amount string) (string, error) {
+	formatted, err := FormatSum(amount)
+	if err != nil {
+		return "", fmt.Errorf("Failed to format the value for parameter 'amount'")
+	}
+	if v, _ := strconv.ParseFloat(formatted, 64); v <= 0 {
+		return "", fmt.Errorf("Parameter 'amount' must be greater than 0")
+	}
+
+	return formatted, nil
+}
+
+func OptionsBudget(ctx *macaron.Context) (int, string) {
+	ctx.Resp.Header().Add(
+		"Access-Control-Allow-Methods",
+		"GET, POST, PUT, DELETE, OPTIONS",
+	)
+	ctx.Resp.Header().Add(
+		"Access-Control-Allow-Headers",
+		"Content-Type, Authentication",
+	)
+	return 200, ""
+}
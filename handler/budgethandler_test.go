@@ -0,0 +1,23 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package handler
+
+import (
+	"testing"
+)
+
+func TestFormatBudgetAmount(t *testing.T) {
+	res, err := formatBudgetAmount("250")
+	if err != nil || res != "250.00" {
+		t.Errorf("'250' returns '%s' (%v) instead of expected '250.00'", res, err)
+	}
+
+	for _, amount := range []string{"0", "0.001", "-10", "no number"} {
+		if _, err := formatBudgetAmount(amount); err == nil {
+			t.Errorf("'%s' returns no error", amount)
+		}
+	}
+}
@@ -0,0 +1,677 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/macaron.v1"
+
+	"github.com/mandrakey/shoptrac/config"
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+const (
+	EXPORT_FORMAT_NDJSON = "ndjson"
+	EXPORT_FORMAT_JSON   = "json"
+
+	LEDGER_FORMAT_LEDGER    = "ledger"
+	LEDGER_FORMAT_HLEDGER   = "hledger"
+	LEDGER_FORMAT_BEANCOUNT = "beancount"
+)
+
+/*
+GetPurchasesExport streams purchases straight from the database cursor to the client. The format is either NDJSON (one
+purchase per line, the default) or a JSON document shaped like the 'purchases_unfiltered' response. Optional parameters
+are '?year=' to export a single year, '?period=' to limit the dates and '?fields=' with a comma separated list of
+attributes to include.
+*/
+func GetPurchasesExport(ctx *macaron.Context) {
+	log := config.Logger()
+
+	if !IsValidSession(ctx) {
+		writeUnauthorizedResponse(ctx)
+		return
+	}
+
+	// ----
+	// Get parameters
+
+	format := ctx.Query("format")
+	if format == "" {
+		format = EXPORT_FORMAT_NDJSON
+	}
+	if format != EXPORT_FORMAT_NDJSON && format != EXPORT_FORMAT_JSON {
+		writeErrorResponse(ctx, 400, "Parameter 'format' must be one of 'ndjson' or 'json'")
+		return
+	}
+
+	year := 0
+	if ctx.Query("year") != "" {
+		y, err := strconv.ParseInt(ctx.Query("year"), 10, 0)
+		if err != nil || y < 1 {
+			writeErrorResponse(ctx, 400, "Parameter 'year' must be a number")
+			return
+		}
+		year = int(y)
+	}
+
+	from, to := "", ""
+	period, err := extractPeriodQuery(ctx)
+	if err != nil {
+		writeErrorResponse(ctx, 400, err.Error())
+		return
+	}
+	if period != nil {
+		from, to = period.FromDb(), period.ToDb()
+	}
+
+	fields, err := extractExportFields(ctx.Query("fields"))
+	if err != nil {
+		writeErrorResponse(ctx, 400, err.Error())
+		return
+	}
+
+	years := make([]int, 0)
+	if format == EXPORT_FORMAT_JSON {
+		years, err = repository.GetPurchaseYears(year, from, to)
+		if err != nil {
+			writeErrorResponse(ctx, 500, err.Error())
+			return
+		}
+	}
+
+	// ----
+	// Stream purchases
+
+	if format == EXPORT_FORMAT_NDJSON {
+		ctx.Resp.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		ctx.Resp.Header().Set("Content-Type", "application/json")
+	}
+	ctx.Resp.WriteHeader(200)
+
+	w := newPurchaseStreamWriter(ctx.Resp, format)
+	err = w.begin(years)
+	if err == nil {
+		err = repository.StreamPurchases(year, from, to, fields, w.write)
+	}
+	if err == nil {
+		err = w.end()
+	}
+
+	// The status has been sent already, so the response just ends prematurely
+	if err != nil {
+		log.Errorf("Failed to export purchases: %s", err)
+	}
+}
+
+/*
+GetPurchasesCsvExport streams purchases as CSV with the names of categories, venues and shoppers instead of their keys.
+Optional parameters are '?from=' and '?to=' or '?period=' to limit the dates, '?columns=' with a comma separated list
+of columns and '?delimiter=' and '?decimal=' to override the configured delimiter and decimal separator.
+*/
+func GetPurchasesCsvExport(ctx *macaron.Context) {
+	log := config.Logger()
+	cfg := config.GetAppConfig()
+
+	if !IsValidSession(ctx) {
+		writeUnauthorizedResponse(ctx)
+		return
+	}
+
+	// ----
+	// Get parameters
+
+	from, err := extractDateQuery(ctx, "from")
+	if err != nil {
+		writeErrorResponse(ctx, 400, err.Error())
+		return
+	}
+	to, err := extractDateQuery(ctx, "to")
+	if err != nil {
+		writeErrorResponse(ctx, 400, err.Error())
+		return
+	}
+
+	period, err := extractPeriodQuery(ctx)
+	if err != nil {
+		writeErrorResponse(ctx, 400, err.Error())
+		return
+	}
+	if period != nil {
+		from, to = period.FromDb(), period.ToDb()
+	}
+
+	columns, err := extractCsvColumns(ctx.Query("columns"))
+	if err != nil {
+		writeErrorResponse(ctx, 400, err.Error())
+		return
+	}
+
+	delimiter := ctx.Query("delimiter")
+	if delimiter == "" {
+		delimiter = cfg.Csv.Delimiter
+	}
+	decimal := ctx.Query("decimal")
+	if decimal == "" {
+		decimal = cfg.Csv.DecimalSeparator
+	}
+	comma, err := extractCsvFormat(delimiter, decimal)
+	if err != nil {
+		writeErrorResponse(ctx, 400, err.Error())
+		return
+	}
+
+	// ----
+	// Stream purchases
+
+	ctx.Resp.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	ctx.Resp.Header().Set("Content-Disposition", "attachment; filename=\"purchases.csv\"")
+	ctx.Resp.WriteHeader(200)
+
+	w := newPurchaseCsvWriter(ctx.Resp, columns, comma, decimal)
+	err = w.begin()
+	if err == nil {
+		err = repository.StreamPurchasesWithNames(from, to, w.write)
+	}
+	if err == nil {
+		err = w.end()
+	}
+
+	// The status has been sent already, so the response just ends prematurely
+	if err != nil {
+		log.Errorf("Failed to export purchases as CSV: %s", err)
+	}
+}
+
+/*
+GetPurchasesLedgerExport streams purchases as transactions of a plain text accounting tool. '?format=' is one of
+'ledger' (the default), 'hledger' or 'beancount', '?from=' and '?to=' or '?period=' limit the dates. Accounts and payees
+are mapped as configured, every transaction carries the purchase key as 'id', so re-exports can be compared.
+*/
+func GetPurchasesLedgerExport(ctx *macaron.Context) {
+	log := config.Logger()
+
+	if !IsValidSession(ctx) {
+		writeUnauthorizedResponse(ctx)
+		return
+	}
+
+	// ----
+	// Get parameters
+
+	format := ctx.Query("format")
+	if format == "" {
+		format = LEDGER_FORMAT_LEDGER
+	}
+	extension, ok := ledgerExtensions[format]
+	if !ok {
+		writeErrorResponse(ctx, 400, "Parameter 'format' must be one of 'ledger', 'hledger' or 'beancount'")
+		return
+	}
+
+	from, err := extractDateQuery(ctx, "from")
+	if err != nil {
+		writeErrorResponse(ctx, 400, err.Error())
+		return
+	}
+	to, err := extractDateQuery(ctx, "to")
+	if err != nil {
+		writeErrorResponse(ctx, 400, err.Error())
+		return
+	}
+
+	period, err := extractPeriodQuery(ctx)
+	if err != nil {
+		writeErrorResponse(ctx, 400, err.Error())
+		return
+	}
+	if period != nil {
+		from, to = period.FromDb(), period.ToDb()
+	}
+
+	// ----
+	// Stream purchases
+
+	ctx.Resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	ctx.Resp.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"purchases.%s\"", extension))
+	ctx.Resp.WriteHeader(200)
+
+	w := newPurchaseLedgerWriter(ctx.Resp, format, config.GetAppConfig().Ledger)
+	err = repository.StreamPurchasesWithNames(from, to, w.write)
+	if err == nil {
+		err = w.end()
+	}
+
+	// The status has been sent already, so the response just ends prematurely
+	if err != nil {
+		log.Errorf("Failed to export purchases as %s: %s", format, err)
+	}
+}
+
+/*
+Parses a comma separated list of export fields. All exportable fields are returned for an empty list.
+*/
+func extractExportFields(value string) ([]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return repository.ExportFields(), nil
+	}
+
+	fields := make([]string, 0)
+	for _, f := range strings.Split(value, ",") {
+		f = strings.TrimSpace(f)
+		if !repository.IsExportField(f) {
+			return nil, fmt.Errorf("Field '%s' can not be exported, allowed fields are: %s", f, strings.Join(repository.ExportFields(), ", "))
+		}
+		fields = append(fields, f)
+	}
+
+	return fields, nil
+}
+
+/*
+Parses a comma separated list of CSV columns. The default columns are returned for an empty list.
+*/
+func extractCsvColumns(value string) ([]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return csvDefaultColumns, nil
+	}
+
+	columns := make([]string, 0)
+	for _, c := range strings.Split(value, ",") {
+		c = strings.TrimSpace(c)
+		if _, ok := csvColumns[c]; !ok {
+			return nil, fmt.Errorf("Column '%s' can not be exported, allowed columns are: %s", c, strings.Join(csvColumnNames, ", "))
+		}
+		columns = append(columns, c)
+	}
+
+	return columns, nil
+}
+
+/*
+Checks the delimiter and decimal separator and returns the delimiter as rune. The delimiter must be a single character
+other than the decimal separator, which must be a dot or a comma.
+*/
+func extractCsvFormat(delimiter string, decimal string) (rune, error) {
+	if decimal != "." && decimal != "," {
+		return 0, fmt.Errorf("Decimal separator must be '.' or ','")
+	}
+
+	comma, size := utf8.DecodeRuneInString(delimiter)
+	if size == 0 || size != len(delimiter) || comma == utf8.RuneError {
+		return 0, fmt.Errorf("Delimiter must be a single character")
+	}
+	if comma == '"' || comma == '\r' || comma == '\n' || delimiter == decimal {
+		return 0, fmt.Errorf("Delimiter '%s' can not be used", delimiter)
+	}
+
+	return comma, nil
+}
+
+/*
+Writes a JSON response. Streaming handlers write to the response themselves instead of returning status and body, so
+they need to send errors the same way.
+*/
+func writeResponse(ctx *macaron.Context, code int, body string) {
+	ctx.Resp.Header().Set("Content-Type", "application/json")
+	ctx.Resp.WriteHeader(code)
+	ctx.Resp.Write([]byte(body))
+}
+
+func writeErrorResponse(ctx *macaron.Context, code int, message string) {
+	writeResponse(ctx, code, ErrorResponse(message))
+}
+
+func writeUnauthorizedResponse(ctx *macaron.Context) {
+	code, body := UnauthorizedResponse()
+	writeResponse(ctx, code, body)
+}
+
+/*
+Writes a stream of purchases in one of the export formats, flushing the output after every batch.
+*/
+type purchaseStreamWriter struct {
+	w      io.Writer
+	format string
+	count  int
+}
+
+func newPurchaseStreamWriter(w io.Writer, format string) *purchaseStreamWriter {
+	return &purchaseStreamWriter{w: w, format: format}
+}
+
+func (s *purchaseStreamWriter) begin(years []int) error {
+	if s.format != EXPORT_FORMAT_JSON {
+		return nil
+	}
+
+	meta, err := json.Marshal(map[string]interface{}{"years": years})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.w, "{\"meta\":%s,\"purchases\":[", meta)
+	return err
+}
+
+func (s *purchaseStreamWriter) write(p map[string]interface{}) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	if s.format == EXPORT_FORMAT_JSON {
+		if s.count > 0 {
+			data = append([]byte(","), data...)
+		}
+	} else {
+		data = append(data, '\n')
+	}
+
+	_, err = s.w.Write(data)
+	if err != nil {
+		return err
+	}
+
+	s.count++
+	if s.count%repository.EXPORT_BATCH_SIZE == 0 {
+		s.flush()
+	}
+	return nil
+}
+
+func (s *purchaseStreamWriter) end() error {
+	if s.format == EXPORT_FORMAT_JSON {
+		_, err := s.w.Write([]byte("]}"))
+		if err != nil {
+			return err
+		}
+	}
+
+	s.flush()
+	return nil
+}
+
+func (s *purchaseStreamWriter) flush() {
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// ----
+// CSV
+
+var csvDefaultColumns = []string{"date", "category", "venue", "shopper", "sum"}
+
+// All columns of the CSV export, in the order they are listed in errors
+var csvColumnNames = []string{
+	"key", "date", "month", "year", "category", "category_key", "venue", "venue_key", "shopper", "shopper_key", "sum", "items",
+}
+
+var csvColumns = map[string]func(p repository.ExportPurchase, decimal string) string{
+	"key":          func(p repository.ExportPurchase, _ string) string { return p.Key },
+	"date":         func(p repository.ExportPurchase, _ string) string { return p.Date },
+	"month":        func(p repository.ExportPurchase, _ string) string { return strconv.Itoa(p.Month) },
+	"year":         func(p repository.ExportPurchase, _ string) string { return strconv.Itoa(p.Year) },
+	"category":     func(p repository.ExportPurchase, _ string) string { return nameOrKey(p.CategoryName, p.Category) },
+	"category_key": func(p repository.ExportPurchase, _ string) string { return p.Category },
+	"venue":        func(p repository.ExportPurchase, _ string) string { return nameOrKey(p.VenueName, p.Venue) },
+	"venue_key":    func(p repository.ExportPurchase, _ string) string { return p.Venue },
+	"shopper":      func(p repository.ExportPurchase, _ string) string { return nameOrKey(p.ShopperName, p.Shopper) },
+	"shopper_key":  func(p repository.ExportPurchase, _ string) string { return p.Shopper },
+	"sum":          func(p repository.ExportPurchase, decimal string) string { return formatDecimal(p.Sum, decimal) },
+	"items":        func(p repository.ExportPurchase, _ string) string { return strconv.Itoa(len(p.Items)) },
+}
+
+func formatDecimal(sum string, decimal string) string {
+	return strings.Replace(sum, ".", decimal, 1)
+}
+
+/*
+Returns the name, or the key if the referenced document does not exist anymore.
+*/
+func nameOrKey(name string, key string) string {
+	if name == "" {
+		return key
+	}
+	return name
+}
+
+/*
+Writes purchases as CSV rows, flushing the output after every batch.
+*/
+type purchaseCsvWriter struct {
+	w       io.Writer
+	csv     *csv.Writer
+	columns []string
+	decimal string
+	count   int
+}
+
+func newPurchaseCsvWriter(w io.Writer, columns []string, comma rune, decimal string) *purchaseCsvWriter {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	return &purchaseCsvWriter{w: w, csv: cw, columns: columns, decimal: decimal}
+}
+
+func (s *purchaseCsvWriter) begin() error {
+	return s.csv.Write(s.columns)
+}
+
+func (s *purchaseCsvWriter) write(p repository.ExportPurchase) error {
+	row := make([]string, len(s.columns))
+	for i, c := range s.columns {
+		row[i] = csvColumns[c](p, s.decimal)
+	}
+
+	err := s.csv.Write(row)
+	if err != nil {
+		return err
+	}
+
+	s.count++
+	if s.count%repository.EXPORT_BATCH_SIZE == 0 {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *purchaseCsvWriter) end() error {
+	return s.flush()
+}
+
+func (s *purchaseCsvWriter) flush() error {
+	s.csv.Flush()
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return s.csv.Error()
+}
+
+func OptionsExport(ctx *macaron.Context) (int, string) {
+	ctx.Resp.Header().Add(
+		"Access-Control-Allow-Methods",
+		"GET, OPTIONS",
+	)
+	ctx.Resp.Header().Add(
+		"Access-Control-Allow-Headers",
+		"Content-Type, Authentication",
+	)
+	return 200, ""
+}
+
+// ----
+// Plain text accounting
+
+var ledgerExtensions = map[string]string{
+	LEDGER_FORMAT_LEDGER:    "ledger",
+	LEDGER_FORMAT_HLEDGER:   "journal",
+	LEDGER_FORMAT_BEANCOUNT: "beancount",
+}
+
+/*
+Writes purchases as transactions booking the sum from the payment account to the expense account. Ledger and hledger
+share the same syntax. Beancount requires accounts to be opened, so an open directive is written before the first
+transaction using an account.
+*/
+type purchaseLedgerWriter struct {
+	w       io.Writer
+	format  string
+	mapping config.Ledger
+	opened  map[string]bool
+	count   int
+}
+
+func newPurchaseLedgerWriter(w io.Writer, format string, mapping config.Ledger) *purchaseLedgerWriter {
+	return &purchaseLedgerWriter{w: w, format: format, mapping: mapping, opened: make(map[string]bool)}
+}
+
+func (s *purchaseLedgerWriter) write(p repository.ExportPurchase) error {
+	expense := s.expenseAccount(p)
+	payment := s.paymentAccount(p)
+	payee := s.payee(p)
+	amount := fmt.Sprintf("%s %s", p.Sum, s.mapping.Currency)
+
+	var b strings.Builder
+	if s.format == LEDGER_FORMAT_BEANCOUNT {
+		expense = beancountAccount(expense)
+		payment = beancountAccount(payment)
+		for _, account := range []string{expense, payment} {
+			if !s.opened[account] {
+				fmt.Fprintf(&b, "%s open %s\n", p.Date, account)
+				s.opened[account] = true
+			}
+		}
+
+		fmt.Fprintf(&b, "%s * %s %s\n", p.Date, beancountString(payee), beancountString(nameOrKey(p.CategoryName, p.Category)))
+		fmt.Fprintf(&b, "  id: %s\n", beancountString(p.Key))
+		fmt.Fprintf(&b, "  shopper: %s\n", beancountString(nameOrKey(p.ShopperName, p.Shopper)))
+		fmt.Fprintf(&b, "  %s  %s\n", expense, amount)
+		fmt.Fprintf(&b, "  %s\n\n", payment)
+	} else {
+		fmt.Fprintf(&b, "%s * %s\n", p.Date, payee)
+		fmt.Fprintf(&b, "    ; id: %s\n", p.Key)
+		fmt.Fprintf(&b, "    ; shopper: %s\n", ledgerName(nameOrKey(p.ShopperName, p.Shopper)))
+		fmt.Fprintf(&b, "    %s  %s\n", expense, amount)
+		fmt.Fprintf(&b, "    %s\n\n", payment)
+	}
+
+	_, err := io.WriteString(s.w, b.String())
+	if err != nil {
+		return err
+	}
+
+	s.count++
+	if s.count%repository.EXPORT_BATCH_SIZE == 0 {
+		s.flush()
+	}
+	return nil
+}
+
+func (s *purchaseLedgerWriter) end() error {
+	s.flush()
+	return nil
+}
+
+func (s *purchaseLedgerWriter) flush() {
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *purchaseLedgerWriter) expenseAccount(p repository.ExportPurchase) string {
+	if account, ok := lookupMapping(s.mapping.Accounts, p.CategoryName, p.Category); ok {
+		return account
+	}
+	return expandAccount(s.mapping.ExpenseAccount, p)
+}
+
+func (s *purchaseLedgerWriter) paymentAccount(p repository.ExportPurchase) string {
+	return expandAccount(s.mapping.PaymentAccount, p)
+}
+
+func (s *purchaseLedgerWriter) payee(p repository.ExportPurchase) string {
+	if payee, ok := lookupMapping(s.mapping.Payees, p.VenueName, p.Venue); ok {
+		return ledgerName(payee)
+	}
+	return ledgerName(nameOrKey(p.VenueName, p.Venue))
+}
+
+/*
+Looks up a mapping by name first, then by key.
+*/
+func lookupMapping(mapping map[string]string, name string, key string) (string, bool) {
+	if value, ok := mapping[name]; ok && name != "" {
+		return value, true
+	}
+	value, ok := mapping[key]
+	return value, ok
+}
+
+/*
+Replaces the placeholders of an account template. Colons in names are replaced, so they do not create sub accounts.
+*/
+func expandAccount(template string, p repository.ExportPurchase) string {
+	component := func(name string) string {
+		return strings.Replace(ledgerName(name), ":", "-", -1)
+	}
+	r := strings.NewReplacer(
+		"{category}", component(nameOrKey(p.CategoryName, p.Category)),
+		"{venue}", component(nameOrKey(p.VenueName, p.Venue)),
+		"{shopper}", component(nameOrKey(p.ShopperName, p.Shopper)),
+	)
+	return r.Replace(template)
+}
+
+/*
+Collapses whitespace, as two spaces separate accounts from amounts and newlines end entries.
+*/
+func ledgerName(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+/*
+Beancount account components must start with a capital letter or digit and may only contain letters, digits and dashes.
+The first letter is capitalized, runs of other characters are replaced by a single dash.
+*/
+func beancountAccount(account string) string {
+	components := strings.Split(account, ":")
+	for i, c := range components {
+		var b strings.Builder
+		dash := false
+		for _, r := range strings.TrimSpace(c) {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				b.WriteRune(r)
+				dash = false
+			} else if !dash {
+				b.WriteRune('-')
+				dash = true
+			}
+		}
+
+		name := strings.Trim(b.String(), "-")
+		if name == "" {
+			name = "Unknown"
+		}
+		first, size := utf8.DecodeRuneInString(name)
+		components[i] = string(unicode.ToUpper(first)) + name[size:]
+	}
+	return strings.Join(components, ":")
+}
+
+func beancountString(value string) string {
+	r := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", " ")
+	return "\"" + r.Replace(value) + "\""
+}
@@ -0,0 +1,216 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gopkg.in/macaron.v1"
+
+	"github.com/mandrakey/shoptrac/config"
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+func TestPurchaseStreamWriter(t *testing.T) {
+	purchases := []map[string]interface{}{
+		{"_key": "1", "sum": "1.00"},
+		{"_key": "2", "sum": "2.50"},
+	}
+
+	var buf bytes.Buffer
+	w := newPurchaseStreamWriter(&buf, EXPORT_FORMAT_NDJSON)
+	w.begin(nil)
+	for _, p := range purchases {
+		w.write(p)
+	}
+	w.end()
+
+	expected := "{\"_key\":\"1\",\"sum\":\"1.00\"}\n{\"_key\":\"2\",\"sum\":\"2.50\"}\n"
+	if buf.String() != expected {
+		t.Errorf("NDJSON export returns '%s' instead of expected '%s'", buf.String(), expected)
+	}
+
+	buf.Reset()
+	w = newPurchaseStreamWriter(&buf, EXPORT_FORMAT_JSON)
+	w.begin([]int{2025, 2026})
+	for _, p := range purchases {
+		w.write(p)
+	}
+	w.end()
+
+	var doc struct {
+		Meta struct {
+			Years []int `json:"years"`
+		} `json:"meta"`
+		Purchases []map[string]interface{} `json:"purchases"`
+	}
+	err := json.Unmarshal(buf.Bytes(), &doc)
+	if err != nil {
+		t.Fatalf("JSON export returns invalid document '%s': %s", buf.String(), err)
+	}
+	if len(doc.Meta.Years) != 2 || len(doc.Purchases) != 2 || doc.Purchases[1]["sum"] != "2.50" {
+		t.Errorf("JSON export returns unexpected document '%s'", buf.String())
+	}
+}
+
+func TestExtractExportFields(t *testing.T) {
+	fields, err := extractExportFields(" date, sum ")
+	if err != nil || len(fields) != 2 || fields[0] != "date" || fields[1] != "sum" {
+		t.Errorf("extractExportFields returns %v, %v instead of expected [date sum]", fields, err)
+	}
+
+	_, err = extractExportFields("date,password")
+	if err == nil {
+		t.Error("extractExportFields accepts unknown field")
+	}
+
+	fields, _ = extractExportFields("")
+	if len(fields) == 0 {
+		t.Error("extractExportFields returns no fields by default")
+	}
+}
+
+func TestPurchaseCsvWriter(t *testing.T) {
+	purchases := []repository.ExportPurchase{
+		{
+			Purchase:     repository.Purchase{Key: "1", Category: "c1", Venue: "v1", Shopper: "s1", Date: "2026-03-01", Sum: "12.50"},
+			CategoryName: "Food", VenueName: "Bäcker; Müller", ShopperName: "Alex",
+		},
+		{
+			Purchase:     repository.Purchase{Key: "2", Category: "c2", Venue: "v1", Shopper: "s1", Date: "2026-03-02", Sum: "3.00"},
+			CategoryName: "", VenueName: "Bäcker; Müller", ShopperName: "Alex",
+		},
+	}
+
+	var buf bytes.Buffer
+	w := newPurchaseCsvWriter(&buf, []string{"date", "category", "venue", "sum"}, ';', ",")
+	w.begin()
+	for _, p := range purchases {
+		w.write(p)
+	}
+	w.end()
+
+	expected := "date;category;venue;sum\n2026-03-01;Food;\"Bäcker; Müller\";12,50\n2026-03-02;c2;\"Bäcker; Müller\";3,00\n"
+	if buf.String() != expected {
+		t.Errorf("CSV export returns '%s' instead of expected '%s'", buf.String(), expected)
+	}
+}
+
+func TestExtractCsvColumns(t *testing.T) {
+	columns, err := extractCsvColumns("")
+	if err != nil || strings.Join(columns, ",") != "date,category,venue,shopper,sum" {
+		t.Errorf("extractCsvColumns returns %v instead of expected default columns", columns)
+	}
+
+	columns, err = extractCsvColumns("key, venue_key ,sum")
+	if err != nil || strings.Join(columns, ",") != "key,venue_key,sum" {
+		t.Errorf("extractCsvColumns returns %v instead of expected columns", columns)
+	}
+
+	_, err = extractCsvColumns("date,password")
+	if err == nil {
+		t.Error("extractCsvColumns returns no error for unknown column")
+	}
+}
+
+func TestExtractCsvFormat(t *testing.T) {
+	comma, err := extractCsvFormat(";", ",")
+	if err != nil || comma != ';' {
+		t.Errorf("extractCsvFormat returns '%c' (%v) instead of expected ';'", comma, err)
+	}
+	comma, err = extractCsvFormat("\t", ".")
+	if err != nil || comma != '\t' {
+		t.Errorf("extractCsvFormat returns '%c' (%v) instead of expected tab", comma, err)
+	}
+
+	for _, f := range [][2]string{{",", ","}, {";;", ","}, {"", "."}, {"\"", "."}, {";", "'"}} {
+		if _, err := extractCsvFormat(f[0], f[1]); err == nil {
+			t.Errorf("extractCsvFormat returns no error for delimiter '%s' and decimal separator '%s'", f[0], f[1])
+		}
+	}
+}
+
+func TestPurchaseLedgerWriter(t *testing.T) {
+	mapping := config.Ledger{
+		Currency:       "EUR",
+		ExpenseAccount: "Expenses:{category}",
+		PaymentAccount: "Assets:Cash:{shopper}",
+		Accounts:       map[string]string{"c2": "Expenses:Household"},
+		Payees:         map[string]string{"Bakery": "Miller's Bakery"},
+	}
+	purchases := []repository.ExportPurchase{
+		{
+			Purchase:     repository.Purchase{Key: "k1", Category: "c1", Venue: "v1", Shopper: "s1", Date: "2026-03-01", Sum: "12.50"},
+			CategoryName: "Food: fresh", VenueName: "Bakery", ShopperName: "Alex",
+		},
+		{
+			Purchase:     repository.Purchase{Key: "k2", Category: "c2", Venue: "v2", Shopper: "s1", Date: "2026-03-02", Sum: "3.00"},
+			CategoryName: "Cleaning", VenueName: "Corner \"Shop\"", ShopperName: "Alex",
+		},
+	}
+
+	var buf bytes.Buffer
+	w := newPurchaseLedgerWriter(&buf, LEDGER_FORMAT_LEDGER, mapping)
+	for _, p := range purchases {
+		w.write(p)
+	}
+	w.end()
+
+	expected := "2026-03-01 * Miller's Bakery\n    ; id: k1\n    ; shopper: Alex\n    Expenses:Food- fresh  12.50 EUR\n    Assets:Cash:Alex\n\n" +
+		"2026-03-02 * Corner \"Shop\"\n    ; id: k2\n    ; shopper: Alex\n    Expenses:Household  3.00 EUR\n    Assets:Cash:Alex\n\n"
+	if buf.String() != expected {
+		t.Errorf("Ledger export returns '%s' instead of expected '%s'", buf.String(), expected)
+	}
+
+	buf.Reset()
+	w = newPurchaseLedgerWriter(&buf, LEDGER_FORMAT_BEANCOUNT, mapping)
+	for _, p := range purchases {
+		w.write(p)
+	}
+	w.end()
+
+	expected = "2026-03-01 open Expenses:Food-fresh\n2026-03-01 open Assets:Cash:Alex\n" +
+		"2026-03-01 * \"Miller's Bakery\" \"Food: fresh\"\n  id: \"k1\"\n  shopper: \"Alex\"\n  Expenses:Food-fresh  12.50 EUR\n  Assets:Cash:Alex\n\n" +
+		"2026-03-02 open Expenses:Household\n" +
+		"2026-03-02 * \"Corner \\\"Shop\\\"\" \"Cleaning\"\n  id: \"k2\"\n  shopper: \"Alex\"\n  Expenses:Household  3.00 EUR\n  Assets:Cash:Alex\n\n"
+	if buf.String() != expected {
+		t.Errorf("Beancount export returns '%s' instead of expected '%s'", buf.String(), expected)
+	}
+}
+
+func TestBeancountAccount(t *testing.T) {
+	cases := map[string]string{
+		"Expenses:Food & Drinks": "Expenses:Food-Drinks",
+		"Assets:cash":            "Assets:Cash",
+		"Expenses:bäckerei":      "Expenses:Bäckerei",
+		"Expenses::":             "Expenses:Unknown:Unknown",
+	}
+	for account, expected := range cases {
+		if a := beancountAccount(account); a != expected {
+			t.Errorf("beancountAccount returns '%s' instead of expected '%s' for '%s'", a, expected, account)
+		}
+	}
+}
+
+func TestWriteErrorResponse(t *testing.T) {
+	rec := httptest.NewRecorder()
+	ctx := &macaron.Context{Resp: macaron.NewResponseWriter("GET", rec)}
+
+	writeErrorResponse(ctx, 400, "Invalid export format")
+	if rec.Code != 400 {
+		t.Errorf("writeErrorResponse writes status %d instead of expected 400", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("writeErrorResponse writes content type '%s' instead of expected 'application/json'", ct)
+	}
+	if body := rec.Body.String(); body != ErrorResponse("Invalid export format") {
+		t.Errorf("writeErrorResponse writes body '%s' instead of expected error response", body)
+	}
+}
@@ -0,0 +1,328 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gopkg.in/macaron.v1"
+
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+func GetGoals(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	goals, err := repository.GetSavingsGoals()
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(goals)
+}
+
+func GetGoalProgress(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	key := ctx.Params(":key")
+	_, err := repository.GetSavingsGoal(key)
+	if err != nil {
+		return 404, ErrorResponse("Savings goal not found")
+	}
+
+	progress, err := repository.GetSavingsGoalProgress(key)
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(progress)
+}
+
+func PutGoal(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	body, err := ctx.Req.Body().Bytes()
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to read request: %s", err))
+	}
+
+	var data map[string]interface{}
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to parse JSON: %s", err))
+	}
+
+	// ----
+	// Extract data
+
+	goal := repository.SavingsGoal{}
+
+	name, ok := data["name"].(string)
+	if !ok || name == "" {
+		return 400, ErrorResponse("Parameter 'name' is required and must be a string")
+	}
+	goal.Name = name
+
+	target, ok := data["target"].(string)
+	if !ok {
+		return 400, ErrorResponse("Parameter 'target' is required and must be a string")
+	}
+	goal.Target, err = FormatSum(target)
+	if err != nil {
+		return 400, ErrorResponse("Failed to format provided value for parameter 'target'")
+	}
+
+	deadline, ok := data["deadline"].(string)
+	if !ok {
+		return 400, ErrorResponse("Parameter 'deadline' is required and must be a string")
+	}
+	_, err = time.Parse(repository.DATE_FORMAT, deadline)
+	if err != nil {
+		return 400, ErrorResponse(fmt.Sprintf("Date '%s' is not valid: %s", deadline, err))
+	}
+	goal.Deadline = deadline
+
+	if data["categories"] != nil {
+		goal.Categories, err = extractStringList(data["categories"], "categories")
+		if err != nil {
+			return 400, ErrorResponse(err.Error())
+		}
+	}
+
+	// ----
+	// Create goal
+
+	created, err := repository.AddSavingsGoal(goal)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to add savings goal: %s", err))
+	}
+
+	return 200, SuccessResponse(created)
+}
+
+func PostGoal(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	key := ctx.Params(":key")
+	if key == "" {
+		return 400, ErrorResponse("No savings goal key specified")
+	}
+
+	body, err := ctx.Req.Body().Bytes()
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to read request: %s", err))
+	}
+
+	var data map[string]interface{}
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to parse JSON: %s", err))
+	}
+
+	// ----
+	// Extract updated data
+
+	values := make(map[string]interface{})
+
+	// name
+	if data["name"] != nil {
+		name, ok := data["name"].(string)
+		if !ok || name == "" {
+			return 400, ErrorResponse("The parameter 'name' must be a non-empty string")
+		}
+		values["name"] = name
+	}
+
+	// target
+	if data["target"] != nil {
+		target, ok := data["target"].(string)
+		if !ok {
+			return 400, ErrorResponse("The parameter 'target' must be a string")
+		}
+		values["target"], err = FormatSum(target)
+		if err != nil {
+			return 400, ErrorResponse("Failed to format the value for parameter 'target'")
+		}
+	}
+
+	// deadline
+	if data["deadline"] != nil {
+		deadline, ok := data["deadline"].(string)
+		if !ok {
+			return 400, ErrorResponse("The parameter 'deadline' must be a string")
+		}
+		_, err = time.Parse(repository.DATE_FORMAT, deadline)
+		if err != nil {
+			return 400, ErrorResponse(fmt.Sprintf("Date '%s' is not valid: %s", deadline, err))
+		}
+		values["deadline"] = deadline
+	}
+
+	// categories
+	if data["categories"] != nil {
+		values["categories"], err = extractStringList(data["categories"], "categories")
+		if err != nil {
+			return 400, ErrorResponse(err.Error())
+		}
+	}
+
+	if len(values) == 0 {
+		return 200, SuccessResponse(nil)
+	}
+
+	// ----
+	// Execute the update
+
+	err = repository.UpdateSavingsGoal(key, &values)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to update savings goal: %s", err))
+	}
+
+	goal, err := repository.GetSavingsGoal(key)
+	if err != nil {
+		return 200, SuccessResponse(nil)
+	}
+
+	return 200, SuccessResponse(goal)
+}
+
+func DeleteGoal(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	key := ctx.Params(":key")
+	if key == "" {
+		return 400, ErrorResponse("No savings goal key specified")
+	}
+
+	err := repository.DeleteSavingsGoal(key)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to delete savings goal: %s", err))
+	}
+	return 200, SuccessResponse(nil)
+}
+
+// ----
+// Contributions
+
+func GetGoalContributions(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	contributions, err := repository.GetSavingsContributions(ctx.Params(":key"))
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(contributions)
+}
+
+func PutGoalContribution(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	body, err := ctx.Req.Body().Bytes()
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to read request: %s", err))
+	}
+
+	var data map[string]interface{}
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to parse JSON: %s", err))
+	}
+
+	// ----
+	// Extract data
+
+	contribution := repository.SavingsContribution{Goal: ctx.Params(":key")}
+
+	amount, ok := data["amount"].(string)
+	if !ok {
+		return 400, ErrorResponse("Parameter 'amount' is required and must be a string")
+	}
+	contribution.Amount, err = FormatSum(amount)
+	if err != nil {
+		return 400, ErrorResponse("Failed to format provided value for parameter 'amount'")
+	}
+
+	date, ok := data["date"].(string)
+	if !ok {
+		return 400, ErrorResponse("Parameter 'date' is required and must be a string")
+	}
+	_, err = time.Parse(repository.DATE_FORMAT, date)
+	if err != nil {
+		return 400, ErrorResponse(fmt.Sprintf("Date '%s' is not valid: %s", date, err))
+	}
+	contribution.Date = date
+
+	contribution.Note, _ = data["note"].(string)
+
+	// ----
+	// Create contribution
+
+	created, err := repository.AddSavingsContribution(contribution)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to add contribution: %s", err))
+	}
+
+	return 200, SuccessResponse(created)
+}
+
+func DeleteGoalContribution(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	err := repository.DeleteSavingsContribution(ctx.Params(":key"), ctx.Params(":contribution"))
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to delete contribution: %s", err))
+	}
+	return 200, SuccessResponse(nil)
+}
+
+func extractStringList(raw interface{}, name string) ([]string, error) {
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("The parameter '%s' must be a list of strings", name)
+	}
+
+	res := make([]string, 0, len(list))
+	for _, v := range list {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("The parameter '%s' must be a list of strings", name)
+		}
+		res = append(res, s)
+	}
+
+	return res, nil
+}
+
+func OptionsGoals(ctx *macaron.Context) (int, string) {
+	ctx.Resp.Header().Add(
+		"Access-Control-Allow-Methods",
+		"GET, POST, PUT, DELETE, OPTIONS",
+	)
+	ctx.Resp.Header().Add(
+		"Access-Control-Allow-Headers",
+		"Content-Type, Authentication",
+	)
+	return 200, ""
+}
@@ -0,0 +1,138 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/macaron.v1"
+
+	"github.com/mandrakey/shoptrac/config"
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+const (
+	IMAGE_CACHE_CONTROL = "public, max-age=31536000, immutable"
+)
+
+/*
+PutImage stores an uploaded image. The image is either sent as raw body with an image/* content type, or as JSON object
+with the base64 encoded image in the field 'image'.
+*/
+func PutImage(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	body, err := ctx.Req.Body().Bytes()
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to read request: %s", err))
+	}
+
+	var img *repository.Image
+	if strings.HasPrefix(ctx.Req.Header.Get("Content-Type"), "image/") {
+		img, err = repository.StoreImage(body)
+	} else {
+		var data map[string]interface{}
+		err = json.Unmarshal(body, &data)
+		if err != nil {
+			return 500, ErrorResponse(fmt.Sprintf("Failed to parse JSON: %s", err))
+		}
+
+		image, ok := data["image"].(string)
+		if !ok || image == "" {
+			return 400, ErrorResponse("Parameter 'image' is required and must be a base64 encoded string")
+		}
+		img, err = repository.StoreBase64Image(image)
+	}
+	if err != nil {
+		return 400, ErrorResponse(fmt.Sprintf("Failed to store image: %s", err))
+	}
+
+	return 200, SuccessResponse(img)
+}
+
+/*
+GetImage serves a stored image. Images are addressed by their content, so the response may be cached forever. No session
+is required, allowing the image URLs to be used directly in the frontend.
+*/
+func GetImage(ctx *macaron.Context) {
+	serveImage(ctx, false)
+}
+
+func GetImageThumbnail(ctx *macaron.Context) {
+	serveImage(ctx, true)
+}
+
+func serveImage(ctx *macaron.Context, thumbnail bool) {
+	log := config.Logger()
+
+	key := ctx.Params(":key")
+	if !repository.IsImageKey(key) {
+		ctx.Error(404, "Image not found")
+		return
+	}
+
+	etag := fmt.Sprintf("\"%s\"", key)
+	if thumbnail {
+		etag = fmt.Sprintf("\"%s_thumb\"", key)
+	}
+	if ctx.Req.Header.Get("If-None-Match") == etag {
+		ctx.Resp.WriteHeader(304)
+		return
+	}
+
+	img, err := repository.GetImage(key)
+	if err != nil {
+		log.Debugf("Failed to load image %s: %s", key, err)
+		ctx.Error(404, "Image not found")
+		return
+	}
+
+	data, err := repository.ReadImageData(key, thumbnail)
+	if err != nil {
+		log.Errorf("Failed to read image data for %s: %s", key, err)
+		ctx.Error(500, "Failed to read image")
+		return
+	}
+
+	ctx.Resp.Header().Set("Content-Type", img.ContentType)
+	ctx.Resp.Header().Set("Cache-Control", IMAGE_CACHE_CONTROL)
+	ctx.Resp.Header().Set("ETag", etag)
+	ctx.Resp.WriteHeader(200)
+	ctx.Resp.Write(data)
+}
+
+/*
+Turns the value of an 'image' request parameter into an image key. Existing image keys are kept as they are, base64
+encoded images are moved to the image storage first.
+*/
+func resolveImageParam(value string) (string, error) {
+	if value == "" || repository.IsImageKey(value) {
+		return value, nil
+	}
+
+	img, err := repository.StoreBase64Image(value)
+	if err != nil {
+		return "", err
+	}
+
+	return img.Key, nil
+}
+
+func OptionsImage(ctx *macaron.Context) (int, string) {
+	ctx.Resp.Header().Add(
+		"Access-Control-Allow-Methods",
+		"GET, PUT, OPTIONS",
+	)
+	ctx.Resp.Header().Add(
+		"Access-Control-Allow-Headers",
+		"Content-Type, Authentication",
+	)
+	return 200, ""
+}
@@ -0,0 +1,358 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/macaron.v1"
+
+	"github.com/mandrakey/shoptrac/config"
+	"github.com/mandrakey/shoptrac/importer"
+	"github.com/mandrakey/shoptrac/notification"
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+type csvImportRequest struct {
+	importer.CsvOptions
+	Csv string `json:"csv"`
+}
+
+/*
+PostImportPurchases imports purchases from a CSV file sent as {"csv": "...", "mapping": {"date": "Datum", ...},
+"defaults": {"shopper": "..."}, "delimiter": ";", "date_format": "DD.MM.YYYY", "decimal_separator": ",",
+"thousands_separator": ".", "dry_run": true}. Nothing is imported in a dry run or if any row is invalid; the result lists
+the errors of all rows.
+*/
+func PostImportPurchases(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	body, err := ctx.Req.Body().Bytes()
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to read request: %s", err))
+	}
+
+	var req csvImportRequest
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&req)
+	if err != nil {
+		return 400, ErrorResponse(fmt.Sprintf("Invalid import request: %s", err))
+	}
+	if req.Csv == "" {
+		return 400, ErrorResponse("Parameter 'csv' is required and must be a string")
+	}
+
+	records, rowErrors, err := importer.ReadCsv(strings.NewReader(req.Csv), req.CsvOptions)
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+
+	res, err := importer.Import(records, rowErrors, req.DryRun)
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+	if !res.DryRun && len(res.Errors) > 0 {
+		return 400, ErrorResponseWithData("Invalid rows, nothing has been imported", res)
+	}
+
+	go notification.EvaluatePurchases(res.Purchases)
+
+	return 200, SuccessResponse(res)
+}
+
+// ----
+// Bank statements
+
+type statementImportRequest struct {
+	Format string `json:"format"`
+	Data   string `json:"data"`
+}
+
+/*
+PostImportStatement adds the debit transactions of a bank statement to the review queue. The statement is sent as
+{"format": "camt053", "data": "..."}, where the format is one of 'camt053', 'mt940' or 'ofx' and detected from the data
+if left out.
+*/
+func PostImportStatement(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	sess := GetActiveSession(ctx)
+
+	body, err := ctx.Req.Body().Bytes()
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to read request: %s", err))
+	}
+
+	var req statementImportRequest
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&req)
+	if err != nil {
+		return 400, ErrorResponse(fmt.Sprintf("Invalid import request: %s", err))
+	}
+	if req.Data == "" {
+		return 400, ErrorResponse("Parameter 'data' is required and must be a string")
+	}
+
+	transactions, format, err := importer.ParseStatement([]byte(req.Data), req.Format)
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+
+	res, err := importer.AddCandidates(transactions, format, sess.UserKey)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to import statement: %s", err))
+	}
+
+	return 200, SuccessResponse(res)
+}
+
+/*
+GetImportCandidates returns the review queue. Only pending candidates are returned unless '?status=' asks for
+'confirmed', 'discarded' or 'all'.
+*/
+func GetImportCandidates(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	status := ctx.Query("status")
+	switch status {
+	case "":
+		status = repository.CANDIDATE_STATUS_PENDING
+	case "all":
+		status = ""
+	case repository.CANDIDATE_STATUS_PENDING, repository.CANDIDATE_STATUS_CONFIRMED, repository.CANDIDATE_STATUS_DISCARDED:
+	default:
+		return 400, ErrorResponse("Parameter 'status' must be one of 'pending', 'confirmed', 'discarded' or 'all'")
+	}
+
+	candidates, err := repository.GetImportCandidates(status)
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(candidates)
+}
+
+/*
+PostImportCandidate changes date, sum, venue, category or shopper of a pending candidate.
+*/
+func PostImportCandidate(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	key := ctx.Params(":key")
+	if key == "" {
+		return 400, ErrorResponse("No import candidate key specified")
+	}
+
+	body, err := ctx.Req.Body().Bytes()
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to read request: %s", err))
+	}
+
+	var data map[string]interface{}
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to parse JSON: %s", err))
+	}
+
+	// ----
+	// Extract updated data
+
+	values := make(map[string]interface{})
+
+	for _, name := range []string{"category", "venue", "shopper"} {
+		if data[name] != nil {
+			value, ok := data[name].(string)
+			if !ok {
+				return 400, ErrorResponse(fmt.Sprintf("The parameter '%s' must be a string", name))
+			}
+			values[name] = value
+		}
+	}
+
+	if data["date"] != nil {
+		date, ok := data["date"].(string)
+		if !ok {
+			return 400, ErrorResponse("The parameter 'date' must be a string")
+		}
+		d, err := repository.ParseLocalDate(date, sessionLocation(ctx))
+		if err != nil {
+			return 400, ErrorResponse(err.Error())
+		}
+		values["date"] = repository.DateToDb(d)
+	}
+
+	if data["sum"] != nil {
+		sum, ok := data["sum"].(string)
+		if !ok {
+			return 400, ErrorResponse("The parameter 'sum' must be a string")
+		}
+		values["sum"], err = FormatSum(sum)
+		if err != nil {
+			return 400, ErrorResponse("The parameter 'sum' must be a number")
+		}
+	}
+
+	if len(values) == 0 {
+		return 200, SuccessResponse(nil)
+	}
+
+	// ----
+	// Execute the update
+
+	err = repository.UpdateImportCandidate(key, &values)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to update import candidate: %s", err))
+	}
+
+	candidate, err := repository.GetImportCandidate(key)
+	if err != nil {
+		return 200, SuccessResponse(nil)
+	}
+
+	return 200, SuccessResponse(candidate)
+}
+
+/*
+PostImportCandidateConfirm turns a pending candidate into a purchase.
+*/
+func PostImportCandidateConfirm(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	key := ctx.Params(":key")
+	if key == "" {
+		return 400, ErrorResponse("No import candidate key specified")
+	}
+
+	purchase, err := repository.ConfirmImportCandidate(key)
+	if purchase == nil {
+		return 400, ErrorResponse(fmt.Sprintf("Failed to confirm import candidate: %s", err))
+	}
+	if err != nil {
+		config.Logger().Errorf("Import candidate %s confirmed as purchase %s with errors: %s", key, purchase.Key, err)
+	}
+
+	go notification.EvaluatePurchase(*purchase)
+
+	return 200, SuccessResponse(purchase)
+}
+
+/*
+DeleteImportCandidate discards a pending candidate. It is kept as discarded, so importing the statement again does not
+bring it back.
+*/
+func DeleteImportCandidate(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	key := ctx.Params(":key")
+	if key == "" {
+		return 400, ErrorResponse("No import candidate key specified")
+	}
+
+	err := repository.DiscardImportCandidate(key)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to discard import candidate: %s", err))
+	}
+	return 200, SuccessResponse(nil)
+}
+
+// ----
+// Venue rules
+
+func GetVenueRules(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	rules, err := repository.GetVenueRules()
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(rules)
+}
+
+func PutVenueRule(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	body, err := ctx.Req.Body().Bytes()
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to read request: %s", err))
+	}
+
+	var data map[string]interface{}
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to parse JSON: %s", err))
+	}
+
+	pattern, ok := data["pattern"].(string)
+	if !ok || strings.TrimSpace(pattern) == "" {
+		return 400, ErrorResponse("Parameter 'pattern' is required and must be a non-empty string")
+	}
+
+	venue, ok := data["venue"].(string)
+	if !ok {
+		return 400, ErrorResponse("Parameter 'venue' is required and must be a string")
+	}
+
+	rule, err := repository.AddVenueRule(repository.VenueRule{Pattern: pattern, Venue: venue})
+	if rule == nil {
+		return 400, ErrorResponse(fmt.Sprintf("Failed to add venue rule: %s", err))
+	}
+	if err != nil {
+		config.Logger().Errorf("Venue rule %s added with errors: %s", rule.Key, err)
+	}
+
+	return 200, SuccessResponse(rule)
+}
+
+func DeleteVenueRule(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	key := ctx.Params(":key")
+	if key == "" {
+		return 400, ErrorResponse("No venue rule key specified")
+	}
+
+	err := repository.DeleteVenueRule(key)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to delete venue rule: %s", err))
+	}
+	return 200, SuccessResponse(nil)
+}
+
+func OptionsImport(ctx *macaron.Context) (int, string) {
+	ctx.Resp.Header().Add(
+		"Access-Control-Allow-Methods",
+		"GET, POST, PUT, DELETE, OPTIONS",
+	)
+	ctx.Resp.Header().Add(
+		"Access-Control-Allow-Headers",
+		"Content-Type, Authentication",
+	)
+	return 200, ""
+}
@@ -0,0 +1,226 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gopkg.in/macaron.v1"
+
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+/*
+GetIncome lists income, optionally limited by '?from=' and '?to=' dates or a '?period='.
+*/
+func GetIncome(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	from, err := extractDateQuery(ctx, "from")
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+	to, err := extractDateQuery(ctx, "to")
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+
+	period, err := extractPeriodQuery(ctx)
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+	if period != nil {
+		from, to = period.FromDb(), period.ToDb()
+	}
+
+	income, err := repository.GetIncome(from, to)
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(income)
+}
+
+func PutIncome(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	body, err := ctx.Req.Body().Bytes()
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to read request: %s", err))
+	}
+
+	var data map[string]interface{}
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to parse JSON: %s", err))
+	}
+
+	// ----
+	// Extract data
+
+	income := repository.Income{}
+
+	source, ok := data["source"].(string)
+	if !ok || source == "" {
+		return 400, ErrorResponse("Parameter 'source' is required and must be a string")
+	}
+	income.Source = source
+
+	amount, ok := data["amount"].(string)
+	if !ok {
+		return 400, ErrorResponse("Parameter 'amount' is required and must be a string")
+	}
+	income.Amount, err = FormatSum(amount)
+	if err != nil {
+		return 400, ErrorResponse("Failed to format provided value for parameter 'amount'")
+	}
+
+	date, ok := data["date"].(string)
+	if !ok {
+		return 400, ErrorResponse("Parameter 'date' is required and must be a string")
+	}
+	_, err = time.Parse(repository.DATE_FORMAT, date)
+	if err != nil {
+		return 400, ErrorResponse(fmt.Sprintf("Date '%s' is not valid: %s", date, err))
+	}
+	income.Date = date
+
+	income.Recurring, _ = data["recurring"].(bool)
+
+	// ----
+	// Create income
+
+	created, err := repository.AddIncome(income)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to add income: %s", err))
+	}
+
+	return 200, SuccessResponse(created)
+}
+
+func PostIncome(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	key := ctx.Params(":key")
+	if key == "" {
+		return 400, ErrorResponse("No income key specified")
+	}
+
+	body, err := ctx.Req.Body().Bytes()
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to read request: %s", err))
+	}
+
+	var data map[string]interface{}
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to parse JSON: %s", err))
+	}
+
+	// ----
+	// Extract updated data
+
+	values := make(map[string]interface{})
+
+	// source
+	if data["source"] != nil {
+		source, ok := data["source"].(string)
+		if !ok || source == "" {
+			return 400, ErrorResponse("The parameter 'source' must be a non-empty string")
+		}
+		values["source"] = source
+	}
+
+	// amount
+	if data["amount"] != nil {
+		amount, ok := data["amount"].(string)
+		if !ok {
+			return 400, ErrorResponse("The parameter 'amount' must be a string")
+		}
+		values["amount"], err = FormatSum(amount)
+		if err != nil {
+			return 400, ErrorResponse("Failed to format the value for parameter 'amount'")
+		}
+	}
+
+	// date
+	if data["date"] != nil {
+		date, ok := data["date"].(string)
+		if !ok {
+			return 400, ErrorResponse("The parameter 'date' must be a string")
+		}
+		_, err = time.Parse(repository.DATE_FORMAT, date)
+		if err != nil {
+			return 400, ErrorResponse(fmt.Sprintf("Date '%s' is not valid: %s", date, err))
+		}
+		values["date"] = date
+	}
+
+	// recurring
+	if data["recurring"] != nil {
+		recurring, ok := data["recurring"].(bool)
+		if !ok {
+			return 400, ErrorResponse("The parameter 'recurring' must be a boolean")
+		}
+		values["recurring"] = recurring
+	}
+
+	if len(values) == 0 {
+		return 200, SuccessResponse(nil)
+	}
+
+	// ----
+	// Execute the update
+
+	err = repository.UpdateIncome(key, &values)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to update income: %s", err))
+	}
+
+	income, err := repository.GetIncomeEntry(key)
+	if err != nil {
+		return 200, SuccessResponse(nil)
+	}
+
+	return 200, SuccessResponse(income)
+}
+
+func DeleteIncome(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	key := ctx.Params(":key")
+	if key == "" {
+		return 400, ErrorResponse("No income key specified")
+	}
+
+	err := repository.DeleteIncome(key)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to delete income: %s", err))
+	}
+	return 200, SuccessResponse(nil)
+}
+
+func OptionsIncome(ctx *macaron.Context) (int, string) {
+	ctx.Resp.Header().Add(
+		"Access-Control-Allow-Methods",
+		"GET, POST, PUT, DELETE, OPTIONS",
+	)
+	ctx.Resp.Header().Add(
+		"Access-Control-Allow-Headers",
+		"Content-Type, Authentication",
+	)
+	return 200, ""
+}
@@ -0,0 +1,288 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gopkg.in/macaron.v1"
+
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+const (
+	DEFAULT_EXPIRY_DAYS = 7
+)
+
+func GetInventory(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	items, err := repository.GetInventoryItems()
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(items)
+}
+
+func GetInventoryLowStock(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	items, err := repository.GetLowStockInventoryItems()
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(items)
+}
+
+func GetInventoryExpiring(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	days := DEFAULT_EXPIRY_DAYS
+	if ctx.Query("days") != "" {
+		days = ctx.QueryInt("days")
+		if days < 0 {
+			return 400, ErrorResponse("Parameter 'days' must be a positive number")
+		}
+	}
+
+	items, err := repository.GetExpiringInventoryItems(repository.LocalDate(time.Now(), sessionLocation(ctx)).AddDate(0, 0, days))
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(items)
+}
+
+func GetInventoryRate(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	key := ctx.Params(":key")
+	if key == "" {
+		return 400, ErrorResponse("No inventory item key specified")
+	}
+
+	rate, err := repository.GetConsumptionRate(key)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to calculate consumption rate: %s", err))
+	}
+
+	return 200, SuccessResponse(rate)
+}
+
+func PutInventory(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	body, err := ctx.Req.Body().Bytes()
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to read request: %s", err))
+	}
+
+	var data map[string]interface{}
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to parse JSON: %s", err))
+	}
+
+	// ----
+	// Extract data
+
+	item := repository.InventoryItem{}
+
+	name, ok := data["name"].(string)
+	if !ok {
+		return 400, ErrorResponse("Parameter 'name' is required and must be a string")
+	}
+	item.Name = name
+
+	item.Unit, _ = data["unit"].(string)
+	item.Location, _ = data["location"].(string)
+	item.Quantity, _ = data["quantity"].(float64)
+	item.MinQuantity, _ = data["min_quantity"].(float64)
+	item.BestBefore, _ = data["best_before"].(string)
+
+	// ----
+	// Create item
+
+	created, err := repository.AddInventoryItem(item)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to add inventory item: %s", err))
+	}
+
+	return 200, SuccessResponse(created)
+}
+
+func PostInventory(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	key := ctx.Params(":key")
+	if key == "" {
+		return 400, ErrorResponse("No inventory item key specified")
+	}
+
+	body, err := ctx.Req.Body().Bytes()
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to read request: %s", err))
+	}
+
+	var data map[string]interface{}
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to parse JSON: %s", err))
+	}
+
+	// ----
+	// Extract updated data
+
+	values := make(map[string]interface{})
+
+	for _, field := range []string{"name", "unit", "location"} {
+		if data[field] != nil {
+			value, ok := data[field].(string)
+			if !ok {
+				return 400, ErrorResponse(fmt.Sprintf("The parameter '%s' must be a string", field))
+			}
+			values[field] = value
+		}
+	}
+	if values["name"] == "" {
+		return 400, ErrorResponse("The parameter 'name' must not be empty")
+	}
+
+	for _, field := range []string{"quantity", "min_quantity"} {
+		if data[field] != nil {
+			value, ok := data[field].(float64)
+			if !ok || value < 0 {
+				return 400, ErrorResponse(fmt.Sprintf("The parameter '%s' must be a positive number", field))
+			}
+			values[field] = value
+		}
+	}
+
+	if data["best_before"] != nil {
+		bestBefore, ok := data["best_before"].(string)
+		if !ok {
+			return 400, ErrorResponse("The parameter 'best_before' must be a string")
+		}
+		if bestBefore != "" {
+			_, err = time.Parse(repository.DATE_FORMAT, bestBefore)
+			if err != nil {
+				return 400, ErrorResponse(fmt.Sprintf("Date '%s' is not valid: %s", bestBefore, err))
+			}
+		}
+		values["best_before"] = bestBefore
+	}
+
+	// any data to update at all?
+	if len(values) == 0 {
+		return 200, SuccessResponse(nil)
+	}
+
+	// ----
+	// Execute the update
+
+	err = repository.UpdateInventoryItem(key, &values)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to update inventory item: %s", err))
+	}
+
+	item, err := repository.GetInventoryItem(key)
+	if err != nil {
+		return 200, SuccessResponse(nil)
+	}
+
+	return 200, SuccessResponse(item)
+}
+
+func PostInventoryConsume(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	key := ctx.Params(":key")
+	if key == "" {
+		return 400, ErrorResponse("No inventory item key specified")
+	}
+
+	body, err := ctx.Req.Body().Bytes()
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to read request: %s", err))
+	}
+
+	var data map[string]interface{}
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to parse JSON: %s", err))
+	}
+
+	quantity, ok := data["quantity"].(float64)
+	if !ok || quantity <= 0 {
+		return 400, ErrorResponse("Parameter 'quantity' is required and must be a positive number")
+	}
+
+	date := repository.LocalDate(time.Now(), sessionLocation(ctx))
+	if data["date"] != nil {
+		dateStr, ok := data["date"].(string)
+		if !ok {
+			return 400, ErrorResponse("The parameter 'date' must be a string")
+		}
+		date, err = time.Parse(repository.DATE_FORMAT, dateStr)
+		if err != nil {
+			return 400, ErrorResponse(fmt.Sprintf("Date '%s' is not valid: %s", dateStr, err))
+		}
+	}
+
+	item, err := repository.ConsumeInventoryItem(key, quantity, date)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to consume inventory item: %s", err))
+	}
+
+	return 200, SuccessResponse(item)
+}
+
+func DeleteInventory(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	key := ctx.Params(":key")
+	if key == "" {
+		return 400, ErrorResponse("No inventory item key specified")
+	}
+
+	err := repository.DeleteInventoryItem(key)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to delete inventory item: %s", err))
+	}
+	return 200, SuccessResponse(nil)
+}
+
+func OptionsInventory(ctx *macaron.Context) (int, string) {
+	ctx.Resp.Header().Add(
+		"Access-Control-Allow-Methods",
+		"GET, POST, PUT, DELETE, OPTIONS",
+	)
+	ctx.Resp.Header().Add(
+		"Access-Control-Allow-Headers",
+		"Content-Type, Authentication",
+	)
+	return 200, ""
+}
@@ -149,6 +149,28 @@ func PatchProfile(ctx *macaron.Context) (int, string) {
 		changed++
 	}
 
+	// An empty time zone resets it to the household time zone
+	if data["time_zone"] != nil {
+		timeZone, ok := data["time_zone"].(string)
+		if !ok {
+			return 400, ErrorResponse("Invalid value for 'time_zone'.")
+		}
+		if timeZone != "" {
+			_, err = repository.LoadLocation(timeZone)
+			if err != nil {
+				return 400, ErrorResponse(err.Error())
+			}
+		}
+
+		err = repository.UserUpdateTimeZone(user.Key, timeZone)
+		if err != nil {
+			log.Errorf("Failed to update time zone for patching profile: %s", err)
+			return 500, ErrorResponse("Failed to update time zone.")
+		}
+		user.TimeZone = timeZone
+		changed++
+	}
+
 	if changed == 0 {
 		return 204, "" // Nothing to update
 	}
@@ -166,7 +188,7 @@ func PatchProfile(ctx *macaron.Context) (int, string) {
 func OptionsProfile(ctx *macaron.Context) (int, string) {
 	ctx.Resp.Header().Add(
 		"Access-Control-Allow-Methods",
-		"GET, PATCH, POST, OPTIONS",
+		"GET, PATCH, POST, PUT, DELETE, OPTIONS",
 	)
 	ctx.Resp.Header().Add(
 		"Access-Control-Allow-Headers",
@@ -13,6 +13,7 @@ import (
 
 	"gopkg.in/macaron.v1"
 
+	"github.com/mandrakey/shoptrac/notification"
 	"github.com/mandrakey/shoptrac/repository"
 )
 
@@ -21,6 +22,19 @@ func GetPurchases(ctx *macaron.Context) (int, string) {
 		return UnauthorizedResponse()
 	}
 
+	// A period replaces month and year
+	period, err := extractPeriodQuery(ctx)
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+	if period != nil {
+		purchases, err := repository.GetPurchasesInRange(*period)
+		if err != nil {
+			return 500, ErrorResponse(err.Error())
+		}
+		return 200, SuccessResponse(purchases)
+	}
+
 	// ----
 	// Get month and year parameters
 
@@ -96,23 +110,30 @@ func PutPurchase(ctx *macaron.Context) (int, string) {
 	if !ok {
 		return 400, ErrorResponse("Parameter 'date' is required and must be a string")
 	}
-	_, err = time.Parse(repository.DATE_FORMAT, date)
+	d, err := repository.ParseLocalDate(date, sessionLocation(ctx))
 	if err != nil {
-		return 400, ErrorResponse(fmt.Sprintf("Date '%s' is not valid: %s", date, err))
+		return 400, ErrorResponse(err.Error())
 	}
-	purchase.Date = date
+	purchase.Date = repository.DateToDb(d)
 
-	month, ok := data["month"].(float64)
-	if !ok {
-		return 400, ErrorResponse("Parameter 'month' is required and must be a number")
+	// Month and year default to those of the date
+	purchase.Month = int(d.Month())
+	if data["month"] != nil {
+		month, ok := data["month"].(float64)
+		if !ok {
+			return 400, ErrorResponse("Parameter 'month' must be a number")
+		}
+		purchase.Month = int(month)
 	}
-	purchase.Month = int(month)
 
-	year, ok := data["year"].(float64)
-	if !ok {
-		return 400, ErrorResponse("Parameter 'year' is required and must be a number")
+	purchase.Year = d.Year()
+	if data["year"] != nil {
+		year, ok := data["year"].(float64)
+		if !ok {
+			return 400, ErrorResponse("Parameter 'year' must be a number")
+		}
+		purchase.Year = int(year)
 	}
-	purchase.Year = int(year)
 
 	sum, ok := data["sum"].(string)
 	if !ok {
@@ -123,6 +144,14 @@ func PutPurchase(ctx *macaron.Context) (int, string) {
 		return 500, ErrorResponse("Failed to format provided value for parameter 'sum'")
 	}
 
+	// items for the inventory
+	if data["items"] != nil {
+		purchase.Items, err = extractPurchaseItems(data["items"])
+		if err != nil {
+			return 400, ErrorResponse(err.Error())
+		}
+	}
+
 	// ----
 	// Create purchase
 
@@ -132,6 +161,8 @@ func PutPurchase(ctx *macaron.Context) (int, string) {
 	}
 	purchase.Key = key
 
+	go notification.EvaluatePurchase(purchase)
+
 	return 200, SuccessResponse(purchase)
 }
 
@@ -194,11 +225,11 @@ func PostPurchase(ctx *macaron.Context) (int, string) {
 		if !ok {
 			return 400, ErrorResponse("The parameter 'date' must be a string")
 		}
-		_, err = time.Parse(repository.DATE_FORMAT, date)
+		d, err := repository.ParseLocalDate(date, sessionLocation(ctx))
 		if err != nil {
-			return 400, ErrorResponse(fmt.Sprintf("Date '%s' is not valid: %s", date, err))
+			return 400, ErrorResponse(err.Error())
 		}
-		values["date"] = date
+		values["date"] = repository.DateToDb(d)
 	}
 
 	// month
@@ -231,6 +262,14 @@ func PostPurchase(ctx *macaron.Context) (int, string) {
 		}
 	}
 
+	// items for the inventory, an empty list removes them
+	if data["items"] != nil {
+		values["items"], err = extractPurchaseItems(data["items"])
+		if err != nil {
+			return 400, ErrorResponse(err.Error())
+		}
+	}
+
 	// any data to update at all?
 	if len(values) == 0 {
 		return 200, SuccessResponse(nil)
@@ -249,6 +288,8 @@ func PostPurchase(ctx *macaron.Context) (int, string) {
 		return 200, SuccessResponse(nil)
 	}
 
+	go notification.EvaluatePurchase(*purchase)
+
 	return 200, SuccessResponse(purchase)
 }
 
@@ -282,6 +323,48 @@ func GetPurchaseTimestamps(ctx *macaron.Context) (int, string) {
 	return 200, SuccessResponse(stamps)
 }
 
+func extractPurchaseItems(raw interface{}) ([]repository.PurchaseItem, error) {
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("The parameter 'items' must be a list")
+	}
+
+	items := make([]repository.PurchaseItem, 0, len(list))
+	for i, rawItem := range list {
+		data, ok := rawItem.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Entry %d of 'items' must be an object", i)
+		}
+
+		item := repository.PurchaseItem{}
+
+		item.Item, ok = data["item"].(string)
+		if !ok || item.Item == "" {
+			return nil, fmt.Errorf("Entry %d of 'items' requires 'item' to be a non-empty string", i)
+		}
+
+		item.Quantity, ok = data["quantity"].(float64)
+		if !ok || item.Quantity <= 0 {
+			return nil, fmt.Errorf("Entry %d of 'items' requires 'quantity' to be a positive number", i)
+		}
+
+		if data["best_before"] != nil {
+			item.BestBefore, ok = data["best_before"].(string)
+			if !ok {
+				return nil, fmt.Errorf("Entry %d of 'items' requires 'best_before' to be a string", i)
+			}
+			_, err := time.Parse(repository.DATE_FORMAT, item.BestBefore)
+			if err != nil {
+				return nil, fmt.Errorf("Date '%s' is not valid: %s", item.BestBefore, err)
+			}
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
 func OptionsPurchase(ctx *macaron.Context) (int, string) {
 	ctx.Resp.Header().Add(
 		"Access-Control-Allow-Methods",
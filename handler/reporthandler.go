@@ -0,0 +1,192 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"gopkg.in/macaron.v1"
+
+	"github.com/mandrakey/shoptrac/config"
+	"github.com/mandrakey/shoptrac/notification"
+	"github.com/mandrakey/shoptrac/report"
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+const (
+	REPORT_DELIVERY_HISTORY = 100
+)
+
+/*
+GetMonthlyReportPdf renders the spending report of a month as PDF document.
+*/
+func GetMonthlyReportPdf(ctx *macaron.Context) {
+	log := config.Logger()
+
+	if !IsValidSession(ctx) {
+		writeUnauthorizedResponse(ctx)
+		return
+	}
+
+	month, err := strconv.ParseInt(ctx.Params(":month"), 10, 0)
+	if err != nil || month < 1 || month > 12 {
+		writeErrorResponse(ctx, 400, "Parameter 'month' is required and must be a number between 1 and 12")
+		return
+	}
+
+	year, err := strconv.ParseInt(ctx.Params(":year"), 10, 0)
+	if err != nil {
+		writeErrorResponse(ctx, 400, fmt.Sprintf("Failed to parse year value: %s", err))
+		return
+	}
+
+	data, err := report.GetMonthlyData(int(month), int(year))
+	if err != nil {
+		log.Errorf("Failed to load monthly report data: %s", err)
+		writeErrorResponse(ctx, 500, err.Error())
+		return
+	}
+
+	pdf := report.RenderPdf(data)
+	ctx.Resp.Header().Set("Content-Type", "application/pdf")
+	ctx.Resp.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"shoptrac-%04d-%02d.pdf\"", year, month))
+	ctx.Resp.Header().Set("Content-Length", strconv.Itoa(len(pdf)))
+	ctx.Resp.WriteHeader(200)
+	ctx.Resp.Write(pdf)
+}
+
+// ----
+// Scheduled reports of the current user
+
+func GetProfileReports(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	sess := GetActiveSession(ctx)
+	subs, err := repository.GetReportSubscriptions(sess.UserKey)
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(subs)
+}
+
+func PutProfileReport(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	sess := GetActiveSession(ctx)
+
+	body, err := ctx.Req.Body().Bytes()
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to read request: %s", err))
+	}
+
+	var data map[string]interface{}
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to parse JSON: %s", err))
+	}
+
+	// ----
+	// Extract data
+
+	sub := repository.ReportSubscription{}
+
+	schedule, ok := data["schedule"].(string)
+	if !ok || (schedule != repository.REPORT_SCHEDULE_WEEKLY && schedule != repository.REPORT_SCHEDULE_MONTHLY) {
+		return 400, ErrorResponse("Parameter 'schedule' is required and must be 'weekly' or 'monthly'")
+	}
+	sub.Schedule = schedule
+
+	format, ok := data["format"].(string)
+	if !ok || (format != repository.REPORT_FORMAT_HTML && format != repository.REPORT_FORMAT_CSV && format != repository.REPORT_FORMAT_PDF) {
+		return 400, ErrorResponse("Parameter 'format' is required and must be 'html', 'csv' or 'pdf'")
+	}
+	sub.Format = format
+
+	delivery, ok := data["delivery"].(string)
+	if !ok || (delivery != repository.REPORT_DELIVERY_EMAIL && delivery != repository.REPORT_DELIVERY_DIRECTORY) {
+		return 400, ErrorResponse("Parameter 'delivery' is required and must be 'email' or 'directory'")
+	}
+	sub.Delivery = delivery
+
+	if data["target"] != nil {
+		target, ok := data["target"].(string)
+		if !ok {
+			return 400, ErrorResponse("The parameter 'target' must be a string")
+		}
+		if delivery == repository.REPORT_DELIVERY_EMAIL && target != "" {
+			err = notification.ValidateTarget(notification.CHANNEL_EMAIL, target)
+			if err != nil {
+				return 400, ErrorResponse(err.Error())
+			}
+		}
+		sub.Target = target
+	}
+
+	// ----
+	// Create subscription
+
+	created, err := repository.AddReportSubscription(sess.UserKey, sub)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to add report subscription: %s", err))
+	}
+
+	return 200, SuccessResponse(created)
+}
+
+func DeleteProfileReport(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	sess := GetActiveSession(ctx)
+
+	key := ctx.Params(":key")
+	if key == "" {
+		return 400, ErrorResponse("No report subscription key specified")
+	}
+
+	err := repository.DeleteReportSubscription(sess.UserKey, key)
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to delete report subscription: %s", err))
+	}
+	return 200, SuccessResponse(nil)
+}
+
+/*
+GetProfileReportDeliveries returns the latest deliveries of the current user's scheduled reports, including failed ones.
+*/
+func GetProfileReportDeliveries(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	sess := GetActiveSession(ctx)
+	deliveries, err := repository.GetReportDeliveries(sess.UserKey, REPORT_DELIVERY_HISTORY)
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(deliveries)
+}
+
+func OptionsReports(ctx *macaron.Context) (int, string) {
+	ctx.Resp.Header().Add(
+		"Access-Control-Allow-Methods",
+		"GET, OPTIONS",
+	)
+	ctx.Resp.Header().Add(
+		"Access-Control-Allow-Headers",
+		"Content-Type, Authentication",
+	)
+	return 200, ""
+}
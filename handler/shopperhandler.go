@@ -51,6 +51,10 @@ func PutShoppers(ctx *macaron.Context) (int, string) {
 		return 400, ErrorResponse("Parameter 'name' is required and must be a string")
 	}
 	image, _ := data["image"].(string)
+	image, err = resolveImageParam(image)
+	if err != nil {
+		return 400, ErrorResponse(fmt.Sprintf("Invalid image: %s", err))
+	}
 
 	shopper, err := repository.AddShopper(name, image)
 	if err != nil {
@@ -102,9 +106,12 @@ func PatchShoppers(ctx *macaron.Context) (int, string) {
 	if data["image"] != nil {
 		image, ok := data["image"].(string)
 		if !ok {
-			return 400, ErrorResponse("The parameter 'image' must be a base64 encoded string")
+			return 400, ErrorResponse("The parameter 'image' must be an image key or a base64 encoded string")
+		}
+		values["image"], err = resolveImageParam(image)
+		if err != nil {
+			return 400, ErrorResponse(fmt.Sprintf("Invalid image: %s", err))
 		}
-		values["image"] = image
 	}
 
 	if len(values) == 0 {
@@ -6,8 +6,11 @@ SPDX-License-Identifier: BSD-3-Clause
 package handler
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"strconv"
+	"time"
 
 	"gopkg.in/macaron.v1"
 
@@ -19,6 +22,24 @@ func GetOverviewStatistics(ctx *macaron.Context) (int, string) {
 		return UnauthorizedResponse()
 	}
 
+	// A period replaces month and year
+	period, err := extractPeriodQuery(ctx)
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+	if period != nil {
+		previous, err := repository.PreviousPeriod(*period)
+		if err != nil {
+			return 400, ErrorResponse(err.Error())
+		}
+
+		stats, err := repository.GetOverviewStatisticsForRange(*period, *previous)
+		if err != nil {
+			return 500, ErrorResponse(err.Error())
+		}
+		return 200, SuccessResponse(stats)
+	}
+
 	// ----
 	// Get month and year parameters
 
@@ -51,12 +72,20 @@ func GetOverviewStatistics(ctx *macaron.Context) (int, string) {
 	return 200, SuccessResponse(stats)
 }
 
+/*
+GetPurchasesUnfiltered returns one row per purchase, optionally limited by '?from=' and '?to=' or a '?period='.
+*/
 func GetPurchasesUnfiltered(ctx *macaron.Context) (int, string) {
 	if !IsValidSession(ctx) {
 		return UnauthorizedResponse()
 	}
 
-	stats, err := repository.GetPurchasesUnfiltered()
+	from, to, err := extractDateRangeQuery(ctx)
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+
+	stats, err := repository.GetPurchasesUnfiltered(from, to)
 	if err != nil {
 		return 500, ErrorResponse(err.Error())
 	}
@@ -64,6 +93,448 @@ func GetPurchasesUnfiltered(ctx *macaron.Context) (int, string) {
 	return 200, SuccessResponse(stats)
 }
 
+/*
+GetPurchasesAggregated returns the number and sum of purchases per month, venue and category, optionally limited by
+'?from=' and '?to=' or a '?period='.
+*/
+func GetPurchasesAggregated(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	from, to, err := extractDateRangeQuery(ctx)
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+
+	stats, err := repository.GetPurchasesAggregated(from, to)
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(stats)
+}
+
+func GetForecast(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	// A period replaces month and year
+	period, err := extractPeriodQuery(ctx)
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+	if period != nil {
+		forecast, err := repository.GetForecastForRange(*period)
+		if err != nil {
+			return 500, ErrorResponse(err.Error())
+		}
+		return 200, SuccessResponse(forecast)
+	}
+
+	month, err := strconv.ParseInt(ctx.Params(":month"), 10, 0)
+	if err != nil || month < 1 || month > 12 {
+		return 400, ErrorResponse("Parameter 'month' is required and must be a number between 1 and 12")
+	}
+
+	year, err := strconv.ParseInt(ctx.Params(":year"), 10, 0)
+	if err != nil {
+		return 400, ErrorResponse(fmt.Sprintf("Failed to parse year value: %s", err))
+	}
+
+	forecast, err := repository.GetForecast(int(month), int(year))
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(forecast)
+}
+
+func GetVenueStatistics(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	key := ctx.Params(":key")
+	if key == "" {
+		return 400, ErrorResponse("No venue key specified")
+	}
+
+	venue, err := repository.GetVenue(key)
+	if err != nil {
+		return 404, ErrorResponse(fmt.Sprintf("Venue '%s' not found", key))
+	}
+
+	from, to := "", ""
+	period, err := extractPeriodQuery(ctx)
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+	if period != nil {
+		from, to = period.FromDb(), period.ToDb()
+	}
+
+	stats, err := repository.GetVenueStatistics(*venue, from, to)
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(stats)
+}
+
+func GetAnomalies(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	period, err := extractPeriodQuery(ctx)
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+
+	var anomalies *[]repository.Anomaly
+	if period != nil {
+		anomalies, err = repository.GetAnomaliesInRange(*period)
+	} else {
+		anomalies, err = repository.GetAnomalies()
+	}
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(anomalies)
+}
+
+func GetBreakdownStatistics(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	by := ctx.Query("by")
+	if by == "" {
+		by = "category"
+	}
+	if !repository.IsBreakdownDimension(by) {
+		return 400, ErrorResponse("Parameter 'by' must be one of 'category', 'venue' or 'shopper'")
+	}
+
+	from, err := extractDateQuery(ctx, "from")
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+	to, err := extractDateQuery(ctx, "to")
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+
+	period, err := extractPeriodQuery(ctx)
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+	if period != nil {
+		from, to = period.FromDb(), period.ToDb()
+	}
+
+	// ----
+	// Get data
+
+	stats, err := repository.GetBreakdownStatistics(by, from, to)
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(stats)
+}
+
+func GetTimeSeriesStatistics(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	granularity := ctx.Query("granularity")
+	if granularity == "" {
+		granularity = repository.GRANULARITY_MONTH
+	}
+	if !repository.IsGranularity(granularity) {
+		return 400, ErrorResponse("Parameter 'granularity' must be one of 'day', 'week', 'month', 'quarter' or 'year'")
+	}
+
+	// ----
+	// Get range, defaults to the last twelve months
+
+	toStr, err := extractDateQuery(ctx, "to")
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+	to := repository.LocalDate(time.Now(), sessionLocation(ctx))
+	if toStr != "" {
+		to, _ = repository.DateFromDb(toStr)
+	}
+
+	fromStr, err := extractDateQuery(ctx, "from")
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+	from := to.AddDate(-1, 0, 1)
+	if fromStr != "" {
+		from, _ = repository.DateFromDb(fromStr)
+	}
+
+	period, err := extractPeriodQuery(ctx)
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+	if period != nil {
+		from, to = period.From, period.To
+	}
+	if to.Before(from) {
+		return 400, ErrorResponse("Parameter 'from' must not be after 'to'")
+	}
+	if repository.CountBuckets(from, to, granularity) > repository.TIMESERIES_MAX_POINTS {
+		return 400, ErrorResponse(fmt.Sprintf(
+			"The range must not span more than %d buckets, use a shorter range or a coarser granularity",
+			repository.TIMESERIES_MAX_POINTS,
+		))
+	}
+
+	window := 3
+	if ctx.Query("window") != "" {
+		window = ctx.QueryInt("window")
+		if window < 1 || window > repository.TIMESERIES_MAX_POINTS {
+			return 400, ErrorResponse(fmt.Sprintf("Parameter 'window' must be a number between 1 and %d", repository.TIMESERIES_MAX_POINTS))
+		}
+	}
+
+	filter := repository.StatisticsFilter{
+		Category: ctx.Query("category"),
+		Venue:    ctx.Query("venue"),
+		Shopper:  ctx.Query("shopper"),
+	}
+
+	// ----
+	// Get data
+
+	series, err := repository.GetTimeSeriesStatistics(granularity, from, to, filter, window)
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(series)
+}
+
+/*
+GetCashFlowStatistics returns income, expenses, net cash flow and savings rate per month. The range is set by '?from='
+and '?to=' or a '?period=' and defaults to the last twelve months.
+*/
+func GetCashFlowStatistics(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	toStr, err := extractDateQuery(ctx, "to")
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+	to := repository.LocalDate(time.Now(), sessionLocation(ctx))
+	if toStr != "" {
+		to, _ = repository.DateFromDb(toStr)
+	}
+
+	fromStr, err := extractDateQuery(ctx, "from")
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+	from := time.Date(to.Year(), to.Month()-11, 1, 0, 0, 0, 0, time.UTC)
+	if fromStr != "" {
+		from, _ = repository.DateFromDb(fromStr)
+	}
+
+	period, err := extractPeriodQuery(ctx)
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+	if period != nil {
+		from, to = period.From, period.To
+	}
+
+	cashflow, err := repository.GetCashFlowStatistics(from, to)
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(cashflow)
+}
+
+/*
+GetHeatmapStatistics returns the spending per date of a year or a '?period=', optionally filtered by '?category=',
+'?venue=' and '?shopper='.
+*/
+func GetHeatmapStatistics(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	// A period replaces the year
+	period, err := extractPeriodQuery(ctx)
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+	if period == nil {
+		year, err := strconv.ParseInt(ctx.Params(":year"), 10, 0)
+		if err != nil {
+			return 400, ErrorResponse(fmt.Sprintf("Failed to parse year value: %s", err))
+		}
+		period, err = repository.ParsePeriod(fmt.Sprintf("%04d", year))
+		if err != nil {
+			return 400, ErrorResponse(err.Error())
+		}
+	}
+
+	filter := repository.StatisticsFilter{
+		Category: ctx.Query("category"),
+		Venue:    ctx.Query("venue"),
+		Shopper:  ctx.Query("shopper"),
+	}
+
+	heatmap, err := repository.GetHeatmapStatisticsForRange(*period, filter)
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(heatmap)
+}
+
+/*
+GetComparisonStatistics compares the spending of period 'a' with the reference period 'b', e.g. "?a=2026-03&b=2025-03"
+or "?a=2026-Q1&b=2025-Q1".
+*/
+func GetComparisonStatistics(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	if ctx.Query("a") == "" || ctx.Query("b") == "" {
+		return 400, ErrorResponse("Parameters 'a' and 'b' are required")
+	}
+
+	a, err := repository.ParsePeriod(ctx.Query("a"))
+	if err != nil {
+		return 400, ErrorResponse(fmt.Sprintf("Invalid value for parameter 'a': %s", err))
+	}
+	b, err := repository.ParsePeriod(ctx.Query("b"))
+	if err != nil {
+		return 400, ErrorResponse(fmt.Sprintf("Invalid value for parameter 'b': %s", err))
+	}
+
+	stats, err := repository.GetComparisonStatistics(*a, *b)
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(stats)
+}
+
+/*
+Reads an optional date from the query parameter with the provided name. Returns an empty string if the parameter is not
+set, or an error if it is not a valid date.
+*/
+func extractDateQuery(ctx *macaron.Context, name string) (string, error) {
+	value := ctx.Query(name)
+	if value == "" {
+		return "", nil
+	}
+
+	_, err := time.Parse(repository.DATE_FORMAT, value)
+	if err != nil {
+		return "", fmt.Errorf("Date '%s' for parameter '%s' is not valid: %s", value, name, err)
+	}
+
+	return value, nil
+}
+
+/*
+PostStatisticsQuery runs a declarative pivot query, e.g.
+{"group_by": ["category"], "measures": ["sum"], "filters": [{"field": "year", "op": "eq", "value": 2026}],
+"sort": [{"field": "sum", "desc": true}], "limit": 10}. Unknown attributes are rejected.
+*/
+func PostStatisticsQuery(ctx *macaron.Context) (int, string) {
+	if !IsValidSession(ctx) {
+		return UnauthorizedResponse()
+	}
+
+	body, err := ctx.Req.Body().Bytes()
+	if err != nil {
+		return 500, ErrorResponse(fmt.Sprintf("Failed to read request: %s", err))
+	}
+
+	var q repository.PivotQuery
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&q)
+	if err != nil {
+		return 400, ErrorResponse(fmt.Sprintf("Invalid query: %s", err))
+	}
+
+	err = repository.ValidatePivotQuery(q)
+	if err != nil {
+		return 400, ErrorResponse(err.Error())
+	}
+
+	res, err := repository.ExecutePivotQuery(q)
+	if err != nil {
+		return 500, ErrorResponse(err.Error())
+	}
+
+	return 200, SuccessResponse(res)
+}
+
+/*
+Resolves the optional '?period=' parameter, see repository.ParsePeriod for the supported identifiers. Returns nil if the
+parameter is not set.
+*/
+/*
+Returns the range of dates requested by '?from=' and '?to=', either of which may be empty for an open range, or by a
+'?period=', which takes precedence.
+*/
+func extractDateRangeQuery(ctx *macaron.Context) (string, string, error) {
+	period, err := extractPeriodQuery(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	if period != nil {
+		return period.FromDb(), period.ToDb(), nil
+	}
+
+	from, err := extractDateQuery(ctx, "from")
+	if err != nil {
+		return "", "", err
+	}
+	to, err := extractDateQuery(ctx, "to")
+	if err != nil {
+		return "", "", err
+	}
+	if from != "" && to != "" && from > to {
+		return "", "", fmt.Errorf("Parameter 'from' must not be after 'to'")
+	}
+
+	return from, to, nil
+}
+
+func extractPeriodQuery(ctx *macaron.Context) (*repository.DateRange, error) {
+	value := ctx.Query("period")
+	if value == "" {
+		return nil, nil
+	}
+
+	period, err := repository.ParsePeriod(value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid value for parameter 'period': %s", err)
+	}
+
+	return period, nil
+}
+
 func OptionsStatistics(ctx *macaron.Context) (int, string) {
 	ctx.Resp.Header().Add(
 		"Access-Control-Allow-Methods",
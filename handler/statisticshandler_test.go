@@ -0,0 +1,52 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"gopkg.in/macaron.v1"
+)
+
+func queryContext(query string) *macaron.Context {
+	return &macaron.Context{Req: macaron.Request{Request: httptest.NewRequest("GET", "/?"+query, nil)}}
+}
+
+func TestExtractDateRangeQuery(t *testing.T) {
+	expected := map[string][2]string{
+		"":                                  {"", ""},
+		"from=2026-03-01":                   {"2026-03-01", ""},
+		"to=2026-03-31":                     {"", "2026-03-31"},
+		"from=2026-03-01&to=2026-03-31":     {"2026-03-01", "2026-03-31"},
+		"from=2026-03-01&to=2026-03-01":     {"2026-03-01", "2026-03-01"},
+		"period=2026-02":                    {"2026-02-01", "2026-02-28"},
+		"from=2025-01-01&period=2026-Q1":    {"2026-01-01", "2026-03-31"},
+		"period=2026-03-05..2026-03-10&to=": {"2026-03-05", "2026-03-10"},
+	}
+	for query, exp := range expected {
+		from, to, err := extractDateRangeQuery(queryContext(query))
+		if err != nil {
+			t.Errorf("'%s' returns error: %s", query, err)
+			continue
+		}
+		if from != exp[0] || to != exp[1] {
+			t.Errorf("'%s' returns '%s'..'%s' instead of expected '%s'..'%s'", query, from, to, exp[0], exp[1])
+		}
+	}
+
+	invalid := []string{
+		"from=2026-13-01",
+		"to=31.03.2026",
+		"from=2026-04-01&to=2026-03-31",
+		"period=someday",
+	}
+	for _, query := range invalid {
+		if _, _, err := extractDateRangeQuery(queryContext(query)); err == nil {
+			t.Errorf("'%s' returns no error", query)
+		}
+	}
+}
@@ -113,6 +113,17 @@ func IsValidSession(ctx *macaron.Context) bool {
 	return true
 }
 
+/*
+Returns the time zone of the session user, which is used to resolve timestamps and the current date.
+*/
+func sessionLocation(ctx *macaron.Context) *time.Location {
+	sess := GetActiveSession(ctx)
+	if sess == nil {
+		return repository.Location()
+	}
+	return repository.UserLocation(sess.User)
+}
+
 func FormatSum(sum string) (string, error) {
 	f, err := strconv.ParseFloat(sum, 32)
 	if err != nil {
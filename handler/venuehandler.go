@@ -52,6 +52,10 @@ func PutVenue(ctx *macaron.Context) (int, string) {
 	}
 
 	image, _ := data["image"].(string)
+	image, err = resolveImageParam(image)
+	if err != nil {
+		return 400, ErrorResponse(fmt.Sprintf("Invalid image: %s", err))
+	}
 
 	// ----
 	// Create venue
@@ -106,9 +110,12 @@ func PostVenue(ctx *macaron.Context) (int, string) {
 	if data["image"] != nil {
 		image, ok := data["image"].(string)
 		if !ok {
-			return 400, ErrorResponse("The parameter 'image' must be a base64 encoded string")
+			return 400, ErrorResponse("The parameter 'image' must be an image key or a base64 encoded string")
+		}
+		values["image"], err = resolveImageParam(image)
+		if err != nil {
+			return 400, ErrorResponse(fmt.Sprintf("Invalid image: %s", err))
 		}
-		values["image"] = image
 	}
 
 	// any data to update at all?
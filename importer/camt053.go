@@ -0,0 +1,138 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package importer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+The parts of a CAMT.053 document needed for purchases. Namespaces are ignored, so all versions of the format are read.
+*/
+type camtDocument struct {
+	Statements []struct {
+		Entries []camtEntry `xml:"Ntry"`
+	} `xml:"BkToCstmrStmt>Stmt"`
+}
+
+type camtEntry struct {
+	Amount struct {
+		Value    string `xml:",chardata"`
+		Currency string `xml:"Ccy,attr"`
+	} `xml:"Amt"`
+	CreditDebit string       `xml:"CdtDbtInd"`
+	Reversal    bool         `xml:"RvslInd"`
+	Status      camtStatus   `xml:"Sts"`
+	BookingDate camtDate     `xml:"BookgDt"`
+	ValueDate   camtDate     `xml:"ValDt"`
+	Reference   string       `xml:"AcctSvcrRef"`
+	Details     []camtDetail `xml:"NtryDtls>TxDtls"`
+	Info        string       `xml:"AddtlNtryInf"`
+}
+
+/*
+The status is either given as text or, since version 8, as code.
+*/
+type camtStatus struct {
+	Value string `xml:",chardata"`
+	Code  string `xml:"Cd"`
+}
+
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+type camtDetail struct {
+	Reference  string   `xml:"Refs>AcctSvcrRef"`
+	Creditor   string   `xml:"RltdPties>Cdtr>Nm"`
+	CreditorV8 string   `xml:"RltdPties>Cdtr>Pty>Nm"`
+	Remittance []string `xml:"RmtInf>Ustrd"`
+}
+
+/*
+ParseCamt053 reads the booked debit entries of a CAMT.053 statement. The creditor of the first transaction of an entry
+is used as counterparty.
+*/
+func ParseCamt053(data []byte) ([]Transaction, error) {
+	var doc camtDocument
+	err := xml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid CAMT.053 statement: %s", err)
+	}
+
+	res := make([]Transaction, 0)
+	for _, stmt := range doc.Statements {
+		for i, e := range stmt.Entries {
+			if e.CreditDebit != "DBIT" || e.Reversal || !isBooked(e) {
+				continue
+			}
+
+			t := Transaction{Id: e.Reference, Currency: e.Amount.Currency, Description: cleanText(e.Info)}
+			t.Amount, err = strconv.ParseFloat(strings.TrimSpace(e.Amount.Value), 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid amount '%s' in entry %d", e.Amount.Value, i+1)
+			}
+			t.Date, err = e.date()
+			if err != nil {
+				return nil, fmt.Errorf("Invalid date in entry %d: %s", i+1, err)
+			}
+
+			if len(e.Details) > 0 {
+				d := e.Details[0]
+				t.Counterparty = cleanText(d.Creditor)
+				if t.Counterparty == "" {
+					t.Counterparty = cleanText(d.CreditorV8)
+				}
+				if len(d.Remittance) > 0 {
+					t.Description = cleanText(strings.Join(d.Remittance, " "))
+				}
+				if t.Id == "" {
+					t.Id = d.Reference
+				}
+			}
+			if t.Counterparty == "" {
+				t.Counterparty = t.Description
+			}
+
+			res = append(res, t)
+		}
+	}
+
+	return res, nil
+}
+
+/*
+Entries without status are taken as booked, pending entries are skipped as they may still change.
+*/
+func isBooked(e camtEntry) bool {
+	status := strings.TrimSpace(e.Status.Code)
+	if status == "" {
+		status = strings.TrimSpace(e.Status.Value)
+	}
+	return status == "" || status == "BOOK"
+}
+
+func (e camtEntry) date() (time.Time, error) {
+	for _, d := range []camtDate{e.BookingDate, e.ValueDate} {
+		// Dates may carry a time zone offset, which does not change the day
+		if date := strings.TrimSpace(d.Date); len(date) >= 10 {
+			return time.Parse("2006-01-02", date[:10])
+		}
+		if d.DateTime != "" {
+			t, err := time.Parse(time.RFC3339, strings.TrimSpace(d.DateTime))
+			if err != nil {
+				return t, err
+			}
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("missing booking date")
+}
@@ -0,0 +1,377 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+const (
+	DEFAULT_DATE_FORMAT = "YYYY-MM-DD"
+)
+
+/*
+Maps purchase attributes to the column headers of a CSV file. Columns which are not mapped fall back to the defaults.
+*/
+type CsvMapping struct {
+	Date     string `json:"date"`
+	Sum      string `json:"sum"`
+	Category string `json:"category"`
+	Venue    string `json:"venue"`
+	Shopper  string `json:"shopper"`
+}
+
+/*
+Names used for rows which leave the category, venue or shopper empty, or for files without such a column.
+*/
+type CsvDefaults struct {
+	Category string `json:"category"`
+	Venue    string `json:"venue"`
+	Shopper  string `json:"shopper"`
+}
+
+/*
+CsvOptions describe the layout of a CSV file. The date format is written with the tokens YYYY, YY, MM, M, DD and D, e.g.
+"DD.MM.YYYY".
+*/
+type CsvOptions struct {
+	Mapping            CsvMapping  `json:"mapping"`
+	Defaults           CsvDefaults `json:"defaults"`
+	Delimiter          string      `json:"delimiter"`
+	DateFormat         string      `json:"date_format"`
+	DecimalSeparator   string      `json:"decimal_separator"`
+	ThousandsSeparator string      `json:"thousands_separator"`
+	DryRun             bool        `json:"dry_run"`
+}
+
+/*
+ImportCsv reads purchases from a CSV file whose first row holds the column headers. See Import for how the purchases
+are stored.
+*/
+func ImportCsv(r io.Reader, opts CsvOptions) (*Result, error) {
+	records, rowErrors, err := ReadCsv(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	return Import(records, rowErrors, opts.DryRun)
+}
+
+/*
+ReadCsv parses a CSV file into records. Rows which can not be parsed are returned as row errors, problems with the file
+as a whole as error.
+*/
+func ReadCsv(r io.Reader, opts CsvOptions) ([]Record, []RowError, error) {
+	opts = withCsvDefaults(opts)
+	layout, err := DateLayout(opts.DateFormat)
+	if err != nil {
+		return nil, nil, err
+	}
+	delimiter, _ := utf8.DecodeRuneInString(opts.Delimiter)
+	if utf8.RuneCountInString(opts.Delimiter) != 1 || delimiter == '"' || delimiter == '\r' || delimiter == '\n' {
+		return nil, nil, fmt.Errorf("Delimiter must be a single character")
+	}
+	if opts.ThousandsSeparator == opts.DecimalSeparator {
+		return nil, nil, fmt.Errorf("Thousands and decimal separator must differ")
+	}
+
+	reader := newCsvReader(r, delimiter)
+
+	header, _, err := reader.read()
+	if err == io.EOF {
+		return nil, nil, fmt.Errorf("The file is empty")
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("Failed to read header: %s", err)
+	}
+	columns, err := mapColumns(header, opts.Mapping)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	records := make([]Record, 0)
+	rowErrors := make([]RowError, 0)
+	for {
+		row, line, err := reader.read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if isEmptyRow(row) {
+			continue
+		}
+
+		rec, err := parseCsvRow(row, columns, opts, layout)
+		rec.Line = line
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Line: line, Message: err.Error()})
+			continue
+		}
+		records = append(records, rec)
+	}
+
+	return records, rowErrors, nil
+}
+
+/*
+Reads the rows of a CSV file along with the line they start on. encoding/csv does not tell the line of a row, and
+skips empty lines, so the rows are split by counting quotes before parsing them.
+*/
+type csvReader struct {
+	r         *bufio.Reader
+	delimiter rune
+	line      int
+}
+
+func newCsvReader(r io.Reader, delimiter rune) *csvReader {
+	return &csvReader{r: bufio.NewReader(r), delimiter: delimiter}
+}
+
+/*
+Returns the next row which is not empty and the line it starts on. Quoted fields may span several lines.
+*/
+func (c *csvReader) read() ([]string, int, error) {
+	for {
+		var text strings.Builder
+		start := c.line + 1
+		quotes := 0
+
+		for {
+			s, err := c.r.ReadString('\n')
+			if s != "" {
+				c.line++
+				text.WriteString(s)
+				quotes += strings.Count(s, "\"")
+			}
+			if err == io.EOF {
+				if text.Len() == 0 {
+					return nil, start, io.EOF
+				}
+				break
+			} else if err != nil {
+				return nil, start, err
+			}
+			// An odd number of quotes means a quoted field continues on the next line
+			if quotes%2 == 0 {
+				break
+			}
+		}
+
+		if strings.TrimSpace(text.String()) == "" {
+			continue
+		}
+
+		reader := csv.NewReader(strings.NewReader(text.String()))
+		reader.Comma = c.delimiter
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+
+		row, err := reader.Read()
+		if pe, ok := err.(*csv.ParseError); ok {
+			return nil, start, fmt.Errorf("Line %d: %s", start+pe.Line-1, pe.Err)
+		} else if err != nil {
+			return nil, start, fmt.Errorf("Line %d: %s", start, err)
+		}
+		return row, start, nil
+	}
+}
+
+func withCsvDefaults(opts CsvOptions) CsvOptions {
+	if opts.Delimiter == "" {
+		opts.Delimiter = ","
+	}
+	if opts.DateFormat == "" {
+		opts.DateFormat = DEFAULT_DATE_FORMAT
+	}
+	if opts.DecimalSeparator == "" {
+		opts.DecimalSeparator = "."
+	}
+	if opts.Mapping.Date == "" {
+		opts.Mapping.Date = "date"
+	}
+	if opts.Mapping.Sum == "" {
+		opts.Mapping.Sum = "sum"
+	}
+	return opts
+}
+
+/*
+Column indexes of the mapped attributes, -1 if there is no such column.
+*/
+type csvColumns struct {
+	date     int
+	sum      int
+	category int
+	venue    int
+	shopper  int
+}
+
+/*
+Finds the mapped columns in the header, ignoring case. Date and sum are required, category, venue and shopper default to
+the columns of that name if not mapped explicitly.
+*/
+func mapColumns(header []string, mapping CsvMapping) (csvColumns, error) {
+	index := func(name string) int {
+		for i, h := range header {
+			if strings.EqualFold(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")), strings.TrimSpace(name)) {
+				return i
+			}
+		}
+		return -1
+	}
+	optional := func(name string, fallback string) (int, error) {
+		if name == "" {
+			return index(fallback), nil
+		}
+		i := index(name)
+		if i < 0 {
+			return -1, fmt.Errorf("Column '%s' not found", name)
+		}
+		return i, nil
+	}
+
+	var res csvColumns
+	var err error
+	if res.date = index(mapping.Date); res.date < 0 {
+		return res, fmt.Errorf("Column '%s' not found", mapping.Date)
+	}
+	if res.sum = index(mapping.Sum); res.sum < 0 {
+		return res, fmt.Errorf("Column '%s' not found", mapping.Sum)
+	}
+	if res.category, err = optional(mapping.Category, "category"); err != nil {
+		return res, err
+	}
+	if res.venue, err = optional(mapping.Venue, "venue"); err != nil {
+		return res, err
+	}
+	if res.shopper, err = optional(mapping.Shopper, "shopper"); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+func parseCsvRow(row []string, columns csvColumns, opts CsvOptions, layout string) (Record, error) {
+	field := func(i int) string {
+		if i < 0 || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+	orDefault := func(value string, fallback string) string {
+		if value == "" {
+			return fallback
+		}
+		return value
+	}
+
+	var rec Record
+	date := field(columns.date)
+	d, err := time.Parse(layout, date)
+	if err != nil {
+		return rec, fmt.Errorf("Invalid date '%s', expected format %s", date, opts.DateFormat)
+	}
+	rec.Date = repository.DateToDb(d)
+
+	sum, err := ParseAmount(field(columns.sum), opts.DecimalSeparator, opts.ThousandsSeparator)
+	if err != nil {
+		return rec, err
+	}
+	if sum <= 0 {
+		return rec, fmt.Errorf("Sum must be greater than zero")
+	}
+	rec.Sum = fmt.Sprintf("%.2f", sum)
+
+	rec.Category = orDefault(field(columns.category), opts.Defaults.Category)
+	rec.Venue = orDefault(field(columns.venue), opts.Defaults.Venue)
+	rec.Shopper = orDefault(field(columns.shopper), opts.Defaults.Shopper)
+	return rec, nil
+}
+
+func isEmptyRow(row []string) bool {
+	for _, f := range row {
+		if strings.TrimSpace(f) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+ParseAmount parses an amount using the provided separators. Whitespace and currency symbols around the amount are
+ignored.
+*/
+func ParseAmount(value string, decimalSeparator string, thousandsSeparator string) (float64, error) {
+	cleaned := strings.TrimFunc(value, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.Is(unicode.Sc, r) || unicode.IsLetter(r)
+	})
+	if thousandsSeparator != "" {
+		cleaned = strings.Replace(cleaned, thousandsSeparator, "", -1)
+	}
+	if decimalSeparator != "." {
+		if strings.Contains(cleaned, ".") {
+			return 0, fmt.Errorf("Invalid amount '%s'", value)
+		}
+		cleaned = strings.Replace(cleaned, decimalSeparator, ".", 1)
+	}
+
+	res, err := strconv.ParseFloat(cleaned, 64)
+	if err != nil || cleaned == "" {
+		return 0, fmt.Errorf("Invalid amount '%s'", value)
+	}
+	return res, nil
+}
+
+/*
+DateLayout converts a date format written with the tokens YYYY, YY, MM, M, DD and D to a layout for time.Parse. All
+other characters are taken literally.
+*/
+func DateLayout(format string) (string, error) {
+	tokens := []struct {
+		token  string
+		layout string
+	}{
+		{"YYYY", "2006"}, {"YY", "06"}, {"MM", "01"}, {"M", "1"}, {"DD", "02"}, {"D", "2"},
+	}
+
+	var layout strings.Builder
+	hasYear, hasMonth, hasDay := false, false, false
+	for i := 0; i < len(format); {
+		matched := false
+		for _, t := range tokens {
+			if strings.HasPrefix(format[i:], t.token) {
+				layout.WriteString(t.layout)
+				hasYear = hasYear || t.token[0] == 'Y'
+				hasMonth = hasMonth || t.token[0] == 'M'
+				hasDay = hasDay || t.token[0] == 'D'
+				i += len(t.token)
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		if strings.ContainsAny(format[i:i+1], "0123456789") {
+			return "", fmt.Errorf("Invalid date format '%s'", format)
+		}
+		layout.WriteByte(format[i])
+		i++
+	}
+
+	if !hasYear || !hasMonth || !hasDay {
+		return "", fmt.Errorf("Date format '%s' must contain year, month and day", format)
+	}
+	return layout.String(), nil
+}
@@ -0,0 +1,151 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package importer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDateLayout(t *testing.T) {
+	cases := map[string]string{
+		"YYYY-MM-DD": "2006-01-02",
+		"DD.MM.YYYY": "02.01.2006",
+		"M/D/YY":     "1/2/06",
+	}
+	for format, expected := range cases {
+		layout, err := DateLayout(format)
+		if err != nil || layout != expected {
+			t.Errorf("DateLayout returns '%s' (%v) instead of expected '%s' for '%s'", layout, err, expected, format)
+		}
+	}
+
+	for _, format := range []string{"MM-DD", "YYYY-MM-01", ""} {
+		if _, err := DateLayout(format); err == nil {
+			t.Errorf("DateLayout returns no error for invalid format '%s'", format)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	cases := []struct {
+		value     string
+		decimal   string
+		thousands string
+		expected  float64
+	}{
+		{"12.50", ".", "", 12.5},
+		{"12,50 €", ",", "", 12.5},
+		{"€ 1.234,56", ",", ".", 1234.56},
+		{"1,234.56 EUR", ".", ",", 1234.56},
+	}
+	for _, c := range cases {
+		v, err := ParseAmount(c.value, c.decimal, c.thousands)
+		if err != nil || v != c.expected {
+			t.Errorf("ParseAmount returns %f (%v) instead of expected %f for '%s'", v, err, c.expected, c.value)
+		}
+	}
+
+	for _, value := range []string{"", "abc", "1.234,56"} {
+		if _, err := ParseAmount(value, ",", ""); err == nil {
+			t.Errorf("ParseAmount returns no error for invalid amount '%s'", value)
+		}
+	}
+}
+
+func TestReadCsv(t *testing.T) {
+	data := "Datum;Betrag;Laden;Kategorie\n" +
+		"03.10.2026;12,50 €;Bakery;Food\n" +
+		"\n" +
+		"04.10.2026;-3,00;Bakery;Food\n" +
+		"2026-10-05;1,00;Bakery;\n" +
+		"06.10.2026;7;Market;\n" +
+		"07.10.2026;2,00;\"Corner\nShop\";Food\n" +
+		"08.10.2026;x;Market;\n"
+	opts := CsvOptions{
+		Mapping:          CsvMapping{Date: "datum", Sum: "Betrag", Venue: "Laden", Category: "Kategorie"},
+		Defaults:         CsvDefaults{Category: "Misc", Shopper: "Alex"},
+		Delimiter:        ";",
+		DateFormat:       "DD.MM.YYYY",
+		DecimalSeparator: ",",
+	}
+
+	records, rowErrors, err := ReadCsv(strings.NewReader(data), opts)
+	if err != nil {
+		t.Fatalf("ReadCsv returns error: %s", err)
+	}
+
+	if len(records) != 3 {
+		t.Fatalf("ReadCsv returns %d records instead of expected 3", len(records))
+	}
+	r := records[0]
+	if r.Line != 2 || r.Date != "2026-10-03" || r.Sum != "12.50" || r.Venue != "Bakery" || r.Category != "Food" || r.Shopper != "Alex" {
+		t.Errorf("ReadCsv returns %+v instead of expected first record", r)
+	}
+	if records[1].Line != 6 || records[1].Category != "Misc" || records[1].Sum != "7.00" {
+		t.Errorf("ReadCsv returns %+v instead of expected record with default category", records[1])
+	}
+	if records[2].Line != 7 || records[2].Venue != "Corner\nShop" {
+		t.Errorf("ReadCsv returns %+v instead of expected record with multi-line venue", records[2])
+	}
+
+	// Lines are those of the file, including the empty line and the multi-line field
+	lines := make([]int, 0)
+	for _, e := range rowErrors {
+		lines = append(lines, e.Line)
+	}
+	if fmt.Sprint(lines) != "[4 5 9]" {
+		t.Errorf("ReadCsv returns row errors on lines %v instead of expected [4 5 9]", lines)
+	}
+}
+
+func TestReadCsvInvalidFile(t *testing.T) {
+	if _, _, err := ReadCsv(strings.NewReader("date,amount\n"), CsvOptions{}); err == nil {
+		t.Error("ReadCsv returns no error for missing sum column")
+	}
+	if _, _, err := ReadCsv(strings.NewReader(""), CsvOptions{}); err == nil {
+		t.Error("ReadCsv returns no error for empty file")
+	}
+	if _, _, err := ReadCsv(strings.NewReader("date,sum\n"), CsvOptions{Mapping: CsvMapping{Venue: "shop"}}); err == nil {
+		t.Error("ReadCsv returns no error for missing mapped venue column")
+	}
+	if _, _, err := ReadCsv(strings.NewReader("date,sum\n"), CsvOptions{DecimalSeparator: ",", ThousandsSeparator: ","}); err == nil {
+		t.Error("ReadCsv returns no error for equal decimal and thousands separators")
+	}
+	_, _, err := ReadCsv(strings.NewReader("date,sum\n2026-10-03,1.00\n\n2026-10-04,\"1.00\n"), CsvOptions{})
+	if err == nil || !strings.HasPrefix(err.Error(), "Line 4:") {
+		t.Errorf("ReadCsv returns error '%v' instead of expected error on line 4", err)
+	}
+}
+
+func TestNameIndex(t *testing.T) {
+	n := newNameIndex()
+	n.add("Bakery", "k1")
+
+	if k := n.lookup(" bakery "); k != "k1" {
+		t.Errorf("lookup returns '%s' instead of expected 'k1'", k)
+	}
+	n.lookup("Market")
+	n.lookup("MARKET")
+	if len(n.missing) != 1 || n.missing[0] != "Market" {
+		t.Errorf("lookup records missing names %v instead of expected [Market]", n.missing)
+	}
+
+	n.create(func(name string) (string, error) { return "k2", nil })
+	if k := n.lookup("market"); k != "k2" {
+		t.Errorf("lookup returns '%s' instead of expected 'k2' after create", k)
+	}
+
+	removed := make([]string, 0)
+	n.remove(func(key string) error {
+		removed = append(removed, key)
+		return nil
+	})
+	if len(removed) != 1 || removed[0] != "k2" {
+		t.Errorf("remove removes %v instead of expected [k2]", removed)
+	}
+}
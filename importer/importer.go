@@ -0,0 +1,303 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package importer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mandrakey/shoptrac/config"
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+/*
+A Record is a purchase read from an import file. Category, venue and shopper are given by name and resolved to the
+existing documents, or created if there are none with that name.
+*/
+type Record struct {
+	Line     int
+	Date     string
+	Sum      string
+	Category string
+	Venue    string
+	Shopper  string
+}
+
+type RowError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+/*
+Names of categories, venues and shoppers which do not exist yet.
+*/
+type NewNames struct {
+	Categories []string `json:"categories"`
+	Venues     []string `json:"venues"`
+	Shoppers   []string `json:"shoppers"`
+}
+
+/*
+A Result describes an import. In a dry run, or if any row is invalid, nothing is imported.
+*/
+type Result struct {
+	DryRun   bool       `json:"dry_run"`
+	Rows     int        `json:"rows"`
+	Valid    int        `json:"valid"`
+	Imported int        `json:"imported"`
+	Errors   []RowError `json:"errors"`
+	Created  NewNames   `json:"created"`
+
+	// Set if the purchases have been stored, but updating aggregates or stock failed
+	Warning string `json:"warning,omitempty"`
+
+	// The stored purchases, e.g. to evaluate alert rules
+	Purchases []repository.Purchase `json:"-"`
+}
+
+/*
+Resolves names to keys, ignoring case and surrounding whitespace. Names which can not be resolved are collected in the
+order they first appear.
+*/
+type nameIndex struct {
+	keys    map[string]string
+	missing []string
+	created []string
+}
+
+func newNameIndex() *nameIndex {
+	return &nameIndex{keys: make(map[string]string), missing: make([]string, 0)}
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+func (n *nameIndex) add(name string, key string) {
+	n.keys[normalizeName(name)] = key
+}
+
+/*
+Returns the key of the name. Missing names are recorded and get a placeholder key, so purchases referring to them can
+still be validated.
+*/
+func (n *nameIndex) lookup(name string) string {
+	if strings.TrimSpace(name) == "" {
+		return ""
+	}
+
+	normalized := normalizeName(name)
+	if key, ok := n.keys[normalized]; ok {
+		return key
+	}
+
+	n.missing = append(n.missing, strings.TrimSpace(name))
+	n.keys[normalized] = "new:" + normalized
+	return n.keys[normalized]
+}
+
+/*
+Creates all missing names with the provided function and replaces the placeholder keys. The keys of the created
+documents are kept, so they can be removed again.
+*/
+func (n *nameIndex) create(fn func(name string) (string, error)) error {
+	for _, name := range n.missing {
+		key, err := fn(name)
+		if err != nil {
+			return fmt.Errorf("Failed to create '%s': %s", name, err)
+		}
+		n.add(name, key)
+		n.created = append(n.created, key)
+	}
+	return nil
+}
+
+/*
+Removes the documents created before with the provided function.
+*/
+func (n *nameIndex) remove(fn func(key string) error) {
+	log := config.Logger()
+
+	for _, key := range n.created {
+		err := fn(key)
+		if err != nil {
+			log.Errorf("Failed to remove '%s' created for import: %s", key, err)
+		}
+	}
+	n.created = nil
+}
+
+/*
+Holds the name indexes of categories, venues and shoppers.
+*/
+type resolver struct {
+	categories *nameIndex
+	venues     *nameIndex
+	shoppers   *nameIndex
+}
+
+func newResolver() (*resolver, error) {
+	r := resolver{categories: newNameIndex(), venues: newNameIndex(), shoppers: newNameIndex()}
+
+	categories, err := repository.GetCategories()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range *categories {
+		r.categories.add(c.Name, c.Key)
+	}
+
+	venues, err := repository.GetVenues()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range *venues {
+		r.venues.add(v.Name, v.Key)
+	}
+
+	shoppers, err := repository.GetShoppers()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range *shoppers {
+		r.shoppers.add(s.Name, s.Key)
+	}
+
+	return &r, nil
+}
+
+func (r *resolver) purchase(rec Record) repository.Purchase {
+	return repository.Purchase{
+		Date:     rec.Date,
+		Sum:      rec.Sum,
+		Category: r.categories.lookup(rec.Category),
+		Venue:    r.venues.lookup(rec.Venue),
+		Shopper:  r.shoppers.lookup(rec.Shopper),
+	}
+}
+
+/*
+Creates the missing categories, venues and shoppers.
+*/
+func (r *resolver) createMissing() error {
+	err := r.categories.create(func(name string) (string, error) {
+		c, err := repository.AddCategory(name)
+		if err != nil {
+			return "", err
+		}
+		return c.Key, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = r.venues.create(func(name string) (string, error) {
+		v, err := repository.AddVenue(name, "")
+		if err != nil {
+			return "", err
+		}
+		return v.Key, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return r.shoppers.create(func(name string) (string, error) {
+		s, err := repository.AddShopper(name, "")
+		if err != nil {
+			return "", err
+		}
+		return s.Key, nil
+	})
+}
+
+/*
+Removes the categories, venues and shoppers created by createMissing, if the purchases could not be stored.
+*/
+func (r *resolver) removeCreated() {
+	r.categories.remove(repository.DeleteCategory)
+	r.venues.remove(repository.DeleteVenue)
+	r.shoppers.remove(repository.DeleteShopper)
+}
+
+/*
+Import validates the records and, unless this is a dry run or any row is invalid, creates the missing categories, venues
+and shoppers and stores all purchases in one batch. If the batch fails, the created categories, venues and shoppers
+are removed again. If the purchases have been stored but updating aggregates or stock fails, the import succeeds with a
+warning in the result. Row errors found while reading the file are included in the result.
+*/
+func Import(records []Record, rowErrors []RowError, dryRun bool) (*Result, error) {
+	res := Result{DryRun: dryRun, Rows: len(records) + len(rowErrors), Errors: rowErrors}
+
+	r, err := newResolver()
+	if err != nil {
+		return nil, err
+	}
+
+	valid, validationErrors := validateRecords(r, records)
+	res.Errors = append(res.Errors, validationErrors...)
+	sort.SliceStable(res.Errors, func(i, j int) bool { return res.Errors[i].Line < res.Errors[j].Line })
+	res.Valid = len(valid)
+	res.Created = NewNames{Categories: r.categories.missing, Venues: r.venues.missing, Shoppers: r.shoppers.missing}
+
+	if dryRun || len(res.Errors) > 0 || len(valid) == 0 {
+		return &res, nil
+	}
+
+	// ----
+	// Commit
+
+	err = r.createMissing()
+	if err != nil {
+		r.removeCreated()
+		return nil, err
+	}
+
+	purchases := make([]repository.Purchase, len(valid))
+	for i, rec := range valid {
+		purchases[i] = r.purchase(rec)
+	}
+	keys, err := repository.AddPurchases(purchases)
+	if keys == nil && err != nil {
+		// None of the purchases has been stored, so neither should their categories, venues and shoppers
+		r.removeCreated()
+		return nil, err
+	}
+	res.Imported = len(keys)
+	res.Purchases = purchases
+
+	// The purchases are stored, importing the file again would duplicate them
+	if err != nil {
+		config.Logger().Warningf("Imported %d purchases, but failed to update statistics or stock: %s", len(keys), err)
+		res.Warning = fmt.Sprintf("The purchases have been imported, but updating statistics or stock failed: %s", err)
+	}
+
+	return &res, nil
+}
+
+/*
+Returns the records which resolve to valid purchases, and the errors of all others.
+*/
+func validateRecords(r *resolver, records []Record) ([]Record, []RowError) {
+	valid := make([]Record, 0, len(records))
+	errors := make([]RowError, 0)
+
+	for _, rec := range records {
+		p := r.purchase(rec)
+
+		// The key is generated when storing the purchase
+		p.Key = "import"
+		err := repository.ValidatePurchase(&p)
+		if err != nil {
+			errors = append(errors, RowError{Line: rec.Line, Message: err.Error()})
+			continue
+		}
+		valid = append(valid, rec)
+	}
+
+	return valid, errors
+}
@@ -0,0 +1,205 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	rxMt940Tag       = regexp.MustCompile(`^:(\d{2}[A-Z]?):(.*)$`)
+	rxMt940Statement = regexp.MustCompile(`^(\d{6})(\d{4})?(RC|RD|C|D)([A-Z])?(\d+,\d*)[NFS][A-Z0-9]{3}([^/]*)(//(.*))?`)
+	rxMt940Balance   = regexp.MustCompile(`^[CD]\d{6}([A-Z]{3})`)
+)
+
+type mt940Field struct {
+	tag   string
+	value string
+}
+
+/*
+ParseMt940 reads the debit statement lines (field 61) of an MT940 file. The information to the account owner (field 86)
+is read in the structured format used by German banks if possible, otherwise it is taken as description and
+counterparty alike.
+*/
+func ParseMt940(data []byte) ([]Transaction, error) {
+	fields, err := mt940Fields(data)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]Transaction, 0)
+	currency := ""
+	var current *Transaction
+	debit := false
+	flush := func() {
+		if current != nil && debit {
+			res = append(res, *current)
+		}
+		current = nil
+	}
+
+	for _, f := range fields {
+		switch f.tag {
+		case "60F", "60M":
+			if m := rxMt940Balance.FindStringSubmatch(f.value); m != nil {
+				currency = m[1]
+			}
+
+		case "61":
+			flush()
+			t, isDebit, err := parseMt940Statement(f.value)
+			if err != nil {
+				return nil, err
+			}
+			t.Currency = currency
+			current, debit = &t, isDebit
+
+		case "86":
+			if current != nil {
+				current.Counterparty, current.Description = parseMt940Information(f.value)
+			}
+			flush()
+
+		case "62F", "62M":
+			flush()
+		}
+	}
+	flush()
+
+	return res, nil
+}
+
+/*
+Splits the file into its fields. Lines not starting with a tag continue the previous field, SWIFT block headers and
+message separators are skipped.
+*/
+func mt940Fields(data []byte) ([]mt940Field, error) {
+	res := make([]mt940Field, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if m := rxMt940Tag.FindStringSubmatch(line); m != nil {
+			res = append(res, mt940Field{tag: m[1], value: m[2]})
+			continue
+		}
+		if line == "-" || line == "" || strings.HasPrefix(line, "{") {
+			continue
+		}
+		if len(res) > 0 {
+			res[len(res)-1].value += "\n" + line
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Invalid MT940 statement: %s", err)
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("Invalid MT940 statement: no fields found")
+	}
+	return res, nil
+}
+
+/*
+Reads a statement line like "2610031003DR12,50NMSCNONREF//2610031234". The booking date (MMDD after the value date) is
+used if given, otherwise the value date.
+*/
+func parseMt940Statement(value string) (Transaction, bool, error) {
+	var t Transaction
+	line := strings.SplitN(value, "\n", 2)[0]
+	m := rxMt940Statement.FindStringSubmatch(line)
+	if m == nil {
+		return t, false, fmt.Errorf("Invalid MT940 statement line '%s'", line)
+	}
+
+	valueDate, err := time.Parse("060102", m[1])
+	if err != nil {
+		return t, false, fmt.Errorf("Invalid date in MT940 statement line '%s'", line)
+	}
+	t.Date = valueDate
+	if m[2] != "" {
+		t.Date, err = mt940BookingDate(valueDate, m[2])
+		if err != nil {
+			return t, false, fmt.Errorf("Invalid booking date in MT940 statement line '%s'", line)
+		}
+	}
+
+	t.Amount, err = strconv.ParseFloat(strings.Replace(m[5], ",", ".", 1), 64)
+	if err != nil {
+		return t, false, fmt.Errorf("Invalid amount in MT940 statement line '%s'", line)
+	}
+	t.Id = strings.TrimSpace(m[8])
+
+	return t, m[3] == "D", nil
+}
+
+/*
+The booking date has no year. It is taken from the value date, which may lie in the previous or next year around new
+year.
+*/
+func mt940BookingDate(valueDate time.Time, mmdd string) (time.Time, error) {
+	d, err := time.Parse("0102", mmdd)
+	if err != nil {
+		return d, err
+	}
+
+	year := valueDate.Year()
+	if d.Month() == time.January && valueDate.Month() == time.December {
+		year++
+	} else if d.Month() == time.December && valueDate.Month() == time.January {
+		year--
+	}
+	return time.Date(year, d.Month(), d.Day(), 0, 0, 0, 0, time.UTC), nil
+}
+
+/*
+Reads the counterparty and description from field 86. Structured fields start with a three digit transaction code
+followed by sub fields like "?20purpose?32name", where 20 to 29 and 60 to 63 hold the purpose and 32 and 33 the name of
+the counterparty.
+*/
+func parseMt940Information(value string) (string, string) {
+	joined := strings.Replace(value, "\n", "", -1)
+	if len(joined) < 4 || !isDigits(joined[:3]) || isDigits(joined[3:4]) {
+		text := cleanText(value)
+		return text, text
+	}
+
+	sep := joined[3:4]
+	var name, purpose []string
+	for _, sub := range strings.Split(joined[4:], sep) {
+		if len(sub) < 2 || !isDigits(sub[:2]) {
+			continue
+		}
+		code, _ := strconv.Atoi(sub[:2])
+		switch {
+		case code == 32 || code == 33:
+			name = append(name, sub[2:])
+		case (code >= 20 && code <= 29) || (code >= 60 && code <= 63):
+			purpose = append(purpose, sub[2:])
+		}
+	}
+
+	description := cleanText(strings.Join(purpose, ""))
+	counterparty := cleanText(strings.Join(name, ""))
+	if counterparty == "" {
+		counterparty = description
+	}
+	return counterparty, description
+}
+
+func isDigits(value string) bool {
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return value != ""
+}
@@ -0,0 +1,92 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package importer
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	rxOfxTransaction = regexp.MustCompile(`(?is)<STMTTRN>(.*?)</STMTTRN>`)
+	rxOfxElement     = regexp.MustCompile(`(?i)<([A-Z0-9.]+)>([^<\r\n]*)`)
+	rxOfxCurrency    = regexp.MustCompile(`(?i)<CURDEF>\s*([A-Z]{3})`)
+)
+
+/*
+ParseOfx reads the debit transactions of an OFX statement. Both the SGML based version 1, where elements are not closed,
+and the XML based version 2 are supported.
+*/
+func ParseOfx(data []byte) ([]Transaction, error) {
+	content := string(data)
+	blocks := rxOfxTransaction.FindAllStringSubmatch(content, -1)
+	if blocks == nil && !strings.Contains(strings.ToUpper(content), "<OFX>") {
+		return nil, fmt.Errorf("Invalid OFX statement: no OFX element found")
+	}
+
+	currency := ""
+	if m := rxOfxCurrency.FindStringSubmatch(content); m != nil {
+		currency = strings.ToUpper(m[1])
+	}
+
+	res := make([]Transaction, 0)
+	for i, block := range blocks {
+		elements := make(map[string]string)
+		for _, m := range rxOfxElement.FindAllStringSubmatch(block[1], -1) {
+			name := strings.ToUpper(m[1])
+			if _, ok := elements[name]; !ok {
+				elements[name] = strings.TrimSpace(ofxUnescape(m[2]))
+			}
+		}
+
+		amount, err := strconv.ParseFloat(strings.Replace(elements["TRNAMT"], ",", ".", 1), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid amount '%s' in transaction %d", elements["TRNAMT"], i+1)
+		}
+		if amount >= 0 {
+			continue
+		}
+
+		date, err := parseOfxDate(elements["DTPOSTED"])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid date '%s' in transaction %d", elements["DTPOSTED"], i+1)
+		}
+
+		t := Transaction{
+			Id:           elements["FITID"],
+			Date:         date,
+			Amount:       -amount,
+			Currency:     currency,
+			Counterparty: cleanText(elements["NAME"]),
+			Description:  cleanText(elements["MEMO"]),
+		}
+		if t.Counterparty == "" {
+			t.Counterparty = t.Description
+		}
+		res = append(res, t)
+	}
+
+	return res, nil
+}
+
+/*
+OFX dates look like "20261003", optionally followed by a time, fractions and a time zone like "120000.000[-5:EST]". Only
+the date is used.
+*/
+func parseOfxDate(value string) (time.Time, error) {
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("date too short")
+	}
+	return time.Parse("20060102", value[:8])
+}
+
+func ofxUnescape(value string) string {
+	r := strings.NewReplacer("&lt;", "<", "&gt;", ">", "&quot;", "\"", "&apos;", "'", "&nbsp;", " ", "&amp;", "&")
+	return r.Replace(value)
+}
@@ -0,0 +1,159 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package importer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+const (
+	FORMAT_CAMT053 = "camt053"
+	FORMAT_MT940   = "mt940"
+	FORMAT_OFX     = "ofx"
+)
+
+/*
+A Transaction is a booking read from a bank statement. Only debits are returned by the parsers, with the amount as a
+positive number.
+*/
+type Transaction struct {
+	Id           string
+	Date         time.Time
+	Amount       float64
+	Currency     string
+	Counterparty string
+	Description  string
+}
+
+/*
+Describes the import of a bank statement. Transactions imported before are skipped, so statements may overlap.
+*/
+type StatementResult struct {
+	Format       string `json:"format"`
+	Transactions int    `json:"transactions"`
+	Added        int    `json:"added"`
+	Skipped      int    `json:"skipped"`
+	Matched      int    `json:"matched"`
+}
+
+/*
+ParseStatement reads the debit transactions of a bank statement. If no format is given, it is detected from the content.
+*/
+func ParseStatement(data []byte, format string) ([]Transaction, string, error) {
+	if format == "" {
+		format = DetectFormat(data)
+	}
+
+	var res []Transaction
+	var err error
+	switch format {
+	case FORMAT_CAMT053:
+		res, err = ParseCamt053(data)
+	case FORMAT_MT940:
+		res, err = ParseMt940(data)
+	case FORMAT_OFX:
+		res, err = ParseOfx(data)
+	case "":
+		return nil, "", fmt.Errorf("Unknown statement format, expected CAMT.053, MT940 or OFX")
+	default:
+		return nil, "", fmt.Errorf("Invalid statement format '%s'", format)
+	}
+	return res, format, err
+}
+
+/*
+DetectFormat guesses the format of a bank statement. Returns an empty string if the format is unknown.
+*/
+func DetectFormat(data []byte) string {
+	head := data
+	if len(head) > 4096 {
+		head = head[:4096]
+	}
+
+	switch {
+	case bytes.Contains(head, []byte("camt.053")) || bytes.Contains(head, []byte("<BkToCstmrStmt")):
+		return FORMAT_CAMT053
+	case bytes.Contains(head, []byte("OFXHEADER")) || bytes.Contains(bytes.ToUpper(head), []byte("<OFX>")):
+		return FORMAT_OFX
+	case bytes.Contains(data, []byte(":20:")) && bytes.Contains(data, []byte(":61:")):
+		return FORMAT_MT940
+	}
+	return ""
+}
+
+/*
+AddCandidates adds the transactions read from a bank statement to the review queue. Venues are assigned by the venue
+rules matching the counterparty.
+*/
+func AddCandidates(transactions []Transaction, format string, userKey string) (*StatementResult, error) {
+	rules, err := repository.GetVenueRules()
+	if err != nil {
+		return nil, err
+	}
+
+	res := StatementResult{Format: format, Transactions: len(transactions)}
+	candidates := make([]repository.ImportCandidate, 0, len(transactions))
+	for _, c := range Candidates(transactions, format, *rules) {
+		c.UserKey = userKey
+		if c.Venue != "" {
+			res.Matched++
+		}
+		candidates = append(candidates, c)
+	}
+
+	res.Added, err = repository.AddImportCandidates(candidates)
+	if err != nil {
+		return nil, err
+	}
+	res.Skipped = len(candidates) - res.Added
+
+	return &res, nil
+}
+
+/*
+Candidates turns transactions into pending candidates for purchases. Their keys are derived from the transactions, so
+importing the same transaction again results in the same key.
+*/
+func Candidates(transactions []Transaction, format string, rules []repository.VenueRule) []repository.ImportCandidate {
+	res := make([]repository.ImportCandidate, 0, len(transactions))
+	seen := make(map[string]int)
+
+	for _, t := range transactions {
+		// Transactions without an id are told apart by their position among identical transactions
+		id := t.Id
+		if id == "" {
+			id = fmt.Sprintf("%s/%.2f/%s/%s", repository.DateToDb(t.Date), t.Amount, t.Counterparty, t.Description)
+			seen[id]++
+			id = fmt.Sprintf("%s/%d", id, seen[id])
+		}
+
+		res = append(res, repository.ImportCandidate{
+			Key:          fmt.Sprintf("%x", sha256.Sum256([]byte(format+"/"+id))),
+			Status:       repository.CANDIDATE_STATUS_PENDING,
+			Date:         repository.DateToDb(t.Date),
+			Sum:          fmt.Sprintf("%.2f", t.Amount),
+			Currency:     t.Currency,
+			Counterparty: t.Counterparty,
+			Description:  t.Description,
+			Venue:        repository.MatchVenueRule(rules, t.Counterparty),
+		})
+	}
+
+	return res
+}
+
+/*
+Collapses runs of whitespace, which statements often use to pad or wrap texts.
+*/
+func cleanText(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
@@ -0,0 +1,191 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package importer
+
+import (
+	"testing"
+
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+const testCamt053 = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02">
+  <BkToCstmrStmt>
+    <Stmt>
+      <Ntry>
+        <Amt Ccy="EUR">23.45</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <Sts>BOOK</Sts>
+        <BookgDt><Dt>2026-10-03</Dt></BookgDt>
+        <ValDt><Dt>2026-10-04</Dt></ValDt>
+        <AcctSvcrRef>REF-1</AcctSvcrRef>
+        <NtryDtls><TxDtls>
+          <RltdPties><Cdtr><Nm>BAKERY   MILLER</Nm></Cdtr></RltdPties>
+          <RmtInf><Ustrd>Card payment</Ustrd><Ustrd>2026-10-03</Ustrd></RmtInf>
+        </TxDtls></NtryDtls>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="EUR">1500.00</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+        <Sts>BOOK</Sts>
+        <BookgDt><Dt>2026-10-01</Dt></BookgDt>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="EUR">9.99</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <Sts>PDNG</Sts>
+        <BookgDt><Dt>2026-10-05</Dt></BookgDt>
+      </Ntry>
+    </Stmt>
+  </BkToCstmrStmt>
+</Document>`
+
+const testMt940 = `:20:STARTUMS
+:25:12345678/0123456789
+:28C:00001/001
+:60F:C260930EUR1234,56
+:61:2610031003DR12,50NMSCNONREF//2610031234
+:86:106?00KARTENZAHLUNG?20SVWZ+Einkauf Filia?21le 12?32SUPERMARKT
+?33 NORD
+:61:2610041004CR100,00NMSCNONREF
+:86:166?00GUTSCHRIFT?32ALEX
+:61:2612300102D7,00NMSCNONREF
+:86:Coffee shop
+:62F:C261005EUR1315,06
+-`
+
+const testOfx = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+
+<OFX>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<CURDEF>EUR
+<BANKTRANLIST>
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20261003120000.000[-5:EST]
+<TRNAMT>-42.10
+<FITID>4711
+<NAME>Hardware &amp; More
+<MEMO>Card payment
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>CREDIT
+<DTPOSTED>20261004
+<TRNAMT>10.00
+<FITID>4712
+<NAME>Refund
+</STMTTRN>
+</BANKTRANLIST>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>`
+
+func TestDetectFormat(t *testing.T) {
+	cases := map[string]string{
+		testCamt053: FORMAT_CAMT053,
+		testMt940:   FORMAT_MT940,
+		testOfx:     FORMAT_OFX,
+		"date,sum":  "",
+	}
+	for data, expected := range cases {
+		if f := DetectFormat([]byte(data)); f != expected {
+			t.Errorf("DetectFormat returns '%s' instead of expected '%s'", f, expected)
+		}
+	}
+}
+
+func TestParseCamt053(t *testing.T) {
+	res, err := ParseCamt053([]byte(testCamt053))
+	if err != nil {
+		t.Fatalf("ParseCamt053 returns error: %s", err)
+	}
+	if len(res) != 1 {
+		t.Fatalf("ParseCamt053 returns %d transactions instead of expected 1 booked debit", len(res))
+	}
+
+	tr := res[0]
+	if tr.Id != "REF-1" || repository.DateToDb(tr.Date) != "2026-10-03" || tr.Amount != 23.45 || tr.Currency != "EUR" {
+		t.Errorf("ParseCamt053 returns %+v instead of expected transaction", tr)
+	}
+	if tr.Counterparty != "BAKERY MILLER" || tr.Description != "Card payment 2026-10-03" {
+		t.Errorf("ParseCamt053 returns counterparty '%s' and description '%s'", tr.Counterparty, tr.Description)
+	}
+}
+
+func TestParseMt940(t *testing.T) {
+	res, err := ParseMt940([]byte(testMt940))
+	if err != nil {
+		t.Fatalf("ParseMt940 returns error: %s", err)
+	}
+	if len(res) != 2 {
+		t.Fatalf("ParseMt940 returns %d transactions instead of expected 2 debits", len(res))
+	}
+
+	tr := res[0]
+	if tr.Id != "2610031234" || repository.DateToDb(tr.Date) != "2026-10-03" || tr.Amount != 12.5 || tr.Currency != "EUR" {
+		t.Errorf("ParseMt940 returns %+v instead of expected transaction", tr)
+	}
+	if tr.Counterparty != "SUPERMARKT NORD" || tr.Description != "SVWZ+Einkauf Filiale 12" {
+		t.Errorf("ParseMt940 returns counterparty '%s' and description '%s'", tr.Counterparty, tr.Description)
+	}
+
+	// Booked in the next year
+	tr = res[1]
+	if repository.DateToDb(tr.Date) != "2027-01-02" || tr.Counterparty != "Coffee shop" || tr.Id != "" {
+		t.Errorf("ParseMt940 returns %+v instead of expected unstructured transaction", tr)
+	}
+}
+
+func TestParseOfx(t *testing.T) {
+	res, err := ParseOfx([]byte(testOfx))
+	if err != nil {
+		t.Fatalf("ParseOfx returns error: %s", err)
+	}
+	if len(res) != 1 {
+		t.Fatalf("ParseOfx returns %d transactions instead of expected 1 debit", len(res))
+	}
+
+	tr := res[0]
+	if tr.Id != "4711" || repository.DateToDb(tr.Date) != "2026-10-03" || tr.Amount != 42.1 || tr.Currency != "EUR" {
+		t.Errorf("ParseOfx returns %+v instead of expected transaction", tr)
+	}
+	if tr.Counterparty != "Hardware & More" || tr.Description != "Card payment" {
+		t.Errorf("ParseOfx returns counterparty '%s' and description '%s'", tr.Counterparty, tr.Description)
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	transactions, err := ParseMt940([]byte(testMt940))
+	if err != nil {
+		t.Fatalf("ParseMt940 returns error: %s", err)
+	}
+	// The same unidentified transaction twice
+	transactions = append(transactions, transactions[1])
+
+	rules := []repository.VenueRule{{Pattern: "supermarkt", Venue: "v1"}}
+	res := Candidates(transactions, FORMAT_MT940, rules)
+
+	if len(res) != 3 {
+		t.Fatalf("Candidates returns %d candidates instead of expected 3", len(res))
+	}
+	if res[0].Venue != "v1" || res[0].Sum != "12.50" || res[0].Status != repository.CANDIDATE_STATUS_PENDING {
+		t.Errorf("Candidates returns %+v instead of expected matched candidate", res[0])
+	}
+	if res[1].Venue != "" {
+		t.Errorf("Candidates assigns venue '%s' to unmatched counterparty", res[1].Venue)
+	}
+	if res[1].Key == res[2].Key {
+		t.Error("Candidates returns the same key for repeated transactions")
+	}
+
+	again := Candidates(transactions, FORMAT_MT940, rules)
+	for i := range res {
+		if res[i].Key != again[i].Key {
+			t.Errorf("Candidates returns key %s instead of expected %s on second import", again[i].Key, res[i].Key)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package notification
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/mandrakey/shoptrac/config"
+)
+
+const (
+	CHANNEL_EMAIL   = "email"
+	CHANNEL_WEBHOOK = "webhook"
+)
+
+type Notification struct {
+	Subject string                 `json:"subject"`
+	Body    string                 `json:"body"`
+	Data    map[string]interface{} `json:"data"`
+}
+
+/*
+A Channel delivers notifications to a target, e.g. an email address or a URL. Send rejects targets which
+ValidateTarget does not accept.
+*/
+type Channel interface {
+	ValidateTarget(target string) error
+	Send(target string, n Notification) error
+}
+
+var (
+	channels   = make(map[string]Channel)
+	channelsMu sync.RWMutex
+)
+
+/*
+SetupChannels registers the built-in email and webhook channels using the provided configuration.
+*/
+func SetupChannels(cfg *config.AppConfig) {
+	RegisterChannel(CHANNEL_EMAIL, NewSmtpChannel(cfg.Smtp))
+	RegisterChannel(CHANNEL_WEBHOOK, NewWebhookChannel(cfg.Webhook))
+}
+
+func RegisterChannel(name string, ch Channel) {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+	channels[name] = ch
+}
+
+func GetChannel(name string) (Channel, error) {
+	channelsMu.RLock()
+	defer channelsMu.RUnlock()
+
+	ch, ok := channels[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown notification channel '%s'", name)
+	}
+	return ch, nil
+}
+
+/*
+ValidateTarget checks whether the target can be used with the channel of the provided name.
+*/
+func ValidateTarget(channel string, target string) error {
+	ch, err := GetChannel(channel)
+	if err != nil {
+		return err
+	}
+
+	return ch.ValidateTarget(target)
+}
+
+func Send(channel string, target string, n Notification) error {
+	ch, err := GetChannel(channel)
+	if err != nil {
+		return err
+	}
+
+	return ch.Send(target, n)
+}
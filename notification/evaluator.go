@@ -0,0 +1,163 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package notification
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mandrakey/shoptrac/config"
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+/*
+EvaluatePurchase checks all alert rules after the provided purchase has been written and delivers the notifications of
+all rules that fire. Each alert is delivered only once per purchase (purchase rules) or per month (budget rules). It is
+meant to run in the background, so errors are logged only.
+*/
+func EvaluatePurchase(p repository.Purchase) {
+	EvaluatePurchases([]repository.Purchase{p})
+}
+
+/*
+EvaluatePurchases works like EvaluatePurchase for a batch of purchases written at once, e.g. by an import. Purchase
+rules are checked for every purchase, budget rules only once per month and category affected by the batch.
+*/
+func EvaluatePurchases(purchases []repository.Purchase) {
+	log := config.Logger()
+
+	if len(purchases) == 0 {
+		return
+	}
+
+	rules, err := repository.GetAlertRules()
+	if err != nil {
+		log.Errorf("Failed to load alert rules: %s", err)
+		return
+	}
+
+	samples := budgetSamples(purchases)
+	reports := make(map[string]*repository.BudgetReport)
+	for _, rule := range *rules {
+		if rule.Type != repository.ALERT_TYPE_BUDGET {
+			for _, p := range purchases {
+				reference, n, ok := evaluateRule(rule, p, nil)
+				if ok {
+					deliver(rule, reference, n)
+				}
+			}
+			continue
+		}
+
+		for _, p := range samples {
+			month := fmt.Sprintf("%04d-%02d", p.Year, p.Month)
+			report, ok := reports[month]
+			if !ok {
+				report, err = repository.GetBudgetReport(p.Month, p.Year)
+				if err != nil {
+					log.Errorf("Failed to load budget report for alerts: %s", err)
+					return
+				}
+				reports[month] = report
+			}
+
+			reference, n, ok := evaluateRule(rule, p, report)
+			if ok {
+				deliver(rule, reference, n)
+			}
+		}
+	}
+}
+
+/*
+Returns the first purchase of every combination of month and category, in the order of the purchases. Budget rules
+only depend on the month and category of a purchase, so these are sufficient to evaluate them.
+*/
+func budgetSamples(purchases []repository.Purchase) []repository.Purchase {
+	res := make([]repository.Purchase, 0)
+	seen := make(map[string]bool)
+	for _, p := range purchases {
+		key := fmt.Sprintf("%04d-%02d/%s", p.Year, p.Month, p.Category)
+		if !seen[key] {
+			seen[key] = true
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+/*
+Checks whether the rule fires for the provided purchase. Returns the reference the alert is de-duplicated by and the
+notification to deliver.
+*/
+func evaluateRule(rule repository.AlertRule, p repository.Purchase, report *repository.BudgetReport) (string, Notification, bool) {
+	if rule.Category != "" && rule.Category != p.Category {
+		return "", Notification{}, false
+	}
+
+	switch rule.Type {
+	case repository.ALERT_TYPE_PURCHASE:
+		sum, err := strconv.ParseFloat(p.Sum, 64)
+		if err != nil || sum < rule.Threshold {
+			return "", Notification{}, false
+		}
+
+		return p.Key, Notification{
+			Subject: fmt.Sprintf("Purchase of %s exceeds %.2f", p.Sum, rule.Threshold),
+			Body: fmt.Sprintf(
+				"A purchase of %s has been recorded on %s, exceeding your alert threshold of %.2f.",
+				p.Sum, p.Date, rule.Threshold,
+			),
+			Data: map[string]interface{}{"rule": rule.Key, "purchase": p},
+		}, true
+
+	case repository.ALERT_TYPE_BUDGET:
+		if report == nil {
+			return "", Notification{}, false
+		}
+		for _, status := range report.Budgets {
+			if status.Category != rule.Category {
+				continue
+			}
+			if status.PercentUsed < rule.Threshold {
+				return "", Notification{}, false
+			}
+
+			return fmt.Sprintf("%04d-%02d", report.Year, report.Month), Notification{
+				Subject: fmt.Sprintf("Budget '%s' at %.0f%%", status.Name, status.PercentUsed),
+				Body: fmt.Sprintf(
+					"The budget '%s' for %02d/%04d has reached %.2f%%: %.2f of %s spent, %.2f remaining.",
+					status.Name, report.Month, report.Year, status.PercentUsed, status.Spent, status.Amount, status.Remaining,
+				),
+				Data: map[string]interface{}{"rule": rule.Key, "budget": status},
+			}, true
+		}
+	}
+
+	return "", Notification{}, false
+}
+
+func deliver(rule repository.AlertRule, reference string, n Notification) {
+	log := config.Logger()
+
+	first, err := repository.MarkAlertSent(rule.Key, reference)
+	if err != nil {
+		log.Errorf("Failed to record alert %s for %s: %s", rule.Key, reference, err)
+		return
+	}
+	if !first {
+		return
+	}
+
+	err = Send(rule.Channel, rule.Target, n)
+	if err != nil {
+		log.Errorf("Failed to deliver alert %s via %s: %s", rule.Key, rule.Channel, err)
+		err = repository.UnmarkAlertSent(rule.Key, reference)
+		if err != nil {
+			log.Warningf("Failed to reset alert %s for %s after failed delivery: %s", rule.Key, reference, err)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package notification
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mandrakey/shoptrac/config"
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+func TestWebhookChannel(t *testing.T) {
+	var received Notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(400)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	local := config.Webhook{AllowedHosts: []string{"127.0.0.1"}}
+	err := NewWebhookChannel(local).Send(server.URL, Notification{Subject: "Budget", Body: "Exceeded"})
+	if err != nil {
+		t.Fatalf("Send returns error: %s", err)
+	}
+	if received.Subject != "Budget" || received.Body != "Exceeded" {
+		t.Errorf("Webhook received %+v", received)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer failing.Close()
+
+	err = NewWebhookChannel(local).Send(failing.URL, Notification{})
+	if err == nil {
+		t.Error("Failing webhook returns no error")
+	}
+
+	err = NewWebhookChannel(config.Webhook{}).Send(server.URL, Notification{})
+	if err == nil {
+		t.Error("Webhook on loopback address without allowed host returns no error")
+	}
+}
+
+func TestWebhookValidateTarget(t *testing.T) {
+	ch := NewWebhookChannel(config.Webhook{AllowedHosts: []string{"homeassistant.lan", "192.168.1.10"}})
+
+	valid := []string{
+		"https://example.org/hook",
+		"http://example.org:8080/hook?token=abc",
+		"http://homeassistant.lan/api/webhook/shoptrac",
+		"http://192.168.1.10/hook",
+	}
+	for _, target := range valid {
+		if err := ch.ValidateTarget(target); err != nil {
+			t.Errorf("ValidateTarget returns error for '%s': %s", target, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"example.org/hook",
+		"/hook",
+		"ftp://example.org/hook",
+		"file:///etc/passwd",
+		"http://localhost:8529/_api",
+		"http://127.0.0.1/hook",
+		"http://[::1]/hook",
+		"http://10.0.0.1/hook",
+		"http://172.16.5.4/hook",
+		"http://192.168.1.11/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://0.0.0.0/hook",
+		"http://[fd00::1]/hook",
+	}
+	for _, target := range invalid {
+		if err := ch.ValidateTarget(target); err == nil {
+			t.Errorf("ValidateTarget returns no error for '%s'", target)
+		}
+	}
+}
+
+func TestSmtpValidateTarget(t *testing.T) {
+	ch := NewSmtpChannel(config.Smtp{})
+
+	if err := ch.ValidateTarget("user@example.org"); err != nil {
+		t.Errorf("ValidateTarget returns error for valid address: %s", err)
+	}
+
+	invalid := []string{
+		"",
+		"user",
+		"user@example.org, other@example.org",
+		"User <user@example.org>",
+		"user@example.org\r\nBcc: other@example.org",
+	}
+	for _, target := range invalid {
+		if err := ch.ValidateTarget(target); err == nil {
+			t.Errorf("ValidateTarget returns no error for '%s'", target)
+		}
+	}
+}
+
+func TestSmtpChannel(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer l.Close()
+
+	messages := make(chan string, 1)
+	go serveFakeSmtp(l, messages)
+
+	port, _ := strconv.Atoi(strings.Split(l.Addr().String(), ":")[1])
+	ch := NewSmtpChannel(config.Smtp{Host: "127.0.0.1", Port: port, From: "shoptrac@example.org"})
+
+	err = ch.Send("user@example.org", Notification{Subject: "Budget", Body: "Exceeded"})
+	if err != nil {
+		t.Fatalf("Send returns error: %s", err)
+	}
+
+	msg := <-messages
+	if !strings.Contains(msg, "To: user@example.org") || !strings.Contains(msg, "Exceeded") {
+		t.Errorf("SMTP server received unexpected message: %s", msg)
+	}
+}
+
+func TestSmtpChannelAttachment(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer l.Close()
+
+	messages := make(chan string, 1)
+	go serveFakeSmtp(l, messages)
+
+	port, _ := strconv.Atoi(strings.Split(l.Addr().String(), ":")[1])
+	ch := NewSmtpChannel(config.Smtp{Host: "127.0.0.1", Port: port, From: "shoptrac@example.org"})
+
+	err = ch.SendAttachment("user@example.org", "Report", "See attachment", "report.csv", "text/csv", []byte("a;b\n1;2\n"))
+	if err != nil {
+		t.Fatalf("SendAttachment returns error: %s", err)
+	}
+
+	msg := <-messages
+	for _, s := range []string{"Content-Type: multipart/mixed; boundary=", "See attachment", "attachment; filename=report.csv", "YTtiCjE7Mgo="} {
+		if !strings.Contains(msg, s) {
+			t.Errorf("SMTP server received message without '%s': %s", s, msg)
+		}
+	}
+}
+
+func TestEvaluateRule(t *testing.T) {
+	p := repository.Purchase{Key: "p1", Category: "1", Date: "2026-03-10", Month: 3, Year: 2026, Sum: "120.00"}
+
+	rule := repository.AlertRule{Key: "r1", Type: repository.ALERT_TYPE_PURCHASE, Threshold: 100}
+	reference, _, ok := evaluateRule(rule, p, nil)
+	if !ok || reference != "p1" {
+		t.Errorf("Purchase rule returns %v with reference '%s'", ok, reference)
+	}
+
+	rule.Category = "2"
+	_, _, ok = evaluateRule(rule, p, nil)
+	if ok {
+		t.Error("Purchase rule fires for other category")
+	}
+
+	report := &repository.BudgetReport{Month: 3, Year: 2026, Budgets: []repository.BudgetStatus{
+		{Budget: repository.Budget{Category: "1", Amount: "150.00"}, Spent: 120, PercentUsed: 80},
+	}}
+	rule = repository.AlertRule{Key: "r2", Type: repository.ALERT_TYPE_BUDGET, Category: "1", Threshold: 80}
+	reference, _, ok = evaluateRule(rule, p, report)
+	if !ok || reference != "2026-03" {
+		t.Errorf("Budget rule returns %v with reference '%s'", ok, reference)
+	}
+
+	rule.Threshold = 100
+	_, _, ok = evaluateRule(rule, p, report)
+	if ok {
+		t.Error("Budget rule fires below threshold")
+	}
+}
+
+/*
+Accepts a single SMTP session and passes the received message on.
+*/
+func serveFakeSmtp(l net.Listener, messages chan<- string) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	var data strings.Builder
+	inData := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		if inData {
+			if line == ".\r\n" {
+				inData = false
+				messages <- data.String()
+				reply("250 OK")
+			} else {
+				data.WriteString(line)
+			}
+			continue
+		}
+
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "DATA"):
+			inData = true
+			reply("354 Go ahead")
+		case strings.HasPrefix(cmd, "QUIT"):
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestBudgetSamples(t *testing.T) {
+	purchases := []repository.Purchase{
+		{Key: "1", Category: "food", Month: 3, Year: 2026},
+		{Key: "2", Category: "food", Month: 3, Year: 2026},
+		{Key: "3", Category: "rent", Month: 3, Year: 2026},
+		{Key: "4", Category: "food", Month: 4, Year: 2026},
+		{Key: "5", Category: "food", Month: 3, Year: 2025},
+	}
+
+	samples := budgetSamples(purchases)
+	keys := make([]string, 0)
+	for _, p := range samples {
+		keys = append(keys, p.Key)
+	}
+	if strings.Join(keys, ",") != "1,3,4,5" {
+		t.Errorf("budgetSamples returns purchases %v instead of expected [1 3 4 5]", keys)
+	}
+}
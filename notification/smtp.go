@@ -0,0 +1,128 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package notification
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"time"
+
+	"github.com/mandrakey/shoptrac/config"
+)
+
+/*
+SmtpChannel delivers notifications as plain text email.
+*/
+type SmtpChannel struct {
+	cfg config.Smtp
+}
+
+func NewSmtpChannel(cfg config.Smtp) *SmtpChannel {
+	return &SmtpChannel{cfg: cfg}
+}
+
+/*
+ValidateTarget accepts a single plain email address, without display name.
+*/
+func (c *SmtpChannel) ValidateTarget(target string) error {
+	addr, err := mail.ParseAddress(target)
+	if err != nil || addr.Address != target {
+		return fmt.Errorf("Invalid email address '%s'", target)
+	}
+	return nil
+}
+
+func (c *SmtpChannel) Send(target string, n Notification) error {
+	return c.SendMessage(target, n.Subject, "text/plain; charset=utf-8", []byte(n.Body))
+}
+
+/*
+SendMessage sends a single-part email with the provided content type to the target address.
+*/
+func (c *SmtpChannel) SendMessage(target string, subject string, contentType string, body []byte) error {
+	err := c.ValidateTarget(target)
+	if err != nil {
+		return err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", c.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", target)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: %s\r\n", contentType)
+	fmt.Fprintf(&msg, "\r\n")
+	msg.Write(body)
+
+	return c.deliver([]string{target}, msg.Bytes())
+}
+
+/*
+SendAttachment sends a plain text email with the provided data attached as a file.
+*/
+func (c *SmtpChannel) SendAttachment(target string, subject string, text string, filename string, contentType string, data []byte) error {
+	err := c.ValidateTarget(target)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	textHeader := textproto.MIMEHeader{}
+	textHeader.Set("Content-Type", "text/plain; charset=utf-8")
+	part, err := w.CreatePart(textHeader)
+	if err != nil {
+		return err
+	}
+	part.Write([]byte(text))
+
+	fileHeader := textproto.MIMEHeader{}
+	fileHeader.Set("Content-Type", contentType)
+	fileHeader.Set("Content-Transfer-Encoding", "base64")
+	fileHeader.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	part, err = w.CreatePart(fileHeader)
+	if err != nil {
+		return err
+	}
+
+	// Base64 lines must not exceed 76 characters
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		fmt.Fprintf(part, "%s\r\n", encoded[:76])
+		encoded = encoded[76:]
+	}
+	fmt.Fprintf(part, "%s\r\n", encoded)
+
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return c.SendMessage(target, subject, "multipart/mixed; boundary="+w.Boundary(), body.Bytes())
+}
+
+func (c *SmtpChannel) deliver(to []string, msg []byte) error {
+	var auth smtp.Auth
+	if c.cfg.User != "" {
+		auth = smtp.PlainAuth("", c.cfg.User, c.cfg.Password, c.cfg.Host)
+	}
+
+	addr := fmt.Sprintf("%s:%d", c.cfg.Host, c.cfg.Port)
+	err := smtp.SendMail(addr, auth, c.cfg.From, to, msg)
+	if err != nil {
+		return fmt.Errorf("Failed to send email via %s: %s", addr, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package notification
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mandrakey/shoptrac/config"
+)
+
+const (
+	WEBHOOK_TIMEOUT = 10 * time.Second
+)
+
+// Address ranges webhooks are not delivered to, besides loopback, link-local and unspecified addresses
+var blockedNetworks = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"fc00::/7",
+}
+
+/*
+WebhookChannel delivers notifications by posting them as JSON object to the target URL. Targets must be http or https
+URLs. Unless their host is allowed explicitly, they must not resolve to a loopback, private or link-local address, which
+is checked when connecting, so host names can not be used to reach such addresses either. Redirects are not followed.
+*/
+type WebhookChannel struct {
+	allowed    map[string]bool
+	client     *http.Client
+	restricted *http.Client
+}
+
+func NewWebhookChannel(cfg config.Webhook) *WebhookChannel {
+	allowed := make(map[string]bool)
+	for _, host := range cfg.AllowedHosts {
+		allowed[strings.ToLower(host)] = true
+	}
+
+	noRedirect := func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	dialer := &net.Dialer{Timeout: WEBHOOK_TIMEOUT, Control: checkWebhookAddress}
+
+	return &WebhookChannel{
+		allowed: allowed,
+		client:  &http.Client{Timeout: WEBHOOK_TIMEOUT, CheckRedirect: noRedirect},
+		restricted: &http.Client{
+			Timeout:       WEBHOOK_TIMEOUT,
+			CheckRedirect: noRedirect,
+			Transport:     &http.Transport{DialContext: dialer.DialContext},
+		},
+	}
+}
+
+func (c *WebhookChannel) ValidateTarget(target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("Invalid webhook URL '%s': %s", target, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("Invalid webhook URL '%s': must be an absolute http or https URL", target)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if c.allowed[host] {
+		return nil
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("Webhook host '%s' is not allowed", host)
+	}
+	if ip := net.ParseIP(host); ip != nil && isBlockedAddress(ip) {
+		return fmt.Errorf("Webhook address '%s' is not allowed", host)
+	}
+
+	return nil
+}
+
+func (c *WebhookChannel) Send(target string, n Notification) error {
+	err := c.ValidateTarget(target)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("Failed to encode notification: %s", err)
+	}
+
+	client := c.restricted
+	if u, _ := url.Parse(target); c.allowed[strings.ToLower(u.Hostname())] {
+		client = c.client
+	}
+
+	resp, err := client.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Failed to call webhook: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Webhook returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+/*
+Rejects connections to blocked addresses after the host name has been resolved.
+*/
+func checkWebhookAddress(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || isBlockedAddress(ip) {
+		return fmt.Errorf("Webhook address '%s' is not allowed", host)
+	}
+	return nil
+}
+
+func isBlockedAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return true
+	}
+
+	for _, cidr := range blockedNetworks {
+		_, network, _ := net.ParseCIDR(cidr)
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
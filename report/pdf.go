@@ -0,0 +1,189 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// A4 in points
+	PAGE_WIDTH  = 595.28
+	PAGE_HEIGHT = 841.89
+
+	FONT_REGULAR = "F1"
+	FONT_BOLD    = "F2"
+)
+
+// Widths of the printable ASCII characters (32 to 126) in Helvetica, in 1/1000 of the font size.
+var helveticaWidths = []int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+/*
+A Pdf is a minimal PDF writer supporting text in the standard Helvetica fonts and lines on A4 pages. Coordinates are
+given in points from the top left corner of the page.
+*/
+type Pdf struct {
+	pages   []*bytes.Buffer
+	current *bytes.Buffer
+}
+
+func NewPdf() *Pdf {
+	return &Pdf{pages: make([]*bytes.Buffer, 0)}
+}
+
+func (p *Pdf) AddPage() {
+	p.current = &bytes.Buffer{}
+	p.pages = append(p.pages, p.current)
+}
+
+func (p *Pdf) PageCount() int {
+	return len(p.pages)
+}
+
+/*
+Text writes s with its baseline starting at x, y.
+*/
+func (p *Pdf) Text(x float64, y float64, font string, size float64, s string) {
+	if p.current == nil {
+		p.AddPage()
+	}
+	fmt.Fprintf(p.current, "BT /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, PAGE_HEIGHT-y, escapePdfString(s))
+}
+
+/*
+TextRight writes s so that it ends at x.
+*/
+func (p *Pdf) TextRight(x float64, y float64, font string, size float64, s string) {
+	p.Text(x-TextWidth(font, size, s), y, font, size, s)
+}
+
+func (p *Pdf) Line(x1 float64, y1 float64, x2 float64, y2 float64, width float64) {
+	if p.current == nil {
+		p.AddPage()
+	}
+	fmt.Fprintf(p.current, "%.2f w %.2f %.2f m %.2f %.2f l S\n", width, x1, PAGE_HEIGHT-y1, x2, PAGE_HEIGHT-y2)
+}
+
+/*
+Rect draws a rectangle filled with the given gray level (0 black, 1 white).
+*/
+func (p *Pdf) Rect(x float64, y float64, w float64, h float64, gray float64) {
+	if p.current == nil {
+		p.AddPage()
+	}
+	fmt.Fprintf(p.current, "q %.2f g %.2f %.2f %.2f %.2f re f Q\n", gray, x, PAGE_HEIGHT-y-h, w, h)
+}
+
+/*
+TextWidth returns the width of s in points. Bold text is slightly wider than regular text, which is approximated here.
+*/
+func TextWidth(font string, size float64, s string) float64 {
+	units := 0
+	for _, r := range s {
+		if r >= 32 && r <= 126 {
+			units += helveticaWidths[r-32]
+		} else {
+			units += 556
+		}
+	}
+	w := float64(units) * size / 1000
+	if font == FONT_BOLD {
+		w *= 1.05
+	}
+	return w
+}
+
+/*
+WriteTo writes the complete document.
+*/
+func (p *Pdf) WriteTo(w io.Writer) (int64, error) {
+	if len(p.pages) == 0 {
+		p.AddPage()
+	}
+
+	var buf bytes.Buffer
+	offsets := make([]int, 0)
+	object := func(content string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), content)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1 to 4 are catalog, page tree and fonts, followed by page and content per page
+	kids := make([]string, len(p.pages))
+	for i := range p.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+i*2)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, content := range p.pages {
+		object(fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /%s 3 0 R /%s 4 0 R >> >> /Contents %d 0 R >>",
+			PAGE_WIDTH, PAGE_HEIGHT, FONT_REGULAR, FONT_BOLD, 6+i*2,
+		))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, o := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", o)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+func (p *Pdf) Bytes() []byte {
+	var buf bytes.Buffer
+	p.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+/*
+Converts s to WinAnsiEncoding and escapes it for a PDF string literal. Characters which can not be encoded are replaced
+by a question mark.
+*/
+func escapePdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		var c byte
+		switch {
+		case r == '€':
+			c = 0x80
+		case r < 0x80 || (r >= 0xa0 && r <= 0xff):
+			c = byte(r)
+		default:
+			c = '?'
+		}
+
+		switch c {
+		case '\\', '(', ')':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n', '\r', '\t':
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
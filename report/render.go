@@ -0,0 +1,146 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"strconv"
+
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{"amount": formatAmount}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { padding: 2px 12px 2px 0; text-align: left; }
+td.num, th.num { text-align: right; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+
+<h2>Totals</h2>
+<table>
+<tr><th></th><th class="num">Purchases</th><th class="num">Sum</th></tr>
+<tr><td>This period</td><td class="num">{{.Current.Count}}</td><td class="num">{{amount .Current.Sum}}</td></tr>
+<tr><td>{{.PreviousLabel}}</td><td class="num">{{.Previous.Count}}</td><td class="num">{{amount .Previous.Sum}}</td></tr>
+<tr><td>Change</td><td class="num">{{.CountChange}}</td><td class="num">{{.Change}}</td></tr>
+</table>
+
+{{range .Breakdowns}}
+<h2>{{.Title}}</h2>
+<table>
+<tr><th>Name</th><th class="num">Purchases</th><th class="num">Sum</th></tr>
+{{range .Entries}}<tr><td>{{.Name}}</td><td class="num">{{.Count}}</td><td class="num">{{amount .Sum}}</td></tr>
+{{end}}</table>
+{{end}}
+
+<h2>Purchases</h2>
+<table>
+<tr><th>Date</th><th>Category</th><th>Venue</th><th>Shopper</th><th class="num">Sum</th></tr>
+{{range .Rows}}<tr><td>{{index . 0}}</td><td>{{index . 1}}</td><td>{{index . 2}}</td><td>{{index . 3}}</td><td class="num">{{index . 4}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+/*
+Render renders the report in the given format and returns the content together with its content type and file
+extension.
+*/
+func Render(format string, data *SummaryData) ([]byte, string, string, error) {
+	switch format {
+	case repository.REPORT_FORMAT_PDF:
+		return RenderPdf(data), "application/pdf", "pdf", nil
+	case repository.REPORT_FORMAT_HTML:
+		content, err := RenderHtml(data)
+		return content, "text/html; charset=utf-8", "html", err
+	case repository.REPORT_FORMAT_CSV:
+		content, err := RenderCsv(data)
+		return content, "text/csv; charset=utf-8", "csv", err
+	default:
+		return nil, "", "", fmt.Errorf("Unknown report format '%s'", format)
+	}
+}
+
+func RenderHtml(data *SummaryData) ([]byte, error) {
+	type breakdownSection struct {
+		Title   string
+		Entries []SummaryEntry
+	}
+
+	view := struct {
+		*SummaryData
+		CountChange string
+		Change      string
+		Breakdowns  []breakdownSection
+		Rows        [][]string
+	}{
+		SummaryData: data,
+		CountChange: formatCountChange(data.Current.Count - data.Previous.Count),
+		Change:      formatChange(data.Current.Sum, data.Previous.Sum),
+		Breakdowns: []breakdownSection{
+			{"By category", breakdown(data.Purchases, func(p repository.Purchase) string { return data.Categories[p.Category] })},
+			{"By venue", breakdown(data.Purchases, func(p repository.Purchase) string { return data.Venues[p.Venue] })},
+		},
+		Rows: purchaseRows(data),
+	}
+
+	var buf bytes.Buffer
+	err := htmlTemplate.Execute(&buf, view)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+/*
+RenderCsv lists the purchases of the report, one per line, with names instead of keys.
+*/
+func RenderCsv(data *SummaryData) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	w.Write([]string{"date", "category", "venue", "shopper", "sum"})
+	for _, p := range sortedPurchases(data.Purchases) {
+		w.Write([]string{
+			p.Date,
+			nameOrKey(data.Categories, p.Category),
+			nameOrKey(data.Venues, p.Venue),
+			nameOrKey(data.Shoppers, p.Shopper),
+			p.Sum,
+		})
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func purchaseRows(data *SummaryData) [][]string {
+	rows := make([][]string, 0, len(data.Purchases))
+	for _, p := range sortedPurchases(data.Purchases) {
+		sum, _ := strconv.ParseFloat(p.Sum, 64)
+		rows = append(rows, []string{
+			p.Date,
+			nameOrKey(data.Categories, p.Category),
+			nameOrKey(data.Venues, p.Venue),
+			nameOrKey(data.Shoppers, p.Shopper),
+			formatAmount(sum),
+		})
+	}
+	return rows
+}
@@ -0,0 +1,285 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package report
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/mandrakey/shoptrac/config"
+	"github.com/mandrakey/shoptrac/notification"
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+const (
+	REPORT_MAX_ATTEMPTS = 5
+	REPORT_RETRY_DELAY  = 30 * time.Minute
+
+	// Reports of older missed periods are not delivered anymore
+	REPORT_MAX_CATCHUP = 12
+)
+
+var (
+	rxSafeName = regexp.MustCompile("^[A-Za-z0-9_-][A-Za-z0-9._-]*$")
+)
+
+/*
+Sends reports by email. Implemented by the SMTP notification channel.
+*/
+type mailer interface {
+	SendMessage(target string, subject string, contentType string, body []byte) error
+	SendAttachment(target string, subject string, text string, filename string, contentType string, data []byte) error
+}
+
+/*
+DeliverScheduled delivers, for every subscription, the reports of all weeks or months completed since the last recorded
+delivery. Failed deliveries are retried with an increasing delay on later runs. Meant to run periodically.
+*/
+func DeliverScheduled() error {
+	log := config.Logger()
+	now := time.Now().In(repository.Location())
+
+	users, err := repository.GetUsersWithReports()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, user := range users {
+		for _, sub := range user.Reports {
+			err := deliverSubscription(user, sub, now)
+			if err != nil {
+				log.Errorf("Failed to deliver report %s of user %s: %s", sub.Key, user.Username, err)
+				failed++
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d scheduled reports could not be delivered", failed)
+	}
+	return nil
+}
+
+/*
+Delivers the reports of all periods missed since the last recorded delivery of the subscription, oldest first, so
+reports are caught up after serve has been down or deliveries failed into the next period.
+*/
+func deliverSubscription(user repository.User, sub repository.ReportSubscription, now time.Time) error {
+	pending := make([]repository.DateRange, 0)
+	deliveries := make(map[string]*repository.ReportDelivery)
+	for _, r := range dueRanges(sub, now) {
+		d, err := repository.GetReportDelivery(user.Key, sub.Key, r.Period)
+		if err != nil {
+			return err
+		}
+		pending = append(pending, r)
+		deliveries[r.Period] = d
+		if d != nil {
+			break
+		}
+	}
+
+	var deliveryErr error
+	for i := len(pending) - 1; i >= 0; i-- {
+		r := pending[i]
+		d := deliveries[r.Period]
+		if !isDeliveryDue(d, now) {
+			continue
+		}
+
+		err := deliverPeriod(user, sub, r, d, now)
+		if err != nil {
+			deliveryErr = err
+		}
+	}
+
+	return deliveryErr
+}
+
+func deliverPeriod(user repository.User, sub repository.ReportSubscription, r repository.DateRange, d *repository.ReportDelivery, now time.Time) error {
+	if d == nil {
+		d = &repository.ReportDelivery{UserKey: user.Key, Subscription: sub.Key, Period: r.Period}
+	}
+
+	deliveryErr := sendReport(user, sub, r)
+
+	d.Attempts++
+	d.LastAttempt = repository.DateTimeToDb(now.UTC())
+	d.NextAttempt = ""
+	if deliveryErr == nil {
+		d.Status = repository.REPORT_STATUS_DELIVERED
+		d.Error = ""
+	} else {
+		d.Status = repository.REPORT_STATUS_FAILED
+		d.Error = deliveryErr.Error()
+		if d.Attempts < REPORT_MAX_ATTEMPTS {
+			d.NextAttempt = repository.DateTimeToDb(now.UTC().Add(retryDelay(d.Attempts)))
+		}
+	}
+
+	err := repository.SaveReportDelivery(*d)
+	if err != nil {
+		return err
+	}
+	return deliveryErr
+}
+
+/*
+Returns the completed periods covered by the subscription, newest first, going back at most REPORT_MAX_CATCHUP periods.
+*/
+func dueRanges(sub repository.ReportSubscription, now time.Time) []repository.DateRange {
+	ranges := make([]repository.DateRange, 0)
+	for r := DueRange(sub.Schedule, now); len(ranges) < REPORT_MAX_CATCHUP && coversSubscription(sub, r); r = DueRange(sub.Schedule, r.From) {
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+/*
+DueRange returns the last completed period of the schedule: the week from monday to sunday before the current one, or
+the previous calendar month.
+*/
+func DueRange(schedule string, now time.Time) repository.DateRange {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	if schedule == repository.REPORT_SCHEDULE_WEEKLY {
+		monday := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+		from := monday.AddDate(0, 0, -7)
+		year, week := from.ISOWeek()
+		return repository.DateRange{Period: fmt.Sprintf("%04d-W%02d", year, week), From: from, To: monday.AddDate(0, 0, -1)}
+	}
+
+	first := today.AddDate(0, 0, 1-today.Day())
+	from := first.AddDate(0, -1, 0)
+	return repository.DateRange{Period: fmt.Sprintf("%04d-%02d", from.Year(), from.Month()), From: from, To: first.AddDate(0, 0, -1)}
+}
+
+/*
+Subscriptions only cover periods ending after they have been created, so subscribing does not deliver a report right
+away.
+*/
+func coversSubscription(sub repository.ReportSubscription, r repository.DateRange) bool {
+	created, err := repository.DateTimeFromDb(sub.Created)
+	if err != nil {
+		return true
+	}
+	return r.To.AddDate(0, 0, 1).After(created)
+}
+
+func isDeliveryDue(d *repository.ReportDelivery, now time.Time) bool {
+	if d == nil {
+		return true
+	}
+	if d.Status == repository.REPORT_STATUS_DELIVERED || d.Attempts >= REPORT_MAX_ATTEMPTS {
+		return false
+	}
+
+	next, err := repository.DateTimeFromDb(d.NextAttempt)
+	if err != nil {
+		return true
+	}
+	return !now.UTC().Before(next)
+}
+
+/*
+Doubles the delay with every failed attempt.
+*/
+func retryDelay(attempts int) time.Duration {
+	return REPORT_RETRY_DELAY << uint(attempts-1)
+}
+
+func sendReport(user repository.User, sub repository.ReportSubscription, r repository.DateRange) error {
+	var data *SummaryData
+	var err error
+	if sub.Schedule == repository.REPORT_SCHEDULE_MONTHLY {
+		data, err = GetMonthlyData(int(r.From.Month()), r.From.Year())
+	} else {
+		data, err = GetRangeData(fmt.Sprintf("Spending report week %s", r.Period), r)
+	}
+	if err != nil {
+		return err
+	}
+
+	content, contentType, extension, err := Render(sub.Format, data)
+	if err != nil {
+		return err
+	}
+	filename := fmt.Sprintf("shoptrac-%s.%s", r.Period, extension)
+
+	switch sub.Delivery {
+	case repository.REPORT_DELIVERY_EMAIL:
+		target := sub.Target
+		if target == "" {
+			target = user.Email
+		}
+		return sendReportEmail(target, data.Title, filename, sub.Format, contentType, content)
+
+	case repository.REPORT_DELIVERY_DIRECTORY:
+		return writeReportFile(config.GetAppConfig().ReportDir, reportDirName(user), filename, content)
+
+	default:
+		return fmt.Errorf("Invalid report delivery '%s'", sub.Delivery)
+	}
+}
+
+/*
+HTML reports are sent as the email itself, all other formats as attachment.
+*/
+func sendReportEmail(target string, subject string, filename string, format string, contentType string, content []byte) error {
+	ch, err := notification.GetChannel(notification.CHANNEL_EMAIL)
+	if err != nil {
+		return err
+	}
+	m, ok := ch.(mailer)
+	if !ok {
+		return fmt.Errorf("Email channel does not support reports")
+	}
+
+	if format == repository.REPORT_FORMAT_HTML {
+		return m.SendMessage(target, subject, contentType, content)
+	}
+	return m.SendAttachment(target, subject, fmt.Sprintf("Your report is attached as %s.", filename), filename, contentType, content)
+}
+
+/*
+Writes the report to a sub directory of the report directory. The file is written under a temporary name first, so other
+programs watching the directory never see incomplete reports.
+*/
+func writeReportFile(baseDir string, dirName string, filename string, content []byte) error {
+	dir := filepath.Join(baseDir, dirName)
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return fmt.Errorf("Failed to create report directory: %s", err)
+	}
+
+	tmp := filepath.Join(dir, "."+filename+".tmp")
+	err = ioutil.WriteFile(tmp, content, 0640)
+	if err != nil {
+		return fmt.Errorf("Failed to write report: %s", err)
+	}
+
+	err = os.Rename(tmp, filepath.Join(dir, filename))
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("Failed to write report: %s", err)
+	}
+	return nil
+}
+
+/*
+Returns the name of the user's report directory, which is the username unless it can not safely be used as one.
+*/
+func reportDirName(user repository.User) string {
+	if rxSafeName.MatchString(user.Username) {
+		return user.Username
+	}
+	return user.Key
+}
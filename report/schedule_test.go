@@ -0,0 +1,145 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package report
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+func TestDueRange(t *testing.T) {
+	// A wednesday
+	now := time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC)
+
+	r := DueRange(repository.REPORT_SCHEDULE_WEEKLY, now)
+	if r.Period != "2026-W41" || r.FromDb() != "2026-10-05" || r.ToDb() != "2026-10-11" {
+		t.Errorf("DueRange returns %s (%s to %s) instead of expected 2026-W41", r.Period, r.FromDb(), r.ToDb())
+	}
+
+	// On mondays the week that just ended is due
+	r = DueRange(repository.REPORT_SCHEDULE_WEEKLY, time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC))
+	if r.Period != "2026-W41" {
+		t.Errorf("DueRange returns %s instead of expected 2026-W41 on monday", r.Period)
+	}
+
+	r = DueRange(repository.REPORT_SCHEDULE_MONTHLY, now)
+	if r.Period != "2026-09" || r.FromDb() != "2026-09-01" || r.ToDb() != "2026-09-30" {
+		t.Errorf("DueRange returns %s (%s to %s) instead of expected 2026-09", r.Period, r.FromDb(), r.ToDb())
+	}
+
+	r = DueRange(repository.REPORT_SCHEDULE_MONTHLY, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	if r.Period != "2025-12" || r.ToDb() != "2025-12-31" {
+		t.Errorf("DueRange returns %s instead of expected 2025-12 in january", r.Period)
+	}
+}
+
+func TestCoversSubscription(t *testing.T) {
+	r := DueRange(repository.REPORT_SCHEDULE_MONTHLY, time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC))
+
+	if !coversSubscription(repository.ReportSubscription{Created: "2026-09-30 23:00:00"}, r) {
+		t.Error("coversSubscription returns false for subscription created before end of period")
+	}
+	if coversSubscription(repository.ReportSubscription{Created: "2026-10-02 08:00:00"}, r) {
+		t.Error("coversSubscription returns true for subscription created after end of period")
+	}
+}
+
+func TestDueRanges(t *testing.T) {
+	now := time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC)
+
+	sub := repository.ReportSubscription{Schedule: repository.REPORT_SCHEDULE_WEEKLY, Created: "2026-09-23 10:00:00"}
+	ranges := dueRanges(sub, now)
+	if len(ranges) != 3 || ranges[0].Period != "2026-W41" || ranges[1].Period != "2026-W40" || ranges[2].Period != "2026-W39" {
+		t.Errorf("dueRanges returns %v instead of expected 2026-W41 to 2026-W39", ranges)
+	}
+
+	sub = repository.ReportSubscription{Schedule: repository.REPORT_SCHEDULE_MONTHLY, Created: "2025-12-15 10:00:00"}
+	ranges = dueRanges(sub, now)
+	if len(ranges) != 10 || ranges[0].Period != "2026-09" || ranges[9].Period != "2025-12" {
+		t.Errorf("dueRanges returns %v instead of expected 2026-09 to 2025-12", ranges)
+	}
+
+	sub = repository.ReportSubscription{Schedule: repository.REPORT_SCHEDULE_WEEKLY, Created: "2020-01-01 00:00:00"}
+	if ranges = dueRanges(sub, now); len(ranges) != REPORT_MAX_CATCHUP {
+		t.Errorf("dueRanges returns %d periods instead of expected %d", len(ranges), REPORT_MAX_CATCHUP)
+	}
+
+	sub = repository.ReportSubscription{Schedule: repository.REPORT_SCHEDULE_WEEKLY, Created: "2026-10-13 10:00:00"}
+	if ranges = dueRanges(sub, now); len(ranges) != 0 {
+		t.Errorf("dueRanges returns %v instead of expected no periods for new subscription", ranges)
+	}
+}
+
+func TestIsDeliveryDue(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+
+	if !isDeliveryDue(nil, now) {
+		t.Error("isDeliveryDue returns false without previous delivery")
+	}
+	if isDeliveryDue(&repository.ReportDelivery{Status: repository.REPORT_STATUS_DELIVERED, Attempts: 1}, now) {
+		t.Error("isDeliveryDue returns true for delivered report")
+	}
+
+	failed := repository.ReportDelivery{Status: repository.REPORT_STATUS_FAILED, Attempts: 2, NextAttempt: "2026-10-14 13:00:00"}
+	if isDeliveryDue(&failed, now) {
+		t.Error("isDeliveryDue returns true before next attempt")
+	}
+	if !isDeliveryDue(&failed, now.Add(time.Hour)) {
+		t.Error("isDeliveryDue returns false at next attempt")
+	}
+
+	failed.Attempts = REPORT_MAX_ATTEMPTS
+	if isDeliveryDue(&failed, now.Add(time.Hour)) {
+		t.Error("isDeliveryDue returns true after maximum attempts")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	if d := retryDelay(1); d != 30*time.Minute {
+		t.Errorf("retryDelay returns %s instead of expected 30m after first attempt", d)
+	}
+	if d := retryDelay(3); d != 2*time.Hour {
+		t.Errorf("retryDelay returns %s instead of expected 2h after third attempt", d)
+	}
+}
+
+func TestWriteReportFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shoptrac-reports")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = writeReportFile(dir, "alex", "shoptrac-2026-09.csv", []byte("date\n"))
+	if err != nil {
+		t.Fatalf("writeReportFile returns error: %s", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "alex", "shoptrac-2026-09.csv"))
+	if err != nil || string(content) != "date\n" {
+		t.Errorf("writeReportFile writes '%s' instead of expected content", content)
+	}
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "alex"))
+	if len(files) != 1 {
+		t.Errorf("writeReportFile leaves %d files instead of expected 1", len(files))
+	}
+}
+
+func TestReportDirName(t *testing.T) {
+	if n := reportDirName(repository.User{Key: "k", Username: "alex.b"}); n != "alex.b" {
+		t.Errorf("reportDirName returns '%s' instead of expected username", n)
+	}
+	for _, username := range []string{"..", "../alex", "a/b", ""} {
+		if n := reportDirName(repository.User{Key: "k", Username: username}); n != "k" {
+			t.Errorf("reportDirName returns '%s' instead of expected key for username '%s'", n, username)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package report
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+const (
+	MARGIN      = 50.0
+	LINE_HEIGHT = 14.0
+	FONT_SIZE   = 10.0
+)
+
+type SummaryEntry struct {
+	Name  string
+	Count int
+	Sum   float64
+}
+
+/*
+SummaryData holds everything shown in a report. Purchases refer to categories, venues and shoppers by key, the maps
+resolve them to names.
+*/
+type SummaryData struct {
+	Title         string
+	Period        string
+	PreviousLabel string
+	Current       repository.CountSumHolder
+	Previous      repository.CountSumHolder
+	Purchases     []repository.Purchase
+	Categories    map[string]string
+	Venues        map[string]string
+	Shoppers      map[string]string
+}
+
+/*
+GetMonthlyData loads the overview statistics and purchases of a month together with the names they refer to.
+*/
+func GetMonthlyData(month int, year int) (*SummaryData, error) {
+	overview, err := repository.GetOverviewStatistics(month, year)
+	if err != nil {
+		return nil, err
+	}
+	purchases, err := repository.GetPurchases(month, year)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := time.Date(year, time.Month(month)-1, 1, 0, 0, 0, 0, time.UTC)
+	data := SummaryData{
+		Title:         fmt.Sprintf("Spending report %s %d", time.Month(month).String(), year),
+		Period:        fmt.Sprintf("%04d-%02d", year, month),
+		PreviousLabel: fmt.Sprintf("%s %d", previous.Month().String(), previous.Year()),
+		Purchases:     *purchases,
+	}
+	return &data, completeSummaryData(&data, overview)
+}
+
+/*
+GetRangeData loads the same data as GetMonthlyData for an arbitrary range, compared with the range right before it.
+*/
+func GetRangeData(title string, r repository.DateRange) (*SummaryData, error) {
+	previous, err := repository.PreviousPeriod(r)
+	if err != nil {
+		return nil, err
+	}
+	overview, err := repository.GetOverviewStatisticsForRange(r, *previous)
+	if err != nil {
+		return nil, err
+	}
+	purchases, err := repository.GetPurchasesInRange(r)
+	if err != nil {
+		return nil, err
+	}
+
+	data := SummaryData{
+		Title:         title,
+		Period:        r.Period,
+		PreviousLabel: fmt.Sprintf("%s to %s", previous.FromDb(), previous.ToDb()),
+		Purchases:     *purchases,
+	}
+	return &data, completeSummaryData(&data, overview)
+}
+
+/*
+Takes the totals from the overview statistics and loads the names of categories, venues and shoppers.
+*/
+func completeSummaryData(data *SummaryData, overview map[string]*repository.CountSumHolder) error {
+	if overview["currentMonth"] != nil {
+		data.Current = *overview["currentMonth"]
+	}
+	if overview["lastMonth"] != nil {
+		data.Previous = *overview["lastMonth"]
+	}
+
+	categories, err := repository.GetCategories()
+	if err != nil {
+		return err
+	}
+	venues, err := repository.GetVenues()
+	if err != nil {
+		return err
+	}
+	shoppers, err := repository.GetShoppers()
+	if err != nil {
+		return err
+	}
+
+	data.Categories = make(map[string]string)
+	data.Venues = make(map[string]string)
+	data.Shoppers = make(map[string]string)
+	for _, c := range *categories {
+		data.Categories[c.Key] = c.Name
+	}
+	for _, v := range *venues {
+		data.Venues[v.Key] = v.Name
+	}
+	for _, s := range *shoppers {
+		data.Shoppers[s.Key] = s.Name
+	}
+
+	return nil
+}
+
+/*
+RenderPdf renders a report: totals compared with the previous period, the breakdown by category and venue and the list
+of all purchases of the period.
+*/
+func RenderPdf(data *SummaryData) []byte {
+	l := newLayout()
+
+	l.heading(data.Title, 18)
+	l.skip(0.5)
+
+	// ----
+	// Totals and comparison
+
+	l.heading("Totals", 13)
+	l.row([]string{"", "Purchases", "Sum"}, FONT_BOLD)
+	l.row([]string{"This period", strconv.Itoa(data.Current.Count), formatAmount(data.Current.Sum)}, FONT_REGULAR)
+	l.row([]string{data.PreviousLabel, strconv.Itoa(data.Previous.Count), formatAmount(data.Previous.Sum)}, FONT_REGULAR)
+	l.row([]string{"Change", formatCountChange(data.Current.Count - data.Previous.Count), formatChange(data.Current.Sum, data.Previous.Sum)}, FONT_REGULAR)
+	l.skip(1)
+
+	// ----
+	// Breakdowns
+
+	l.heading("By category", 13)
+	l.breakdown(breakdown(data.Purchases, func(p repository.Purchase) string { return data.Categories[p.Category] }), data.Current.Sum)
+	l.skip(1)
+
+	l.heading("By venue", 13)
+	l.breakdown(breakdown(data.Purchases, func(p repository.Purchase) string { return data.Venues[p.Venue] }), data.Current.Sum)
+	l.skip(1)
+
+	// ----
+	// Purchases
+
+	l.heading("Purchases", 13)
+	purchases := sortedPurchases(data.Purchases)
+
+	columns := []float64{MARGIN, MARGIN + 70, MARGIN + 200, MARGIN + 330}
+	header := []string{"Date", "Category", "Venue", "Shopper", "Sum"}
+	l.table(columns, header, FONT_BOLD)
+	for _, p := range purchases {
+		sum, _ := strconv.ParseFloat(p.Sum, 64)
+		l.table(columns, []string{
+			p.Date,
+			nameOrKey(data.Categories, p.Category),
+			nameOrKey(data.Venues, p.Venue),
+			nameOrKey(data.Shoppers, p.Shopper),
+			formatAmount(sum),
+		}, FONT_REGULAR)
+	}
+	if len(purchases) == 0 {
+		l.text("No purchases in this period.", FONT_REGULAR)
+	}
+
+	return l.pdf.Bytes()
+}
+
+/*
+Sums up purchases by the name returned from key, largest sum first.
+*/
+func breakdown(purchases []repository.Purchase, key func(repository.Purchase) string) []SummaryEntry {
+	entries := make(map[string]*SummaryEntry)
+	for _, p := range purchases {
+		name := key(p)
+		if name == "" {
+			name = "Unknown"
+		}
+		sum, _ := strconv.ParseFloat(p.Sum, 64)
+		e, ok := entries[name]
+		if !ok {
+			e = &SummaryEntry{Name: name}
+			entries[name] = e
+		}
+		e.Count++
+		e.Sum += sum
+	}
+
+	res := make([]SummaryEntry, 0, len(entries))
+	for _, e := range entries {
+		e.Sum = math.Round(e.Sum*100) / 100
+		res = append(res, *e)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Sum == res[j].Sum {
+			return res[i].Name < res[j].Name
+		}
+		return res[i].Sum > res[j].Sum
+	})
+	return res
+}
+
+func sortedPurchases(purchases []repository.Purchase) []repository.Purchase {
+	res := make([]repository.Purchase, len(purchases))
+	copy(res, purchases)
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Date < res[j].Date })
+	return res
+}
+
+func nameOrKey(names map[string]string, key string) string {
+	if name, ok := names[key]; ok {
+		return name
+	}
+	return key
+}
+
+func formatAmount(sum float64) string {
+	return fmt.Sprintf("%.2f €", sum)
+}
+
+func formatCountChange(diff int) string {
+	if diff > 0 {
+		return fmt.Sprintf("+%d", diff)
+	}
+	return strconv.Itoa(diff)
+}
+
+/*
+Formats the difference between current and previous, followed by the relative change if there is a previous sum.
+*/
+func formatChange(current float64, previous float64) string {
+	diff := math.Round((current-previous)*100) / 100
+	sign := ""
+	if diff > 0 {
+		sign = "+"
+	}
+	res := fmt.Sprintf("%s%.2f €", sign, diff)
+	if previous > 0 {
+		res += fmt.Sprintf(" (%s%.1f %%)", sign, diff/previous*100)
+	}
+	return res
+}
+
+// ----
+// Layout
+
+/*
+A layout writes lines top to bottom and starts a new page when the current one is full.
+*/
+type layout struct {
+	pdf *Pdf
+	y   float64
+}
+
+func newLayout() *layout {
+	l := layout{pdf: NewPdf()}
+	l.newPage()
+	return &l
+}
+
+func (l *layout) newPage() {
+	l.pdf.AddPage()
+	l.y = MARGIN
+}
+
+func (l *layout) advance(height float64) {
+	if l.y+height > PAGE_HEIGHT-MARGIN {
+		l.newPage()
+	}
+	l.y += height
+}
+
+func (l *layout) skip(lines float64) {
+	l.y += lines * LINE_HEIGHT
+}
+
+func (l *layout) heading(s string, size float64) {
+	l.advance(size * 1.4)
+	l.pdf.Text(MARGIN, l.y, FONT_BOLD, size, s)
+	l.y += 4
+}
+
+func (l *layout) text(s string, font string) {
+	l.advance(LINE_HEIGHT)
+	l.pdf.Text(MARGIN, l.y, font, FONT_SIZE, s)
+}
+
+/*
+Writes a label followed by right aligned columns, as used for the totals.
+*/
+func (l *layout) row(values []string, font string) {
+	l.advance(LINE_HEIGHT)
+	l.pdf.Text(MARGIN, l.y, font, FONT_SIZE, values[0])
+	right := []float64{300, 450}
+	for i, v := range values[1:] {
+		l.pdf.TextRight(right[i], l.y, font, FONT_SIZE, v)
+	}
+}
+
+func (l *layout) breakdown(entries []SummaryEntry, total float64) {
+	l.row4([]string{"Name", "Purchases", "Sum", "Share"}, FONT_BOLD)
+	for _, e := range entries {
+		share := ""
+		if total > 0 {
+			share = fmt.Sprintf("%.1f %%", e.Sum/total*100)
+		}
+		l.row4([]string{e.Name, strconv.Itoa(e.Count), formatAmount(e.Sum), share}, FONT_REGULAR)
+	}
+}
+
+func (l *layout) row4(values []string, font string) {
+	l.advance(LINE_HEIGHT)
+	l.pdf.Text(MARGIN, l.y, font, FONT_SIZE, truncate(values[0], font, 240))
+	right := []float64{350, 450, PAGE_WIDTH - MARGIN}
+	for i, v := range values[1:] {
+		l.pdf.TextRight(right[i], l.y, font, FONT_SIZE, v)
+	}
+}
+
+/*
+Writes left aligned columns starting at the given positions, with the last value aligned to the right margin.
+*/
+func (l *layout) table(columns []float64, values []string, font string) {
+	l.advance(LINE_HEIGHT)
+	for i, x := range columns {
+		width := PAGE_WIDTH - MARGIN - 80 - x
+		if i+1 < len(columns) {
+			width = columns[i+1] - x - 6
+		}
+		l.pdf.Text(x, l.y, font, FONT_SIZE, truncate(values[i], font, width))
+	}
+	l.pdf.TextRight(PAGE_WIDTH-MARGIN, l.y, font, FONT_SIZE, values[len(values)-1])
+}
+
+/*
+Shortens s so it fits into width, marking the cut with dots.
+*/
+func truncate(s string, font string, width float64) string {
+	if TextWidth(font, FONT_SIZE, s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && TextWidth(font, FONT_SIZE, string(runes)+"...") > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
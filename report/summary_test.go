@@ -0,0 +1,152 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/mandrakey/shoptrac/repository"
+)
+
+func TestEscapePdfString(t *testing.T) {
+	if s := escapePdfString("a (b) \\ 5 €"); s != "a \\(b\\) \\\\ 5 \x80" {
+		t.Errorf("escapePdfString returns '%s' instead of expected escaped string", s)
+	}
+	if s := escapePdfString("Müller ☃"); s != "M\xfcller ?" {
+		t.Errorf("escapePdfString returns '%s' instead of expected WinAnsi string", s)
+	}
+}
+
+func TestPdfStructure(t *testing.T) {
+	p := NewPdf()
+	p.AddPage()
+	p.Text(10, 10, FONT_REGULAR, 12, "Hello")
+	p.AddPage()
+	p.Line(0, 0, 100, 100, 1)
+	out := p.Bytes()
+
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatalf("Pdf lacks header or trailer")
+	}
+
+	// The xref table must point to the start of each object
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(out)
+	if m == nil {
+		t.Fatalf("Pdf lacks startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(out[xref:], []byte("xref\n0 9\n")) {
+		t.Fatalf("startxref does not point to an xref table with 9 entries")
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(out[xref:], -1)
+	if len(entries) != 8 {
+		t.Fatalf("Xref table has %d entries instead of expected 8", len(entries))
+	}
+	for i, e := range entries {
+		offset, _ := strconv.Atoi(string(e[1]))
+		if !bytes.HasPrefix(out[offset:], []byte(fmt.Sprintf("%d 0 obj", i+1))) {
+			t.Errorf("Xref entry %d does not point to its object", i+1)
+		}
+	}
+	if !bytes.Contains(out, []byte("/Count 2")) {
+		t.Errorf("Pdf does not contain 2 pages")
+	}
+}
+
+func TestRenderPdf(t *testing.T) {
+	data := SummaryData{
+		Title:         "Spending report January 2026",
+		PreviousLabel: "December 2025",
+		Current:       repository.CountSumHolder{Count: 3, Sum: 60},
+		Previous:      repository.CountSumHolder{Count: 2, Sum: 40},
+		Categories:    map[string]string{"c1": "Food", "c2": "Drugstore"},
+		Venues:        map[string]string{"v1": "Market"},
+		Shoppers:      map[string]string{"s1": "Alex"},
+		Purchases: []repository.Purchase{
+			{Category: "c1", Venue: "v1", Shopper: "s1", Date: "2026-01-03", Sum: "25.00"},
+			{Category: "c2", Venue: "v1", Shopper: "s1", Date: "2026-01-02", Sum: "20.00"},
+			{Category: "c1", Venue: "v1", Shopper: "s1", Date: "2026-01-10", Sum: "15.00"},
+		},
+	}
+
+	out := RenderPdf(&data)
+	for _, s := range []string{"Spending report January 2026", "December 2025", "+20.00 \x80 \\(+50.0 %\\)", "Food", "40.00 \x80", "Market", "2026-01-10"} {
+		if !bytes.Contains(out, []byte(s)) {
+			t.Errorf("RenderPdf output does not contain '%s'", s)
+		}
+	}
+}
+
+func TestRenderPdfPageBreak(t *testing.T) {
+	data := SummaryData{Title: "Spending report March 2026", Purchases: make([]repository.Purchase, 0)}
+	for i := 0; i < 100; i++ {
+		data.Purchases = append(data.Purchases, repository.Purchase{Date: "2026-03-01", Sum: "1.00"})
+	}
+
+	out := RenderPdf(&data)
+	if !bytes.Contains(out, []byte("/Count 3")) {
+		t.Errorf("RenderPdf does not spread 100 purchases over 3 pages")
+	}
+}
+
+func TestBreakdown(t *testing.T) {
+	purchases := []repository.Purchase{
+		{Category: "a", Sum: "1.10"},
+		{Category: "b", Sum: "5"},
+		{Category: "a", Sum: "2.20"},
+		{Category: "x", Sum: "1"},
+	}
+	names := map[string]string{"a": "A", "b": "B"}
+
+	res := breakdown(purchases, func(p repository.Purchase) string { return names[p.Category] })
+	if len(res) != 3 || res[0].Name != "B" || res[1].Name != "A" || res[1].Sum != 3.3 || res[1].Count != 2 || res[2].Name != "Unknown" {
+		t.Errorf("breakdown returns %+v instead of expected entries", res)
+	}
+}
+
+func TestRenderCsv(t *testing.T) {
+	data := SummaryData{
+		Categories: map[string]string{"c1": "Food; Drinks"},
+		Venues:     map[string]string{},
+		Shoppers:   map[string]string{"s1": "Alex"},
+		Purchases: []repository.Purchase{
+			{Category: "c1", Venue: "v1", Shopper: "s1", Date: "2026-01-03", Sum: "25.00"},
+			{Category: "c1", Venue: "v1", Shopper: "s1", Date: "2026-01-02", Sum: "2.50"},
+		},
+	}
+
+	out, err := RenderCsv(&data)
+	if err != nil {
+		t.Fatalf("RenderCsv returns error: %s", err)
+	}
+	expected := "date,category,venue,shopper,sum\n2026-01-02,Food; Drinks,v1,Alex,2.50\n2026-01-03,Food; Drinks,v1,Alex,25.00\n"
+	if string(out) != expected {
+		t.Errorf("RenderCsv returns '%s' instead of expected '%s'", out, expected)
+	}
+}
+
+func TestRenderHtml(t *testing.T) {
+	data := SummaryData{
+		Title:      "Spending report week 2026-W41",
+		Current:    repository.CountSumHolder{Count: 1, Sum: 5},
+		Categories: map[string]string{"c1": "<Snacks>"},
+		Purchases:  []repository.Purchase{{Category: "c1", Date: "2026-10-06", Sum: "5.00"}},
+	}
+
+	out, err := RenderHtml(&data)
+	if err != nil {
+		t.Fatalf("RenderHtml returns error: %s", err)
+	}
+	for _, s := range []string{"<h1>Spending report week 2026-W41</h1>", "&lt;Snacks&gt;", "5.00 €", "2026-10-06"} {
+		if !bytes.Contains(out, []byte(s)) {
+			t.Errorf("RenderHtml output does not contain '%s'", s)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"strconv"
+
+	arango "github.com/arangodb/go-driver"
+
+	"github.com/mandrakey/shoptrac/config"
+)
+
+const (
+	COLLECTION_AGGREGATES       = "aggregates"
+	COLLECTION_DAILY_AGGREGATES = "daily_aggregates"
+)
+
+/*
+An Aggregate holds the number and sum of all purchases of one month for a combination of category, venue and shopper.
+Its key is derived from these fields, so the same combination always maps to the same document. Daily aggregates hold
+the same for every date instead of month and year, for statistics on arbitrary ranges of dates.
+*/
+type Aggregate struct {
+	Key      string  `json:"_key"`
+	Year     int     `json:"year"`
+	Month    int     `json:"month"`
+	Date     string  `json:"date,omitempty"`
+	Category string  `json:"category"`
+	Venue    string  `json:"venue"`
+	Shopper  string  `json:"shopper"`
+	Count    int     `json:"count"`
+	Sum      float64 `json:"sum"`
+}
+
+// All queries must derive the aggregate key the same way. Every component is converted to a string, as
+// CONCAT_SEPARATOR would skip missing values and thereby map different combinations to the same key.
+const (
+	qryApplyAggregate = `LET key = SHA1(CONCAT_SEPARATOR("/", TO_STRING(@year), TO_STRING(@month), TO_STRING(@category), TO_STRING(@venue), TO_STRING(@shopper)))
+		UPSERT { _key: key }
+		INSERT {
+			_key: key,
+			year: @year,
+			month: @month,
+			category: @category,
+			venue: @venue,
+			shopper: @shopper,
+			count: @count,
+			sum: ROUND(@sum * 100) / 100
+		}
+		UPDATE { count: OLD.count + @count, sum: ROUND((OLD.sum + @sum) * 100) / 100 }
+		IN aggregates`
+
+	qryApplyAggregates = `FOR p IN purchases
+		FILTER p._key IN @keys
+		COLLECT year = TO_NUMBER(p.year), month = TO_NUMBER(p.month), category = TO_STRING(p.category),
+			venue = TO_STRING(p.venue), shopper = TO_STRING(p.shopper)
+		AGGREGATE cnt = COUNT(p), sum = SUM(TO_NUMBER(p.sum))
+		LET key = SHA1(CONCAT_SEPARATOR("/", TO_STRING(year), TO_STRING(month), category, venue, shopper))
+		UPSERT { _key: key }
+		INSERT {
+			_key: key,
+			year: year,
+			month: month,
+			category: category,
+			venue: venue,
+			shopper: shopper,
+			count: cnt,
+			sum: ROUND(sum * 100) / 100
+		}
+		UPDATE { count: OLD.count + cnt, sum: ROUND((OLD.sum + sum) * 100) / 100 }
+		IN aggregates`
+
+	qryRemoveEmptyAggregate = `FOR a IN aggregates
+		FILTER a._key == SHA1(CONCAT_SEPARATOR("/", TO_STRING(@year), TO_STRING(@month), TO_STRING(@category), TO_STRING(@venue), TO_STRING(@shopper)))
+		FILTER a.count <= 0
+		REMOVE a IN aggregates`
+
+	qryRebuildAggregates = `FOR p IN purchases
+		COLLECT year = TO_NUMBER(p.year), month = TO_NUMBER(p.month), category = TO_STRING(p.category),
+			venue = TO_STRING(p.venue), shopper = TO_STRING(p.shopper)
+		AGGREGATE cnt = COUNT(p), sum = SUM(TO_NUMBER(p.sum))
+		INSERT {
+			_key: SHA1(CONCAT_SEPARATOR("/", TO_STRING(year), TO_STRING(month), category, venue, shopper)),
+			year: year,
+			month: month,
+			category: category,
+			venue: venue,
+			shopper: shopper,
+			count: cnt,
+			sum: ROUND(sum * 100) / 100
+		} INTO aggregates`
+
+	qryApplyDailyAggregate = `LET key = SHA1(CONCAT_SEPARATOR("/", TO_STRING(@date), TO_STRING(@category), TO_STRING(@venue), TO_STRING(@shopper)))
+		UPSERT { _key: key }
+		INSERT {
+			_key: key,
+			date: @date,
+			category: @category,
+			venue: @venue,
+			shopper: @shopper,
+			count: @count,
+			sum: ROUND(@sum * 100) / 100
+		}
+		UPDATE { count: OLD.count + @count, sum: ROUND((OLD.sum + @sum) * 100) / 100 }
+		IN daily_aggregates`
+
+	qryApplyDailyAggregates = `FOR p IN purchases
+		FILTER p._key IN @keys
+		COLLECT date = TO_STRING(p.date), category = TO_STRING(p.category), venue = TO_STRING(p.venue),
+			shopper = TO_STRING(p.shopper)
+		AGGREGATE cnt = COUNT(p), sum = SUM(TO_NUMBER(p.sum))
+		LET key = SHA1(CONCAT_SEPARATOR("/", date, category, venue, shopper))
+		UPSERT { _key: key }
+		INSERT {
+			_key: key,
+			date: date,
+			category: category,
+			venue: venue,
+			shopper: shopper,
+			count: cnt,
+			sum: ROUND(sum * 100) / 100
+		}
+		UPDATE { count: OLD.count + cnt, sum: ROUND((OLD.sum + sum) * 100) / 100 }
+		IN daily_aggregates`
+
+	qryRemoveEmptyDailyAggregate = `FOR a IN daily_aggregates
+		FILTER a._key == SHA1(CONCAT_SEPARATOR("/", TO_STRING(@date), TO_STRING(@category), TO_STRING(@venue), TO_STRING(@shopper)))
+		FILTER a.count <= 0
+		REMOVE a IN daily_aggregates`
+
+	qryRebuildDailyAggregates = `FOR p IN purchases
+		COLLECT date = TO_STRING(p.date), category = TO_STRING(p.category), venue = TO_STRING(p.venue),
+			shopper = TO_STRING(p.shopper)
+		AGGREGATE cnt = COUNT(p), sum = SUM(TO_NUMBER(p.sum))
+		INSERT {
+			_key: SHA1(CONCAT_SEPARATOR("/", date, category, venue, shopper)),
+			date: date,
+			category: category,
+			venue: venue,
+			shopper: shopper,
+			count: cnt,
+			sum: ROUND(sum * 100) / 100
+		} INTO daily_aggregates`
+)
+
+/*
+RebuildAggregates recalculates all monthly and daily aggregates from the purchases collection. This is required only if
+the aggregates got out of sync, e.g. after purchases have been changed directly in the database.
+*/
+func RebuildAggregates() error {
+	db, err := GetDb()
+	if err != nil {
+		return err
+	}
+
+	return rebuildAggregates(db)
+}
+
+func rebuildAggregates(db arango.Database) error {
+	log := config.Logger()
+
+	rebuild := []struct {
+		collection string
+		query      string
+	}{
+		{COLLECTION_AGGREGATES, qryRebuildAggregates},
+		{COLLECTION_DAILY_AGGREGATES, qryRebuildDailyAggregates},
+	}
+
+	for _, r := range rebuild {
+		col, err := db.Collection(ctx, r.collection)
+		if err != nil {
+			return err
+		}
+
+		err = col.Truncate(ctx)
+		if err != nil {
+			return err
+		}
+
+		c, err := db.Query(ctx, r.query, nil)
+		if err != nil {
+			return err
+		}
+		c.Close()
+
+		cnt, err := col.Count(ctx)
+		if err != nil {
+			return err
+		}
+		log.Infof("Rebuilt %d %s from purchases.", cnt, r.collection)
+	}
+
+	return nil
+}
+
+/*
+Adds the provided purchase to its monthly and daily aggregates, or removes it if sign is negative.
+*/
+func applyAggregate(p *Purchase, sign int) error {
+	db, err := GetDb()
+	if err != nil {
+		return err
+	}
+
+	// Invalid sums count as zero, like TO_NUMBER does in the queries
+	sum, _ := strconv.ParseFloat(p.Sum, 64)
+
+	monthly := map[string]interface{}{
+		"year":     p.Year,
+		"month":    p.Month,
+		"category": p.Category,
+		"venue":    p.Venue,
+		"shopper":  p.Shopper,
+		"count":    sign,
+		"sum":      float64(sign) * sum,
+	}
+	err = runAggregateQueries(db, qryApplyAggregate, qryRemoveEmptyAggregate, monthly, sign)
+	if err != nil {
+		return err
+	}
+
+	daily := map[string]interface{}{
+		"date":     p.Date,
+		"category": p.Category,
+		"venue":    p.Venue,
+		"shopper":  p.Shopper,
+		"count":    sign,
+		"sum":      float64(sign) * sum,
+	}
+	return runAggregateQueries(db, qryApplyDailyAggregate, qryRemoveEmptyDailyAggregate, daily, sign)
+}
+
+/*
+Applies the count and sum to an aggregate and, when removing, deletes the aggregate once it is empty.
+*/
+func runAggregateQueries(db arango.Database, apply string, removeEmpty string, data map[string]interface{}, sign int) error {
+	c, err := db.Query(ctx, apply, data)
+	if err != nil {
+		return err
+	}
+	c.Close()
+
+	if sign > 0 {
+		return nil
+	}
+
+	delete(data, "count")
+	delete(data, "sum")
+	c, err = db.Query(ctx, removeEmpty, data)
+	if err != nil {
+		return err
+	}
+	c.Close()
+
+	return nil
+}
+
+/*
+Adds the newly stored purchases with the provided keys to their monthly and daily aggregates.
+*/
+func applyAggregates(keys []string) error {
+	db, err := GetDb()
+	if err != nil {
+		return err
+	}
+
+	for _, qry := range []string{qryApplyAggregates, qryApplyDailyAggregates} {
+		c, err := db.Query(ctx, qry, map[string]interface{}{"keys": keys})
+		if err != nil {
+			return err
+		}
+		c.Close()
+	}
+
+	return nil
+}
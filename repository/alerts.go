@@ -0,0 +1,212 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	arango "github.com/arangodb/go-driver"
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+const (
+	COLLECTION_ALERT_RULES = "alert_rules"
+	COLLECTION_ALERT_LOG   = "alert_log"
+
+	ALERT_TYPE_BUDGET   = "budget"
+	ALERT_TYPE_PURCHASE = "purchase"
+)
+
+/*
+An AlertRule notifies a user through the configured channel. Budget rules trigger once the budget for their category
+(or the overall budget, if no category is set) reaches the threshold in percent. Purchase rules trigger for every single
+purchase with a sum of at least the threshold, optionally restricted to a category.
+*/
+type AlertRule struct {
+	Key       string  `json:"_key"`
+	UserKey   string  `json:"user_key"`
+	Type      string  `json:"type"`
+	Category  string  `json:"category"`
+	Threshold float64 `json:"threshold"`
+	Channel   string  `json:"channel"`
+	Target    string  `json:"target"`
+}
+
+type AlertLogEntry struct {
+	Key       string `json:"_key"`
+	Rule      string `json:"rule"`
+	Reference string `json:"reference"`
+	Created   string `json:"created"`
+}
+
+func GetAlertRules() (*[]AlertRule, error) {
+	return queryAlertRules("FOR r IN alert_rules RETURN r", nil)
+}
+
+func GetAlertRulesForUser(userKey string) (*[]AlertRule, error) {
+	return queryAlertRules(
+		"FOR r IN alert_rules FILTER r.user_key == @userKey RETURN r",
+		map[string]interface{}{"userKey": userKey},
+	)
+}
+
+func GetAlertRule(key string) (*AlertRule, error) {
+	col, err := GetCollection(COLLECTION_ALERT_RULES)
+	if err != nil {
+		return nil, err
+	}
+
+	var r AlertRule
+	_, err = col.ReadDocument(ctx, key, &r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+func AddAlertRule(rule AlertRule) (*AlertRule, error) {
+	col, err := GetCollection(COLLECTION_ALERT_RULES)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate uuid: %s", err)
+	}
+	rule.Key = key.String()
+
+	err = validateAlertRule(&rule)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = col.CreateDocument(ctx, rule)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rule, nil
+}
+
+/*
+Removes the alert rule with the provided key, if it belongs to the given user.
+*/
+func DeleteAlertRule(userKey string, key string) error {
+	rule, err := GetAlertRule(key)
+	if err != nil {
+		return err
+	}
+	if rule.UserKey != userKey {
+		return fmt.Errorf("Not authorized")
+	}
+
+	col, err := GetCollection(COLLECTION_ALERT_RULES)
+	if err != nil {
+		return err
+	}
+
+	_, err = col.RemoveDocument(ctx, key)
+	return err
+}
+
+/*
+MarkAlertSent records that the alert rule with the provided key has fired for the given reference, e.g. a month or a
+purchase. It returns false if the alert has already been recorded before, which is used to send every alert only once.
+*/
+func MarkAlertSent(ruleKey string, reference string) (bool, error) {
+	col, err := GetCollection(COLLECTION_ALERT_LOG)
+	if err != nil {
+		return false, err
+	}
+
+	entry := AlertLogEntry{
+		Key:       alertLogKey(ruleKey, reference),
+		Rule:      ruleKey,
+		Reference: reference,
+		Created:   DateTimeToDb(time.Now().UTC()),
+	}
+	_, err = col.CreateDocument(ctx, entry)
+	if arango.IsConflict(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+/*
+Removes a recorded alert again, so it will be sent on the next evaluation. Used if the delivery failed.
+*/
+func UnmarkAlertSent(ruleKey string, reference string) error {
+	col, err := GetCollection(COLLECTION_ALERT_LOG)
+	if err != nil {
+		return err
+	}
+
+	_, err = col.RemoveDocument(ctx, alertLogKey(ruleKey, reference))
+	return err
+}
+
+func alertLogKey(ruleKey string, reference string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(ruleKey+"/"+reference)))
+}
+
+func queryAlertRules(qry string, bindVars map[string]interface{}) (*[]AlertRule, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := db.Query(ctx, qry, bindVars)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	res := make([]AlertRule, 0)
+	for {
+		var r AlertRule
+		_, err := c.ReadDocument(ctx, &r)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		res = append(res, r)
+	}
+
+	return &res, nil
+}
+
+func validateAlertRule(r *AlertRule) error {
+	if r.Key == "" {
+		return fmt.Errorf("Missing alert rule key")
+	}
+	if r.UserKey == "" {
+		return fmt.Errorf("Missing alert rule user")
+	}
+	if r.Type != ALERT_TYPE_BUDGET && r.Type != ALERT_TYPE_PURCHASE {
+		return fmt.Errorf("Invalid alert rule type '%s'", r.Type)
+	}
+	if r.Threshold <= 0 {
+		return fmt.Errorf("Alert rule threshold must be greater than 0")
+	}
+	if r.Channel == "" {
+		return fmt.Errorf("Missing alert rule channel")
+	}
+	if r.Target == "" {
+		return fmt.Errorf("Missing alert rule target")
+	}
+
+	return nil
+}
@@ -0,0 +1,457 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	arango "github.com/arangodb/go-driver"
+
+	"github.com/mandrakey/shoptrac/config"
+)
+
+const (
+	COLLECTION_ANOMALIES = "anomalies"
+	COLLECTION_BASELINES = "baselines"
+
+	ANOMALY_TYPE_PURCHASE = "purchase"
+	ANOMALY_TYPE_SPIKE    = "spike"
+
+	ANOMALY_SCORE_THRESHOLD = 3.5
+	ANOMALY_MIN_SAMPLES     = 5
+	SPIKE_TRAILING_MONTHS   = 6
+	SPIKE_MIN_MONTHS        = 3
+)
+
+/*
+A Baseline describes the typical purchase sum for one venue or category.
+*/
+type Baseline struct {
+	Key       string  `json:"_key"`
+	Dimension string  `json:"dimension"`
+	Value     string  `json:"value"`
+	Count     int     `json:"count"`
+	Median    float64 `json:"median"`
+	Mad       float64 `json:"mad"`
+}
+
+/*
+An Anomaly is either a purchase which is unusually large for its venue or category, or a month in which the spending of
+a category spikes against its trailing average.
+*/
+type Anomaly struct {
+	Key       string  `json:"_key"`
+	Type      string  `json:"type"`
+	Purchase  string  `json:"purchase,omitempty"`
+	Dimension string  `json:"dimension"`
+	Value     string  `json:"value"`
+	Date      string  `json:"date,omitempty"`
+	Month     int     `json:"month"`
+	Year      int     `json:"year"`
+	Sum       float64 `json:"sum"`
+	Median    float64 `json:"median"`
+	Score     float64 `json:"score"`
+}
+
+type anomalyPurchase struct {
+	Key      string  `json:"_key"`
+	Category string  `json:"category"`
+	Venue    string  `json:"venue"`
+	Date     string  `json:"date"`
+	Month    int     `json:"month"`
+	Year     int     `json:"year"`
+	Sum      float64 `json:"sum"`
+}
+
+/*
+A Baseline along with the unrounded values used to score purchases against it.
+*/
+type purchaseBaseline struct {
+	Baseline
+	median    float64
+	mad       float64
+	deviation float64
+}
+
+/*
+RefreshAnomalies recomputes the per-venue and per-category baselines (median and median absolute deviation) of all
+purchases and replaces the stored baselines and anomalies with the newly detected ones. The purchases are read one
+venue or category at a time to compute the baselines, and then streamed to score them, so they are never all held in
+memory. Spikes are detected from the monthly aggregates.
+*/
+func RefreshAnomalies() error {
+	log := config.Logger()
+
+	db, err := GetDb()
+	if err != nil {
+		return err
+	}
+
+	baselines, err := queryPurchaseBaselines(db)
+	if err != nil {
+		return fmt.Errorf("Failed to compute baselines: %s", err)
+	}
+
+	// ----
+	// Score every purchase against the baselines of its venue and category
+
+	c, err := db.Query(
+		ctx,
+		"FOR p IN purchases RETURN { _key: p._key, category: p.category, venue: p.venue, date: p.date, month: p.month, year: p.year, sum: TO_NUMBER(p.sum) }",
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	flagged := make(map[string]Anomaly)
+	for {
+		var p anomalyPurchase
+		_, err := c.ReadDocument(ctx, &p)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		flagPurchaseAnomaly(p, baselines, flagged)
+	}
+	anomalies := sortedAnomalies(flagged)
+
+	totals, err := queryMonthlyCategoryTotals(db)
+	if err != nil {
+		return err
+	}
+	anomalies = append(anomalies, detectSpikes(totals, Today())...)
+
+	// ----
+	// Replace stored results
+
+	stored := make([]Baseline, 0, len(baselines))
+	for _, b := range baselines {
+		stored = append(stored, b.Baseline)
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].Key < stored[j].Key })
+
+	tid, err := db.BeginTransaction(ctx, arango.TransactionCollections{Write: []string{COLLECTION_BASELINES, COLLECTION_ANOMALIES}}, nil)
+	if err != nil {
+		return err
+	}
+	tctx := arango.WithTransactionID(ctx, tid)
+
+	err = replaceDocuments(tctx, db, COLLECTION_BASELINES, stored)
+	if err != nil {
+		db.AbortTransaction(ctx, tid, nil)
+		return fmt.Errorf("Failed to store baselines: %s", err)
+	}
+	err = replaceDocuments(tctx, db, COLLECTION_ANOMALIES, anomalies)
+	if err != nil {
+		db.AbortTransaction(ctx, tid, nil)
+		return fmt.Errorf("Failed to store anomalies: %s", err)
+	}
+	err = db.CommitTransaction(ctx, tid, nil)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Anomaly detection finished with %d baselines and %d anomalies.", len(stored), len(anomalies))
+	return nil
+}
+
+/*
+Returns the baselines of all venues and categories with enough purchases, by the key of the baseline.
+*/
+func queryPurchaseBaselines(db arango.Database) (map[string]purchaseBaseline, error) {
+	c, err := db.Query(
+		ctx,
+		`FOR dimension IN ["venue", "category"]
+			FOR p IN purchases
+			COLLECT d = dimension, value = TO_STRING(p[dimension]) INTO sums = TO_NUMBER(p.sum)
+			FILTER LENGTH(sums) >= @minSamples
+			RETURN { dimension: d, value: value, sums: sums }`,
+		map[string]interface{}{"minSamples": ANOMALY_MIN_SAMPLES},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	res := make(map[string]purchaseBaseline)
+	for {
+		var group struct {
+			Dimension string    `json:"dimension"`
+			Value     string    `json:"value"`
+			Sums      []float64 `json:"sums"`
+		}
+		_, err := c.ReadDocument(ctx, &group)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		b := newPurchaseBaseline(group.Dimension, group.Value, group.Sums)
+		res[b.Key] = b
+	}
+
+	return res, nil
+}
+
+/*
+Returns the spending per category and month, as one anomalyPurchase per category and month.
+*/
+func queryMonthlyCategoryTotals(db arango.Database) ([]anomalyPurchase, error) {
+	c, err := db.Query(
+		ctx,
+		`FOR a IN aggregates
+		COLLECT category = a.category, year = a.year, month = a.month AGGREGATE sum = SUM(a.sum)
+		RETURN { category: category, year: year, month: month, sum: sum }`,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	res := make([]anomalyPurchase, 0)
+	for {
+		var p anomalyPurchase
+		_, err := c.ReadDocument(ctx, &p)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		res = append(res, p)
+	}
+
+	return res, nil
+}
+
+func GetAnomalies() (*[]Anomaly, error) {
+	return queryAnomalies("", nil)
+}
+
+/*
+GetAnomaliesInRange returns the anomalous purchases dated within the period and the spikes of all months overlapping it.
+*/
+func GetAnomaliesInRange(r DateRange) (*[]Anomaly, error) {
+	return queryAnomalies(
+		`FILTER a.type == @purchase
+			? a.date >= @from AND a.date <= @to
+			: a.year * 100 + a.month >= @fromMonth AND a.year * 100 + a.month <= @toMonth`,
+		map[string]interface{}{
+			"purchase":  ANOMALY_TYPE_PURCHASE,
+			"from":      r.FromDb(),
+			"to":        r.ToDb(),
+			"fromMonth": r.From.Year()*100 + int(r.From.Month()),
+			"toMonth":   r.To.Year()*100 + int(r.To.Month()),
+		},
+	)
+}
+
+func queryAnomalies(filter string, data map[string]interface{}) (*[]Anomaly, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := db.Query(ctx, "FOR a IN anomalies "+filter+" SORT a.year DESC, a.month DESC, a.score DESC RETURN a", data)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	res := make([]Anomaly, 0)
+	for {
+		var a Anomaly
+		_, err := c.ReadDocument(ctx, &a)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		res = append(res, a)
+	}
+
+	return &res, nil
+}
+
+func GetBaselines() (*[]Baseline, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := db.Query(ctx, "FOR b IN baselines SORT b.dimension, b.value RETURN b", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	res := make([]Baseline, 0)
+	for {
+		var b Baseline
+		_, err := c.ReadDocument(ctx, &b)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		res = append(res, b)
+	}
+
+	return &res, nil
+}
+
+func newPurchaseBaseline(dimension string, value string, sums []float64) purchaseBaseline {
+	med := median(sums)
+	mad := medianAbsoluteDeviation(sums, med)
+
+	return purchaseBaseline{
+		Baseline: Baseline{
+			Key:       fmt.Sprintf("%s-%s", dimension, value),
+			Dimension: dimension,
+			Value:     value,
+			Count:     len(sums),
+			Median:    roundSum(med),
+			Mad:       roundSum(mad),
+		},
+		median:    med,
+		mad:       mad,
+		deviation: meanAbsoluteDeviation(sums, med),
+	}
+}
+
+/*
+Scores the purchase against the baselines of its venue and category. Only unusually large purchases are of interest;
+flagged keeps the highest score per purchase.
+*/
+func flagPurchaseAnomaly(p anomalyPurchase, baselines map[string]purchaseBaseline, flagged map[string]Anomaly) {
+	for _, key := range []string{"venue-" + p.Venue, "category-" + p.Category} {
+		b, ok := baselines[key]
+		if !ok {
+			continue
+		}
+
+		score := deviationScore(p.Sum, b.median, b.mad, b.deviation)
+		if score < ANOMALY_SCORE_THRESHOLD || score <= flagged[p.Key].Score {
+			continue
+		}
+		flagged[p.Key] = Anomaly{
+			Key:       p.Key,
+			Type:      ANOMALY_TYPE_PURCHASE,
+			Purchase:  p.Key,
+			Dimension: b.Dimension,
+			Value:     b.Value,
+			Date:      p.Date,
+			Month:     p.Month,
+			Year:      p.Year,
+			Sum:       roundSum(p.Sum),
+			Median:    b.Median,
+			Score:     math.Round(score*100) / 100,
+		}
+	}
+}
+
+func sortedAnomalies(flagged map[string]Anomaly) []Anomaly {
+	anomalies := make([]Anomaly, 0, len(flagged))
+	for _, a := range flagged {
+		anomalies = append(anomalies, a)
+	}
+	sort.Slice(anomalies, func(i, j int) bool { return anomalies[i].Key < anomalies[j].Key })
+	return anomalies
+}
+
+/*
+Flags months in which the spending of a category is unusually high compared to the trailing months. The purchases may
+as well be the totals per category and month. The current month is not complete yet and therefore skipped.
+*/
+func detectSpikes(purchases []anomalyPurchase, now time.Time) []Anomaly {
+	totals := make(map[string]map[int]float64)
+	for _, p := range purchases {
+		if totals[p.Category] == nil {
+			totals[p.Category] = make(map[int]float64)
+		}
+		totals[p.Category][p.Year*12+p.Month-1] += p.Sum
+	}
+
+	current := now.Year()*12 + int(now.Month()) - 1
+	anomalies := make([]Anomaly, 0)
+	for category, months := range totals {
+		for index, total := range months {
+			if index >= current {
+				continue
+			}
+
+			trailing := make([]float64, 0, SPIKE_TRAILING_MONTHS)
+			for i := index - SPIKE_TRAILING_MONTHS; i < index; i++ {
+				if v, ok := months[i]; ok {
+					trailing = append(trailing, v)
+				}
+			}
+			if len(trailing) < SPIKE_MIN_MONTHS {
+				continue
+			}
+
+			med := median(trailing)
+			score := robustScore(total, med, medianAbsoluteDeviation(trailing, med), trailing)
+			if score < ANOMALY_SCORE_THRESHOLD {
+				continue
+			}
+
+			year, month := index/12, index%12+1
+			anomalies = append(anomalies, Anomaly{
+				Key:       fmt.Sprintf("spike-%s-%04d-%02d", category, year, month),
+				Type:      ANOMALY_TYPE_SPIKE,
+				Dimension: "category",
+				Value:     category,
+				Month:     month,
+				Year:      year,
+				Sum:       roundSum(total),
+				Median:    roundSum(med),
+				Score:     math.Round(score*100) / 100,
+			})
+		}
+	}
+
+	sort.Slice(anomalies, func(i, j int) bool { return anomalies[i].Key < anomalies[j].Key })
+	return anomalies
+}
+
+/*
+Replaces all documents of the collection with the provided name by the given documents, matching them by key and
+removing all others. The context must carry a transaction writing to the collection, so readers see either the old or
+the new documents.
+*/
+func replaceDocuments(tctx context.Context, db arango.Database, collection string, documents interface{}) error {
+	queries := []string{
+		"FOR d IN @documents UPSERT { _key: d._key } INSERT d REPLACE d IN @@collection",
+		"FOR d IN @@collection FILTER d._key NOT IN @documents[*]._key REMOVE d IN @@collection",
+	}
+	for _, qry := range queries {
+		c, err := db.Query(tctx, qry, map[string]interface{}{"@collection": collection, "documents": documents})
+		if err != nil {
+			return err
+		}
+		c.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	values := []float64{4, 1, 3, 2}
+	if v := median(values); v != 2.5 {
+		t.Errorf("median returns %f instead of expected 2.5", v)
+	}
+	if v := percentile(values, 0); v != 1 {
+		t.Errorf("percentile(0) returns %f instead of expected 1", v)
+	}
+	if v := percentile(values, 100); v != 4 {
+		t.Errorf("percentile(100) returns %f instead of expected 4", v)
+	}
+	if v := percentile(values, 25); math.Abs(v-1.75) > 1e-9 {
+		t.Errorf("percentile(25) returns %f instead of expected 1.75", v)
+	}
+	if v := percentile(nil, 50); v != 0 {
+		t.Errorf("percentile of no values returns %f instead of expected 0", v)
+	}
+}
+
+func TestRobustScore(t *testing.T) {
+	values := []float64{10, 11, 12, 13, 100}
+	med := median(values)
+	mad := medianAbsoluteDeviation(values, med)
+	if med != 12 || mad != 1 {
+		t.Errorf("median and MAD return %f and %f instead of expected 12 and 1", med, mad)
+	}
+	if s := robustScore(100, med, mad, values); s < ANOMALY_SCORE_THRESHOLD {
+		t.Errorf("robustScore returns %f for an outlier", s)
+	}
+
+	// More than half of the values are equal: fall back to the mean absolute deviation
+	values = []float64{10, 10, 10, 10, 50}
+	if s := robustScore(50, 10, 0, values); s < ANOMALY_SCORE_THRESHOLD {
+		t.Errorf("robustScore returns %f for an outlier with zero MAD", s)
+	}
+	if s := robustScore(10, 10, 0, []float64{10, 10}); s != 0 {
+		t.Errorf("robustScore returns %f for constant values instead of expected 0", s)
+	}
+}
+
+func TestFlagPurchaseAnomaly(t *testing.T) {
+	purchases := []anomalyPurchase{
+		{Key: "1", Venue: "v1", Category: "food", Sum: 20},
+		{Key: "2", Venue: "v1", Category: "food", Sum: 22},
+		{Key: "3", Venue: "v1", Category: "food", Sum: 19},
+		{Key: "4", Venue: "v1", Category: "food", Sum: 21},
+		{Key: "5", Venue: "v1", Category: "food", Sum: 23},
+		{Key: "6", Venue: "v1", Category: "food", Sum: 150},
+		{Key: "7", Venue: "v1", Category: "food", Sum: 5},
+		{Key: "8", Venue: "v2", Category: "other", Sum: 1000},
+	}
+
+	// Baselines are only computed for groups of at least ANOMALY_MIN_SAMPLES purchases
+	sums := make(map[string][]float64)
+	for _, p := range purchases {
+		sums["venue/"+p.Venue] = append(sums["venue/"+p.Venue], p.Sum)
+		sums["category/"+p.Category] = append(sums["category/"+p.Category], p.Sum)
+	}
+	baselines := make(map[string]purchaseBaseline)
+	for group, values := range sums {
+		if len(values) < ANOMALY_MIN_SAMPLES {
+			continue
+		}
+		parts := strings.SplitN(group, "/", 2)
+		b := newPurchaseBaseline(parts[0], parts[1], values)
+		baselines[b.Key] = b
+	}
+	if len(baselines) != 2 {
+		t.Errorf("Returns %d baselines instead of expected 2", len(baselines))
+	}
+	if b := baselines["venue-v1"]; b.Count != 7 || b.Median != 21 || b.Mad != 2 {
+		t.Errorf("Returns baseline %+v for venue v1", b.Baseline)
+	}
+
+	flagged := make(map[string]Anomaly)
+	for _, p := range purchases {
+		flagPurchaseAnomaly(p, baselines, flagged)
+	}
+	anomalies := sortedAnomalies(flagged)
+	if len(anomalies) != 1 || anomalies[0].Purchase != "6" || anomalies[0].Type != ANOMALY_TYPE_PURCHASE {
+		t.Fatalf("Returns anomalies %+v instead of expected purchase 6", anomalies)
+	}
+	if anomalies[0].Median != 21 {
+		t.Errorf("Anomaly median is %f instead of expected 21", anomalies[0].Median)
+	}
+}
+
+func TestDetectSpikes(t *testing.T) {
+	purchases := []anomalyPurchase{
+		{Category: "food", Month: 1, Year: 2026, Sum: 300},
+		{Category: "food", Month: 2, Year: 2026, Sum: 320},
+		{Category: "food", Month: 3, Year: 2026, Sum: 310},
+		{Category: "food", Month: 4, Year: 2026, Sum: 900},
+		{Category: "food", Month: 5, Year: 2026, Sum: 2000},
+	}
+
+	// May is the current month and must be ignored
+	spikes := detectSpikes(purchases, time.Date(2026, 5, 10, 0, 0, 0, 0, time.UTC))
+	if len(spikes) != 1 {
+		t.Fatalf("Returns %d spikes instead of expected 1", len(spikes))
+	}
+	if spikes[0].Month != 4 || spikes[0].Year != 2026 || spikes[0].Median != 310 {
+		t.Errorf("Returns spike %+v instead of expected April 2026", spikes[0])
+	}
+}
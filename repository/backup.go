@@ -0,0 +1,233 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	arango "github.com/arangodb/go-driver"
+)
+
+const (
+	BACKUP_BATCH_SIZE        = 500
+	BACKUP_MAX_DOCUMENT_SIZE = 64 * 1024 * 1024
+)
+
+/*
+BackupCollections returns the names of all collections of the database except the system collections, sorted by name.
+*/
+func BackupCollections() ([]string, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	cols, err := db.Collections(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]string, 0, len(cols))
+	for _, c := range cols {
+		if !strings.HasPrefix(c.Name(), "_") {
+			res = append(res, c.Name())
+		}
+	}
+	sort.Strings(res)
+
+	return res, nil
+}
+
+/*
+SchemaVersion returns the version of the last migration run on the database, or 0 if it has never been migrated.
+*/
+func SchemaVersion() (int, error) {
+	db, err := GetDb()
+	if err != nil {
+		return -1, err
+	}
+
+	exists, err := db.CollectionExists(ctx, COLLECTION_SHOPTRAC_MIGRATIONS)
+	if err != nil {
+		return -1, err
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	return getCurrentDbVersion(db)
+}
+
+/*
+CountDocuments returns the number of documents in the collection, which is 0 if the collection does not exist.
+*/
+func CountDocuments(name string) (int64, error) {
+	db, err := GetDb()
+	if err != nil {
+		return -1, err
+	}
+
+	exists, err := db.CollectionExists(ctx, name)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	col, err := db.Collection(ctx, name)
+	if err != nil {
+		return -1, err
+	}
+	return col.Count(ctx)
+}
+
+/*
+TruncateCollection removes all documents from the collection, if it exists.
+*/
+func TruncateCollection(name string) error {
+	db, err := GetDb()
+	if err != nil {
+		return err
+	}
+
+	exists, err := db.CollectionExists(ctx, name)
+	if err != nil || !exists {
+		return err
+	}
+
+	col, err := db.Collection(ctx, name)
+	if err != nil {
+		return err
+	}
+	return col.Truncate(ctx)
+}
+
+/*
+DumpCollection writes all documents of the collection to w, one JSON document per line ordered by key. The internal
+attributes _id and _rev are left out, so the documents can be imported into another database. Returns the number of
+documents written.
+*/
+func DumpCollection(name string, w io.Writer) (int, error) {
+	db, err := GetDb()
+	if err != nil {
+		return 0, err
+	}
+
+	qctx := arango.WithQueryStream(arango.WithQueryBatchSize(ctx, BACKUP_BATCH_SIZE), true)
+	c, err := db.Query(qctx, `FOR d IN @@col SORT d._key RETURN UNSET(d, "_id", "_rev")`, map[string]interface{}{"@col": name})
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+
+	count := 0
+	for {
+		var doc map[string]interface{}
+		_, err := c.ReadDocument(ctx, &doc)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return count, err
+		}
+
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return count, err
+		}
+		_, err = w.Write(append(data, '\n'))
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+/*
+RestoreCollection creates the collection if necessary and imports the documents read from r, one JSON document per line,
+in batches. Documents with a key which exists already make the import fail. Returns the number of documents imported.
+*/
+func RestoreCollection(name string, r io.Reader) (int, error) {
+	db, err := GetDb()
+	if err != nil {
+		return 0, err
+	}
+
+	col, err := ensureCollection(db, name)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	batch := make([]json.RawMessage, 0, BACKUP_BATCH_SIZE)
+	importBatch := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		stats, err := col.ImportDocuments(ctx, batch, &arango.ImportDocumentOptions{Complete: true})
+		if err != nil {
+			return err
+		}
+		if stats.Errors > 0 {
+			return fmt.Errorf("Failed to import %d documents into '%s'", stats.Errors, name)
+		}
+
+		count += int(stats.Created)
+		batch = batch[:0]
+		return nil
+	}
+
+	_, err = scanDocuments(name, r, func(doc json.RawMessage) error {
+		batch = append(batch, doc)
+		if len(batch) == BACKUP_BATCH_SIZE {
+			return importBatch()
+		}
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+
+	err = importBatch()
+	return count, err
+}
+
+/*
+VerifyDocuments reads the documents from r like RestoreCollection does, without importing them. Returns the number of
+documents read.
+*/
+func VerifyDocuments(name string, r io.Reader) (int, error) {
+	return scanDocuments(name, r, func(json.RawMessage) error { return nil })
+}
+
+func scanDocuments(name string, r io.Reader, fn func(json.RawMessage) error) (int, error) {
+	count := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), BACKUP_MAX_DOCUMENT_SIZE)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !json.Valid([]byte(line)) {
+			return count, fmt.Errorf("Invalid document %d in '%s'", count+1, name)
+		}
+
+		err := fn(json.RawMessage(line))
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, scanner.Err()
+}
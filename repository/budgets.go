@@ -0,0 +1,334 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	arango "github.com/arangodb/go-driver"
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+const (
+	COLLECTION_BUDGETS = "budgets"
+)
+
+/*
+A Budget limits the spending for one category, or for all purchases if no category is set, in a single month. Templates
+have no month and year and apply to every month without a budget of its own for the same category.
+*/
+type Budget struct {
+	Key      string `json:"_key"`
+	Category string `json:"category"`
+	Amount   string `json:"amount"`
+	Month    int    `json:"month"`
+	Year     int    `json:"year"`
+	Template bool   `json:"template"`
+}
+
+type BudgetStatus struct {
+	Budget
+	Name             string  `json:"name"`
+	Spent            float64 `json:"spent"`
+	Remaining        float64 `json:"remaining"`
+	PercentUsed      float64 `json:"percent_used"`
+	Projected        float64 `json:"projected"`
+	ProjectedOverrun float64 `json:"projected_overrun"`
+}
+
+/*
+A BudgetReport covers a period. Month and year are those the period starts in and select the budgets in effect, for
+periods other than calendar months DaysInMonth is the length of the period.
+*/
+type BudgetReport struct {
+	Period      DateRange      `json:"period"`
+	Month       int            `json:"month"`
+	Year        int            `json:"year"`
+	ElapsedDays int            `json:"elapsed_days"`
+	DaysInMonth int            `json:"days_in_month"`
+	Budgets     []BudgetStatus `json:"budgets"`
+}
+
+func GetBudgets() (*[]Budget, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := db.Query(ctx, "FOR b IN budgets SORT b.template DESC, b.year DESC, b.month DESC RETURN b", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	res := make([]Budget, 0)
+	for {
+		var b Budget
+		_, err := c.ReadDocument(ctx, &b)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		res = append(res, b)
+	}
+
+	return &res, nil
+}
+
+func GetBudget(key string) (*Budget, error) {
+	col, err := GetCollection(COLLECTION_BUDGETS)
+	if err != nil {
+		return nil, err
+	}
+
+	var b Budget
+	_, err = col.ReadDocument(ctx, key, &b)
+	if err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+func AddBudget(budget Budget) (*Budget, error) {
+	col, err := GetCollection(COLLECTION_BUDGETS)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate uuid: %s", err)
+	}
+	budget.Key = key.String()
+	if budget.Template {
+		budget.Month = 0
+		budget.Year = 0
+	}
+
+	err = validateBudget(&budget)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only one budget per category and month
+	budgets, err := GetBudgets()
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range *budgets {
+		if b.Category == budget.Category && b.Month == budget.Month && b.Year == budget.Year && b.Template == budget.Template {
+			return nil, fmt.Errorf("A budget for this category and month already exists")
+		}
+	}
+
+	_, err = col.CreateDocument(ctx, budget)
+	if err != nil {
+		return nil, err
+	}
+
+	return &budget, nil
+}
+
+func UpdateBudget(key string, data *map[string]interface{}) error {
+	col, err := GetCollection(COLLECTION_BUDGETS)
+	if err != nil {
+		return err
+	}
+
+	_, err = col.UpdateDocument(ctx, key, data)
+	return err
+}
+
+func DeleteBudget(key string) error {
+	col, err := GetCollection(COLLECTION_BUDGETS)
+	if err != nil {
+		return err
+	}
+
+	_, err = col.RemoveDocument(ctx, key)
+	return err
+}
+
+/*
+GetBudgetReport reports spent and remaining amounts, the percentage used and the projected overrun of every budget in
+effect for the provided month. See GetBudgetReportForRange.
+*/
+func GetBudgetReport(month int, year int) (*BudgetReport, error) {
+	return GetBudgetReportForRange(monthRange(month, year))
+}
+
+/*
+GetBudgetReportForRange reports on the budgets in effect for the month the period starts in, comparing them to the
+spending of the whole period.
+*/
+func GetBudgetReportForRange(r DateRange) (*BudgetReport, error) {
+	budgets, err := GetBudgets()
+	if err != nil {
+		return nil, err
+	}
+
+	spent, err := getSpentPerCategory(r)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string)
+	categories, err := GetCategories()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range *categories {
+		names[c.Key] = c.Name
+	}
+
+	return buildBudgetReport(*budgets, spent, names, r, Today()), nil
+}
+
+/*
+Returns the spending per category in the period. Periods of whole months are read from the monthly aggregates, all
+others from the daily aggregates.
+*/
+func getSpentPerCategory(r DateRange) (map[string]float64, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	qry := `FOR a IN daily_aggregates
+		FILTER a.date >= @from AND a.date <= @to
+		COLLECT category = a.category AGGREGATE sum = SUM(a.sum)
+		RETURN { category: category, sum: sum }`
+	data := map[string]interface{}{"from": r.FromDb(), "to": r.ToDb()}
+	if r.spansWholeMonths() {
+		qry = `FOR a IN aggregates
+		FILTER a.year * 100 + a.month >= @from AND a.year * 100 + a.month <= @to
+		COLLECT category = a.category AGGREGATE sum = SUM(a.sum)
+		RETURN { category: category, sum: sum }`
+		data = map[string]interface{}{
+			"from": r.From.Year()*100 + int(r.From.Month()),
+			"to":   r.To.Year()*100 + int(r.To.Month()),
+		}
+	}
+
+	c, err := db.Query(ctx, qry, data)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	res := make(map[string]float64)
+	for {
+		var s struct {
+			Category string  `json:"category"`
+			Sum      float64 `json:"sum"`
+		}
+		_, err := c.ReadDocument(ctx, &s)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		res[s.Category] = s.Sum
+	}
+
+	return res, nil
+}
+
+/*
+Returns the budgets in effect for the given month: the budgets defined for that month, and the templates for all
+categories without such a budget.
+*/
+func effectiveBudgets(budgets []Budget, month int, year int) []Budget {
+	res := make([]Budget, 0)
+	covered := make(map[string]bool)
+	for _, b := range budgets {
+		if !b.Template && b.Month == month && b.Year == year {
+			res = append(res, b)
+			covered[b.Category] = true
+		}
+	}
+	for _, b := range budgets {
+		if b.Template && !covered[b.Category] {
+			res = append(res, b)
+			covered[b.Category] = true
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Category < res[j].Category })
+	return res
+}
+
+func buildBudgetReport(budgets []Budget, spent map[string]float64, names map[string]string, r DateRange, now time.Time) *BudgetReport {
+	month, year := int(r.From.Month()), r.From.Year()
+	report := BudgetReport{
+		Period:      r,
+		Month:       month,
+		Year:        year,
+		DaysInMonth: r.Days(),
+		Budgets:     make([]BudgetStatus, 0),
+	}
+
+	// Elapsed days determine the projection: past periods are complete, future periods have not started yet.
+	report.ElapsedDays = elapsedDays(r, now)
+
+	total := 0.0
+	for _, s := range spent {
+		total += s
+	}
+
+	for _, b := range effectiveBudgets(budgets, month, year) {
+		status := BudgetStatus{Budget: b, Name: names[b.Category]}
+		if b.Category == "" {
+			status.Name = "Overall"
+			status.Spent = total
+		} else {
+			status.Spent = spent[b.Category]
+		}
+
+		amount, _ := strconv.ParseFloat(b.Amount, 64)
+		status.Remaining = roundSum(amount - status.Spent)
+		if amount > 0 {
+			status.PercentUsed = math.Round(status.Spent/amount*10000) / 100
+		}
+		if report.ElapsedDays > 0 {
+			status.Projected = roundSum(status.Spent / float64(report.ElapsedDays) * float64(report.DaysInMonth))
+		}
+		status.ProjectedOverrun = math.Max(0, roundSum(status.Projected-amount))
+		status.Spent = roundSum(status.Spent)
+
+		report.Budgets = append(report.Budgets, status)
+	}
+
+	return &report
+}
+
+func validateBudget(b *Budget) error {
+	if b.Key == "" {
+		return fmt.Errorf("Missing budget key")
+	}
+	amount, err := strconv.ParseFloat(b.Amount, 64)
+	if err != nil || amount <= 0 {
+		return fmt.Errorf("Invalid budget amount '%s'", b.Amount)
+	}
+	if !b.Template && (b.Month < 1 || b.Month > 12) {
+		return fmt.Errorf("Invalid budget month '%d'", b.Month)
+	}
+	if !b.Template && b.Year < 1 {
+		return fmt.Errorf("Invalid budget year '%d'", b.Year)
+	}
+
+	return nil
+}
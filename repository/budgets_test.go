@@ -0,0 +1,63 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildBudgetReport(t *testing.T) {
+	budgets := []Budget{
+		{Key: "t1", Category: "1", Amount: "100.00", Template: true},
+		{Key: "t2", Category: "2", Amount: "50.00", Template: true},
+		{Key: "m2", Category: "2", Amount: "80.00", Month: 3, Year: 2026},
+		{Key: "m3", Category: "2", Amount: "10.00", Month: 4, Year: 2026},
+		{Key: "all", Category: "", Amount: "300.00", Template: true},
+	}
+	spent := map[string]float64{"1": 60, "2": 20, "3": 40}
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	report := buildBudgetReport(budgets, spent, map[string]string{"1": "Food"}, monthRange(3, 2026), now)
+	if report.ElapsedDays != 15 || report.DaysInMonth != 31 {
+		t.Errorf("Returns %d of %d days instead of expected 15 of 31", report.ElapsedDays, report.DaysInMonth)
+	}
+	if len(report.Budgets) != 3 {
+		t.Fatalf("Returns %d budgets instead of expected 3", len(report.Budgets))
+	}
+
+	overall := report.Budgets[0]
+	if overall.Name != "Overall" || overall.Spent != 120 || overall.PercentUsed != 40 {
+		t.Errorf("Overall budget returns %+v", overall)
+	}
+
+	food := report.Budgets[1]
+	if food.Key != "t1" || food.Name != "Food" || food.Remaining != 40 || food.Projected != 124 || food.ProjectedOverrun != 24 {
+		t.Errorf("Template budget returns %+v", food)
+	}
+
+	other := report.Budgets[2]
+	if other.Key != "m2" {
+		t.Errorf("Month budget does not override template, got '%s'", other.Key)
+	}
+}
+
+func TestBuildBudgetReportForPeriod(t *testing.T) {
+	budgets := []Budget{
+		{Key: "m3", Category: "1", Amount: "310.00", Month: 3, Year: 2026},
+		{Key: "m4", Category: "1", Amount: "10.00", Month: 4, Year: 2026},
+	}
+	r := DateRange{From: time.Date(2026, 3, 25, 0, 0, 0, 0, time.UTC), To: time.Date(2026, 4, 24, 0, 0, 0, 0, time.UTC)}
+	now := time.Date(2026, 4, 3, 12, 0, 0, 0, time.UTC)
+
+	report := buildBudgetReport(budgets, map[string]float64{"1": 100}, nil, r, now)
+	if report.ElapsedDays != 10 || report.DaysInMonth != 31 {
+		t.Errorf("Returns %d of %d days instead of expected 10 of 31", report.ElapsedDays, report.DaysInMonth)
+	}
+	if len(report.Budgets) != 1 || report.Budgets[0].Key != "m3" || report.Budgets[0].Projected != 310 {
+		t.Errorf("Returns %+v instead of expected budget of the month the period starts in", report.Budgets)
+	}
+}
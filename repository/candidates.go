@@ -0,0 +1,365 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	arango "github.com/arangodb/go-driver"
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+const (
+	COLLECTION_IMPORT_CANDIDATES = "import_candidates"
+	COLLECTION_VENUE_RULES       = "venue_rules"
+
+	CANDIDATE_STATUS_PENDING   = "pending"
+	CANDIDATE_STATUS_CONFIRMED = "confirmed"
+	CANDIDATE_STATUS_DISCARDED = "discarded"
+)
+
+/*
+A VenueRule assigns the venue to imported transactions whose counterparty contains the pattern, ignoring case. If
+several rules match, the one with the longest pattern wins.
+*/
+type VenueRule struct {
+	Key     string `json:"_key"`
+	Pattern string `json:"pattern"`
+	Venue   string `json:"venue"`
+}
+
+/*
+An ImportCandidate is a transaction read from a bank statement, waiting to be confirmed as purchase or discarded.
+Confirmed and discarded candidates are kept, so importing the same statement again does not bring them back.
+*/
+type ImportCandidate struct {
+	Key          string `json:"_key"`
+	UserKey      string `json:"user_key"`
+	Status       string `json:"status"`
+	Date         string `json:"date"`
+	Sum          string `json:"sum"`
+	Currency     string `json:"currency"`
+	Counterparty string `json:"counterparty"`
+	Description  string `json:"description"`
+	Venue        string `json:"venue"`
+	Category     string `json:"category"`
+	Shopper      string `json:"shopper"`
+	Purchase     string `json:"purchase,omitempty"`
+	Created      string `json:"created"`
+}
+
+// ----
+// Venue rules
+
+func GetVenueRules() (*[]VenueRule, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := db.Query(ctx, "FOR r IN venue_rules SORT r.pattern RETURN r", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	res := make([]VenueRule, 0)
+	for {
+		var r VenueRule
+		_, err := c.ReadDocument(ctx, &r)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		res = append(res, r)
+	}
+
+	return &res, nil
+}
+
+/*
+AddVenueRule stores a new venue rule and assigns its venue to all pending candidates which do not have one yet.
+*/
+func AddVenueRule(rule VenueRule) (*VenueRule, error) {
+	col, err := GetCollection(COLLECTION_VENUE_RULES)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate uuid: %s", err)
+	}
+	rule.Key = key.String()
+	rule.Pattern = strings.TrimSpace(rule.Pattern)
+
+	if rule.Pattern == "" {
+		return nil, fmt.Errorf("Missing venue rule pattern")
+	}
+	_, err = GetVenue(rule.Venue)
+	if err != nil {
+		return nil, fmt.Errorf("Unknown venue '%s'", rule.Venue)
+	}
+
+	_, err = col.CreateDocument(ctx, rule)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := GetDb()
+	if err != nil {
+		return &rule, err
+	}
+	c, err := db.Query(
+		ctx,
+		`FOR c IN import_candidates
+		FILTER c.status == @pending AND c.venue == "" AND CONTAINS(LOWER(c.counterparty), LOWER(@pattern))
+		UPDATE c WITH { venue: @venue } IN import_candidates`,
+		map[string]interface{}{"pending": CANDIDATE_STATUS_PENDING, "pattern": rule.Pattern, "venue": rule.Venue},
+	)
+	if err != nil {
+		return &rule, fmt.Errorf("Failed to apply venue rule to pending candidates: %s", err)
+	}
+	c.Close()
+
+	return &rule, nil
+}
+
+func DeleteVenueRule(key string) error {
+	col, err := GetCollection(COLLECTION_VENUE_RULES)
+	if err != nil {
+		return err
+	}
+
+	_, err = col.RemoveDocument(ctx, key)
+	return err
+}
+
+/*
+MatchVenueRule returns the venue of the rule matching the counterparty, or an empty string if no rule matches.
+*/
+func MatchVenueRule(rules []VenueRule, counterparty string) string {
+	counterparty = strings.ToLower(counterparty)
+	venue := ""
+	length := 0
+
+	for _, r := range rules {
+		pattern := strings.ToLower(strings.TrimSpace(r.Pattern))
+		if pattern != "" && len(pattern) > length && strings.Contains(counterparty, pattern) {
+			venue = r.Venue
+			length = len(pattern)
+		}
+	}
+
+	return venue
+}
+
+// ----
+// Candidates
+
+/*
+GetImportCandidates returns the candidates with the provided status, or all if it is empty, ordered by date.
+*/
+func GetImportCandidates(status string) (*[]ImportCandidate, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := db.Query(
+		ctx,
+		`FOR c IN import_candidates
+		FILTER @status == "" OR c.status == @status
+		SORT c.date, c._key
+		RETURN c`,
+		map[string]interface{}{"status": status},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	res := make([]ImportCandidate, 0)
+	for {
+		var cand ImportCandidate
+		_, err := c.ReadDocument(ctx, &cand)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		res = append(res, cand)
+	}
+
+	return &res, nil
+}
+
+func GetImportCandidate(key string) (*ImportCandidate, error) {
+	col, err := GetCollection(COLLECTION_IMPORT_CANDIDATES)
+	if err != nil {
+		return nil, err
+	}
+
+	var c ImportCandidate
+	_, err = col.ReadDocument(ctx, key, &c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+/*
+AddImportCandidates stores the candidates, skipping those whose key exists already. Returns the number of candidates
+added.
+*/
+func AddImportCandidates(candidates []ImportCandidate) (int, error) {
+	if len(candidates) == 0 {
+		return 0, nil
+	}
+
+	db, err := GetDb()
+	if err != nil {
+		return 0, err
+	}
+
+	created := DateTimeToDb(time.Now().UTC())
+	for i := range candidates {
+		if candidates[i].Key == "" {
+			return 0, fmt.Errorf("Missing import candidate key")
+		}
+		candidates[i].Created = created
+	}
+
+	c, err := db.Query(
+		ctx,
+		`FOR c IN @candidates
+		INSERT c INTO import_candidates OPTIONS { ignoreErrors: true }
+		RETURN NEW._key`,
+		map[string]interface{}{"candidates": candidates},
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+
+	added := 0
+	for {
+		var key string
+		_, err := c.ReadDocument(ctx, &key)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return added, err
+		}
+
+		added++
+	}
+
+	return added, nil
+}
+
+/*
+UpdateImportCandidate changes date, sum, venue, category or shopper of a pending candidate.
+*/
+func UpdateImportCandidate(key string, data *map[string]interface{}) error {
+	for k := range *data {
+		if k != "date" && k != "sum" && k != "venue" && k != "category" && k != "shopper" {
+			return fmt.Errorf("Invalid import candidate attribute '%s'", k)
+		}
+	}
+
+	_, err := updatePendingCandidate(key, *data)
+	return err
+}
+
+/*
+ConfirmImportCandidate turns a pending candidate into a purchase and returns it. The candidate must have a venue,
+category and shopper assigned.
+*/
+func ConfirmImportCandidate(key string) (*Purchase, error) {
+	// Marking the candidate first makes sure it is only confirmed once
+	c, err := updatePendingCandidate(key, map[string]interface{}{"status": CANDIDATE_STATUS_CONFIRMED})
+	if err != nil {
+		return nil, err
+	}
+
+	p := Purchase{Date: c.Date, Sum: c.Sum, Venue: c.Venue, Category: c.Category, Shopper: c.Shopper}
+	p.Key, err = AddPurchase(p)
+	if p.Key == "" {
+		// The purchase has not been stored, so the candidate goes back to the queue
+		updateImportCandidate(key, map[string]interface{}{"status": CANDIDATE_STATUS_PENDING})
+		return nil, err
+	}
+
+	// The purchase exists even if updating the aggregates or the inventory failed
+	updateErr := updateImportCandidate(key, map[string]interface{}{"purchase": p.Key})
+	if err == nil {
+		err = updateErr
+	}
+
+	purchase, getErr := GetPurchase(p.Key)
+	if getErr != nil {
+		return &p, err
+	}
+	return purchase, err
+}
+
+func DiscardImportCandidate(key string) error {
+	_, err := updatePendingCandidate(key, map[string]interface{}{"status": CANDIDATE_STATUS_DISCARDED})
+	return err
+}
+
+/*
+Updates the candidate only if it is still pending and returns it as it was before the update.
+*/
+func updatePendingCandidate(key string, values map[string]interface{}) (*ImportCandidate, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := db.Query(
+		ctx,
+		`FOR c IN import_candidates
+		FILTER c._key == @key AND c.status == @pending
+		UPDATE c WITH @values IN import_candidates
+		RETURN OLD`,
+		map[string]interface{}{"key": key, "pending": CANDIDATE_STATUS_PENDING, "values": values},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var old ImportCandidate
+	_, err = c.ReadDocument(ctx, &old)
+	if arango.IsNoMoreDocuments(err) {
+		return nil, fmt.Errorf("No pending import candidate '%s'", key)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &old, nil
+}
+
+func updateImportCandidate(key string, values map[string]interface{}) error {
+	col, err := GetCollection(COLLECTION_IMPORT_CANDIDATES)
+	if err != nil {
+		return err
+	}
+
+	_, err = col.UpdateDocument(ctx, key, values)
+	return err
+}
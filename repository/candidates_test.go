@@ -0,0 +1,27 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import "testing"
+
+func TestMatchVenueRule(t *testing.T) {
+	rules := []VenueRule{
+		{Pattern: "market", Venue: "v1"},
+		{Pattern: "Super Market", Venue: "v2"},
+		{Pattern: " ", Venue: "v3"},
+	}
+
+	cases := map[string]string{
+		"FARMERS MARKET":        "v1",
+		"SUPER MARKET NORTH 12": "v2",
+		"Bakery":                "",
+	}
+	for counterparty, expected := range cases {
+		if v := MatchVenueRule(rules, counterparty); v != expected {
+			t.Errorf("MatchVenueRule returns '%s' instead of expected '%s' for '%s'", v, expected, counterparty)
+		}
+	}
+}
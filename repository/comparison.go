@@ -0,0 +1,100 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"math"
+)
+
+type ComparisonValues struct {
+	A       CountSumHolder `json:"a"`
+	B       CountSumHolder `json:"b"`
+	Delta   float64        `json:"delta"`
+	Percent *float64       `json:"percent"`
+}
+
+type ComparisonEntry struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+	ComparisonValues
+}
+
+type Comparison struct {
+	A          DateRange         `json:"a"`
+	B          DateRange         `json:"b"`
+	Overall    ComparisonValues  `json:"overall"`
+	Categories []ComparisonEntry `json:"categories"`
+	Venues     []ComparisonEntry `json:"venues"`
+}
+
+/*
+GetComparisonStatistics compares the spending in period a with the spending in the reference period b, both overall and
+per category and venue. Deltas are calculated as a - b, the percentage change relative to b. If b has no spending, the
+percentage change is nil.
+*/
+func GetComparisonStatistics(a DateRange, b DateRange) (*Comparison, error) {
+	res := Comparison{A: a, B: b}
+
+	for _, by := range []string{"category", "venue"} {
+		ba, err := GetBreakdownStatistics(by, a.FromDb(), a.ToDb())
+		if err != nil {
+			return nil, err
+		}
+		bb, err := GetBreakdownStatistics(by, b.FromDb(), b.ToDb())
+		if err != nil {
+			return nil, err
+		}
+
+		entries := compareBreakdowns(ba, bb)
+		if by == "category" {
+			res.Overall = compareValues(
+				CountSumHolder{Count: ba.Count, Sum: ba.Sum},
+				CountSumHolder{Count: bb.Count, Sum: bb.Sum},
+			)
+			res.Categories = entries
+		} else {
+			res.Venues = entries
+		}
+	}
+
+	return &res, nil
+}
+
+func compareBreakdowns(a *Breakdown, b *Breakdown) []ComparisonEntry {
+	entries := make([]ComparisonEntry, 0)
+	index := make(map[string]int)
+
+	for _, e := range a.Entries {
+		index[e.Key] = len(entries)
+		entries = append(entries, ComparisonEntry{Key: e.Key, Name: e.Name})
+		entries[index[e.Key]].A = CountSumHolder{Count: e.Count, Sum: e.Sum}
+	}
+	for _, e := range b.Entries {
+		i, ok := index[e.Key]
+		if !ok {
+			i = len(entries)
+			index[e.Key] = i
+			entries = append(entries, ComparisonEntry{Key: e.Key, Name: e.Name})
+		}
+		entries[i].B = CountSumHolder{Count: e.Count, Sum: e.Sum}
+	}
+
+	for i := range entries {
+		entries[i].ComparisonValues = compareValues(entries[i].A, entries[i].B)
+	}
+
+	return entries
+}
+
+func compareValues(a CountSumHolder, b CountSumHolder) ComparisonValues {
+	res := ComparisonValues{A: a, B: b, Delta: roundSum(a.Sum - b.Sum)}
+	if b.Sum != 0 {
+		percent := math.Round((a.Sum-b.Sum)/b.Sum*10000) / 100
+		res.Percent = &percent
+	}
+
+	return res
+}
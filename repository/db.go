@@ -8,7 +8,6 @@ package repository
 import (
 	"context"
 	"fmt"
-	"strconv"
 	"strings"
 	"time"
 
@@ -71,59 +70,95 @@ func GetCollection(name string) (arango.Collection, error) {
 	return col, nil
 }
 
-func BuildFilterString(filters map[string]interface{}, prefix string) string {
-	f := make([]string, 0)
-	for field, rawValue := range filters {
-		value := rawValue.(string)
-
-		// determine sign to use
-		frst := string(value[0])
-		sign := "=="
-		if frst == "!" {
-			sign = "!="
-			value = value[1:len(value)]
-		}
-		if frst == "<" || frst == ">" {
-			scnd := string(value[1])
-			if scnd == "=" {
-				sign = fmt.Sprintf("%s%s", frst, scnd)
-				value = strings.Trim(value[2:len(value)], " ")
-			} else {
-				sign = frst
-				value = strings.Trim(value[1:len(value)], " ")
-			}
-		}
-		filters[field] = value
+const (
+	FILTER_KIND_STRING = "string"
+	FILTER_KIND_NUMBER = "number"
+)
 
-		// determine value type
-		if value == "null" {
-			f = append(f, fmt.Sprintf("%s%s %s null", prefix, field, sign))
-			continue
-		}
-		vint, err := strconv.ParseInt(value, 0, 0)
-		if err == nil {
-			f = append(f, fmt.Sprintf("%s%s %s @%s", prefix, field, sign, field))
-			filters[field] = vint
-			continue
+/*
+A Filter compares a field with a value using one of the operators eq, ne, lt, le, gt, ge, in (value is a list) or like
+(string fields only, with % and _ as wildcards). A nil value compares with null and is only allowed for eq and ne.
+*/
+type Filter struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+}
+
+/*
+A FilterField maps the name of a field filters may use to its AQL expression and the kind of values it accepts.
+*/
+type FilterField struct {
+	Expression string
+	Kind       string
+}
+
+var filterOperators = map[string]string{
+	"eq":   "==",
+	"ne":   "!=",
+	"lt":   "<",
+	"le":   "<=",
+	"gt":   ">",
+	"ge":   ">=",
+	"in":   "IN",
+	"like": "LIKE",
+}
+
+/*
+BuildFilterString validates the filters against the provided fields and compiles them into a condition joined by AND.
+Field names and operators are only ever taken from the fields and operators known, values are added to data as bind
+parameters f0, f1, ... in the order of the filters. Returns an empty string if there are no filters.
+*/
+func BuildFilterString(filters []Filter, fields map[string]FilterField, data map[string]interface{}) (string, error) {
+	f := make([]string, 0, len(filters))
+	for i, filter := range filters {
+		field, ok := fields[filter.Field]
+		if !ok {
+			return "", fmt.Errorf("Unknown filter field '%s'", filter.Field)
 		}
-		vfloat, err := strconv.ParseFloat(value, 32)
-		if err == nil {
-			f = append(f, fmt.Sprintf("%s%s %s @%s", prefix, field, sign, field))
-			filters[field] = vfloat
-			continue
+		op, ok := filterOperators[filter.Op]
+		if !ok {
+			return "", fmt.Errorf("Unknown filter operator '%s'", filter.Op)
 		}
 
-		//----
-		// string value
-
-		// wildcard?
-		if strings.ContainsAny(value, "%_") {
-			sign = "LIKE"
+		switch {
+		case filter.Op == "in":
+			values, ok := filter.Value.([]interface{})
+			if !ok {
+				return "", fmt.Errorf("Filter on '%s' with operator 'in' requires a list", filter.Field)
+			}
+			for _, v := range values {
+				if !isFilterValue(v, field.Kind) {
+					return "", fmt.Errorf("Filter on '%s' requires %s values", filter.Field, field.Kind)
+				}
+			}
+		case filter.Op == "like" && field.Kind != FILTER_KIND_STRING:
+			return "", fmt.Errorf("Filter on '%s' can not use operator 'like'", filter.Field)
+		case filter.Value == nil:
+			if filter.Op != "eq" && filter.Op != "ne" {
+				return "", fmt.Errorf("Filter on '%s' can only compare null with 'eq' or 'ne'", filter.Field)
+			}
+		case !isFilterValue(filter.Value, field.Kind):
+			return "", fmt.Errorf("Filter on '%s' requires a %s value", filter.Field, field.Kind)
 		}
-		f = append(f, fmt.Sprintf("%s%s %s @%s", prefix, field, sign, field))
+
+		param := fmt.Sprintf("f%d", i)
+		data[param] = filter.Value
+		f = append(f, fmt.Sprintf("%s %s @%s", field.Expression, op, param))
 	}
 
-	return strings.Join(f, " AND ")
+	return strings.Join(f, " AND "), nil
+}
+
+func isFilterValue(v interface{}, kind string) bool {
+	switch v.(type) {
+	case string:
+		return kind == FILTER_KIND_STRING
+	case float64, int:
+		return kind == FILTER_KIND_NUMBER
+	default:
+		return false
+	}
 }
 
 func DateFromDb(v string) (time.Time, error) {
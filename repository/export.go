@@ -0,0 +1,188 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"fmt"
+
+	arango "github.com/arangodb/go-driver"
+)
+
+const (
+	EXPORT_BATCH_SIZE = 500
+)
+
+/*
+An ExportPurchase is a purchase together with the names of its category, venue and shopper.
+*/
+type ExportPurchase struct {
+	Purchase
+	CategoryName string `json:"category_name"`
+	VenueName    string `json:"venue_name"`
+	ShopperName  string `json:"shopper_name"`
+}
+
+// Purchase attributes which may be exported, in their default order.
+var exportFields = []string{"_key", "date", "month", "year", "category", "venue", "shopper", "sum", "items"}
+
+func IsExportField(name string) bool {
+	for _, f := range exportFields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Returns all purchase attributes which may be exported.
+*/
+func ExportFields() []string {
+	res := make([]string, len(exportFields))
+	copy(res, exportFields)
+	return res
+}
+
+// Selects the purchases of a year, or of all years if it is 0, dated between from and to
+const qryExportFilter = `FILTER @year == 0 OR p.year == @year
+		FILTER (@from == "" OR p.date >= @from) AND (@to == "" OR p.date <= @to)`
+
+/*
+GetPurchaseYears returns all years with at least one purchase in ascending order, counting only the purchases which
+StreamPurchases exports with the same year, from and to.
+*/
+func GetPurchaseYears(year int, from string, to string) ([]int, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	qry := `FOR p IN purchases
+		` + qryExportFilter + `
+		COLLECT year = TO_NUMBER(p.year)
+		SORT year
+		RETURN year`
+
+	c, err := db.Query(ctx, qry, map[string]interface{}{"year": year, "from": from, "to": to})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	res := make([]int, 0)
+	for {
+		var year int
+		_, err := c.ReadDocument(ctx, &year)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		res = append(res, year)
+	}
+
+	return res, nil
+}
+
+/*
+StreamPurchases reads the purchases of the provided year, or of all years if it is 0, dated between from and to (both
+inclusive, empty values do not limit the range) ordered by date and passes them to fn one by one. Only the given fields
+are included. The cursor is read batch by batch, so the purchases are never held in memory all at once. Streaming stops
+at the first error returned by fn.
+*/
+func StreamPurchases(year int, from string, to string, fields []string, fn func(map[string]interface{}) error) error {
+	for _, f := range fields {
+		if !IsExportField(f) {
+			return fmt.Errorf("Invalid export field '%s'", f)
+		}
+	}
+
+	db, err := GetDb()
+	if err != nil {
+		return err
+	}
+
+	qry := `FOR p IN purchases
+		` + qryExportFilter + `
+		SORT p.date, p._key
+		RETURN KEEP(p, @fields)`
+
+	data := map[string]interface{}{"year": year, "from": from, "to": to, "fields": fields}
+	qctx := arango.WithQueryStream(arango.WithQueryBatchSize(ctx, EXPORT_BATCH_SIZE), true)
+	c, err := db.Query(qctx, qry, data)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	for {
+		var p map[string]interface{}
+		_, err := c.ReadDocument(ctx, &p)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		err = fn(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+StreamPurchasesWithNames reads the purchases dated between from and to (both inclusive, empty values do not limit the
+range) ordered by date and passes them to fn one by one, with the names of their category, venue and shopper resolved.
+Like StreamPurchases, the cursor is read batch by batch and streaming stops at the first error returned by fn.
+*/
+func StreamPurchasesWithNames(from string, to string, fn func(ExportPurchase) error) error {
+	db, err := GetDb()
+	if err != nil {
+		return err
+	}
+
+	qry := `FOR p IN purchases
+		FILTER @from == "" OR p.date >= @from
+		FILTER @to == "" OR p.date <= @to
+		SORT p.date, p._key
+		RETURN MERGE(p, {
+			category_name: DOCUMENT("categories", p.category).name,
+			venue_name: DOCUMENT("venues", p.venue).name,
+			shopper_name: DOCUMENT("shoppers", p.shopper).name
+		})`
+
+	data := map[string]interface{}{"from": from, "to": to}
+	qctx := arango.WithQueryStream(arango.WithQueryBatchSize(ctx, EXPORT_BATCH_SIZE), true)
+	c, err := db.Query(qctx, qry, data)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	for {
+		var p ExportPurchase
+		_, err := c.ReadDocument(ctx, &p)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		err = fn(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,340 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	arango "github.com/arangodb/go-driver"
+)
+
+const (
+	FORECAST_HISTORY_PERIODS   = 12
+	FORECAST_RECURRING_PERIODS = 4
+	FORECAST_RECURRING_MINIMUM = 3
+
+	FORECAST_METHOD_HISTORY = "history"
+	FORECAST_METHOD_LINEAR  = "linear"
+)
+
+type ForecastEntry struct {
+	Category  string  `json:"category"`
+	Name      string  `json:"name"`
+	Spent     float64 `json:"spent"`
+	Forecast  float64 `json:"forecast"`
+	Low       float64 `json:"low"`
+	High      float64 `json:"high"`
+	Recurring float64 `json:"recurring"`
+	Method    string  `json:"method"`
+}
+
+/*
+A Forecast of the spending at the end of a period. Month and year are those the period starts in, for periods other
+than calendar months DaysInMonth is the length of the period.
+*/
+type Forecast struct {
+	Period      DateRange       `json:"period"`
+	Month       int             `json:"month"`
+	Year        int             `json:"year"`
+	ElapsedDays int             `json:"elapsed_days"`
+	DaysInMonth int             `json:"days_in_month"`
+	Total       ForecastEntry   `json:"total"`
+	Categories  []ForecastEntry `json:"categories"`
+}
+
+/*
+A purchase used for forecasting. Period is the index of the period it belongs to, 0 for the forecasted period and
+counting up into the past, Day the day within that period starting at 1.
+*/
+type forecastPurchase struct {
+	Date     string  `json:"date"`
+	Period   int     `json:"-"`
+	Day      int     `json:"-"`
+	Category string  `json:"category"`
+	Venue    string  `json:"venue"`
+	Sum      float64 `json:"sum"`
+}
+
+/*
+GetForecast projects the spending per category at the end of the provided month. See GetForecastForRange.
+*/
+func GetForecast(month int, year int) (*Forecast, error) {
+	return GetForecastForRange(monthRange(month, year))
+}
+
+/*
+GetForecastForRange projects the spending per category at the end of the provided period. The projection adds the
+spending in the remainder of the period observed in the previous periods to what has been spent so far, plus recurring
+purchases (same venue, category and sum in most of the recent periods) which have not been recorded yet. The confidence
+range spans one standard deviation of the historical remainders. Without any history, spending is extrapolated
+linearly. Previous periods are determined by PreviousPeriod.
+*/
+func GetForecastForRange(r DateRange) (*Forecast, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	periods := []DateRange{r}
+	for i := 0; i < FORECAST_HISTORY_PERIODS; i++ {
+		previous, err := PreviousPeriod(periods[i])
+		if err != nil {
+			return nil, err
+		}
+		periods = append(periods, *previous)
+	}
+
+	// ----
+	// Query database
+
+	qry := `FOR p IN purchases
+		FILTER p.date >= @from AND p.date <= @to
+		RETURN {
+			date: p.date,
+			category: p.category,
+			venue: p.venue,
+			sum: TO_NUMBER(p.sum)
+		}`
+
+	data := map[string]interface{}{"from": periods[len(periods)-1].FromDb(), "to": r.ToDb()}
+	c, err := db.Query(ctx, qry, data)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	purchases := make([]forecastPurchase, 0)
+	for {
+		var p forecastPurchase
+		_, err := c.ReadDocument(ctx, &p)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		date, err := DateFromDb(p.Date)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid purchase date '%s': %s", p.Date, err)
+		}
+		for i, period := range periods {
+			if !date.Before(period.From) && !date.After(period.To) {
+				p.Period = i
+				p.Day = int(date.Sub(period.From).Hours()/24) + 1
+				purchases = append(purchases, p)
+				break
+			}
+		}
+	}
+
+	// ----
+	// Build forecast
+
+	names := make(map[string]string)
+	categories, err := GetCategories()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range *categories {
+		names[c.Key] = c.Name
+	}
+
+	return buildForecast(purchases, names, periods, elapsedDays(r, Today())), nil
+}
+
+/*
+Returns the number of days of the given period which have passed, including the current day.
+*/
+func elapsedDays(r DateRange, now time.Time) int {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	if today.Before(r.From) {
+		return 0
+	} else if today.After(r.To) {
+		return r.Days()
+	}
+	return int(today.Sub(r.From).Hours()/24) + 1
+}
+
+/*
+Builds the forecast for the first of the periods, the others are the previous periods in descending order.
+*/
+func buildForecast(purchases []forecastPurchase, names map[string]string, periods []DateRange, elapsed int) *Forecast {
+	res := Forecast{
+		Period:      periods[0],
+		Month:       int(periods[0].From.Month()),
+		Year:        periods[0].From.Year(),
+		ElapsedDays: elapsed,
+		DaysInMonth: periods[0].Days(),
+		Total:       ForecastEntry{Name: "Total"},
+		Categories:  make([]ForecastEntry, 0),
+	}
+	fraction := float64(elapsed) / float64(res.DaysInMonth)
+
+	current := make([]forecastPurchase, 0)
+	history := make([]forecastPurchase, 0)
+	for _, p := range purchases {
+		if p.Period == 0 {
+			current = append(current, p)
+		} else {
+			history = append(history, p)
+		}
+	}
+
+	recurring := findRecurringPurchases(history)
+
+	// ----
+	// Collect spending so far and historical remainders per category
+
+	spent := make(map[string]float64)
+	for _, p := range current {
+		spent[p.Category] += p.Sum
+	}
+
+	// remainders[category][period] holds the spending after the cutoff day in a past period
+	remainders := make(map[string]map[int]float64)
+	observed := make(map[int]bool)
+	for _, p := range history {
+		observed[p.Period] = true
+		if remainders[p.Category] == nil {
+			remainders[p.Category] = make(map[int]float64)
+		}
+
+		cutoff := int(math.Round(fraction * float64(periods[p.Period].Days())))
+		if p.Day > cutoff && !recurring[recurringSignature(p)] {
+			remainders[p.Category][p.Period] += p.Sum
+		}
+	}
+
+	// ----
+	// Pending recurring purchases
+
+	pending := make(map[string]float64)
+	for signature, p := range recurringSamples(history, recurring) {
+		found := false
+		for _, c := range current {
+			if recurringSignature(c) == signature {
+				found = true
+				break
+			}
+		}
+		if !found && p.Day > elapsed {
+			pending[p.Category] += p.Sum
+		}
+	}
+
+	// ----
+	// Project every category
+
+	keys := make(map[string]bool)
+	for k := range spent {
+		keys[k] = true
+	}
+	for k := range remainders {
+		keys[k] = true
+	}
+	for k := range pending {
+		keys[k] = true
+	}
+
+	for category := range keys {
+		e := ForecastEntry{Category: category, Name: names[category], Spent: spent[category], Recurring: pending[category]}
+
+		if len(observed) > 0 && elapsed < res.DaysInMonth {
+			values := make([]float64, 0, len(observed))
+			for period := range observed {
+				values = append(values, remainders[category][period])
+			}
+			mean, stddev := meanStdDev(values)
+
+			e.Method = FORECAST_METHOD_HISTORY
+			e.Forecast = e.Spent + mean + e.Recurring
+			e.Low = e.Spent + math.Max(0, mean-stddev) + e.Recurring
+			e.High = e.Spent + mean + stddev + e.Recurring
+		} else {
+			e.Method = FORECAST_METHOD_LINEAR
+			e.Forecast = e.Spent
+			if elapsed > 0 {
+				e.Forecast = e.Spent/float64(elapsed)*float64(res.DaysInMonth) + e.Recurring
+			}
+			e.Low = e.Spent + e.Recurring
+			e.High = math.Max(e.Forecast, e.Low)
+		}
+
+		res.Total.Spent += e.Spent
+		res.Total.Forecast += e.Forecast
+		res.Total.Low += e.Low
+		res.Total.High += e.High
+		res.Total.Recurring += e.Recurring
+
+		e.Spent = roundSum(e.Spent)
+		e.Forecast = roundSum(e.Forecast)
+		e.Low = roundSum(e.Low)
+		e.High = roundSum(e.High)
+		e.Recurring = roundSum(e.Recurring)
+		res.Categories = append(res.Categories, e)
+	}
+
+	res.Total.Spent = roundSum(res.Total.Spent)
+	res.Total.Forecast = roundSum(res.Total.Forecast)
+	res.Total.Low = roundSum(res.Total.Low)
+	res.Total.High = roundSum(res.Total.High)
+	res.Total.Recurring = roundSum(res.Total.Recurring)
+
+	sort.Slice(res.Categories, func(i, j int) bool { return res.Categories[i].Forecast > res.Categories[j].Forecast })
+	return &res
+}
+
+func recurringSignature(p forecastPurchase) string {
+	return fmt.Sprintf("%s/%s/%.2f", p.Venue, p.Category, p.Sum)
+}
+
+/*
+Returns the signatures of purchases found in at least FORECAST_RECURRING_MINIMUM of the FORECAST_RECURRING_PERIODS
+periods before the forecasted one.
+*/
+func findRecurringPurchases(history []forecastPurchase) map[string]bool {
+	seen := make(map[string]map[int]bool)
+	for _, p := range history {
+		if p.Period > FORECAST_RECURRING_PERIODS {
+			continue
+		}
+		signature := recurringSignature(p)
+		if seen[signature] == nil {
+			seen[signature] = make(map[int]bool)
+		}
+		seen[signature][p.Period] = true
+	}
+
+	res := make(map[string]bool)
+	for signature, periods := range seen {
+		if len(periods) >= FORECAST_RECURRING_MINIMUM {
+			res[signature] = true
+		}
+	}
+	return res
+}
+
+/*
+Returns one sample purchase per recurring signature, using the latest day of the period it usually happens on.
+*/
+func recurringSamples(history []forecastPurchase, recurring map[string]bool) map[string]forecastPurchase {
+	res := make(map[string]forecastPurchase)
+	for _, p := range history {
+		signature := recurringSignature(p)
+		if !recurring[signature] {
+			continue
+		}
+		if sample, ok := res[signature]; !ok || p.Day > sample.Day {
+			res[signature] = p
+		}
+	}
+	return res
+}
@@ -0,0 +1,79 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildForecast(t *testing.T) {
+	purchases := []forecastPurchase{
+		// History: 100 early and 100 late in the month, plus rent on the 28th
+		{Period: 3, Day: 5, Category: "food", Venue: "1", Sum: 100},
+		{Period: 3, Day: 25, Category: "food", Venue: "1", Sum: 100},
+		{Period: 3, Day: 28, Category: "rent", Venue: "2", Sum: 500},
+		{Period: 2, Day: 5, Category: "food", Venue: "1", Sum: 90},
+		{Period: 2, Day: 20, Category: "food", Venue: "1", Sum: 120},
+		{Period: 2, Day: 28, Category: "rent", Venue: "2", Sum: 500},
+		{Period: 1, Day: 3, Category: "food", Venue: "1", Sum: 110},
+		{Period: 1, Day: 22, Category: "food", Venue: "1", Sum: 80},
+		{Period: 1, Day: 28, Category: "rent", Venue: "2", Sum: 500},
+		// Current month
+		{Period: 0, Day: 4, Category: "food", Venue: "1", Sum: 95},
+	}
+
+	periods := []DateRange{monthRange(4, 2026), monthRange(3, 2026), monthRange(2, 2026), monthRange(1, 2026)}
+	f := buildForecast(purchases, map[string]string{"food": "Food"}, periods, 15)
+	if len(f.Categories) != 2 {
+		t.Fatalf("Returns %d categories instead of expected 2", len(f.Categories))
+	}
+
+	rent := f.Categories[0]
+	if rent.Category != "rent" || rent.Recurring != 500 || rent.Forecast != 500 {
+		t.Errorf("Recurring rent returns %+v", rent)
+	}
+
+	food := f.Categories[1]
+	if food.Method != FORECAST_METHOD_HISTORY || food.Spent != 95 || food.Forecast != 195 {
+		t.Errorf("Food returns %+v", food)
+	}
+	if food.Low > food.Forecast || food.High < food.Forecast {
+		t.Errorf("Food forecast %f is outside of range %f..%f", food.Forecast, food.Low, food.High)
+	}
+	if f.Total.Forecast != 695 {
+		t.Errorf("Total forecast is %f instead of expected 695", f.Total.Forecast)
+	}
+}
+
+func TestBuildForecastWithoutHistory(t *testing.T) {
+	purchases := []forecastPurchase{
+		{Period: 0, Day: 2, Category: "food", Venue: "1", Sum: 30},
+	}
+
+	f := buildForecast(purchases, nil, []DateRange{monthRange(4, 2026)}, 10)
+	if f.Categories[0].Method != FORECAST_METHOD_LINEAR || f.Categories[0].Forecast != 90 {
+		t.Errorf("Linear forecast returns %+v", f.Categories[0])
+	}
+}
+
+func TestElapsedDays(t *testing.T) {
+	r := DateRange{From: time.Date(2026, 3, 25, 0, 0, 0, 0, time.UTC), To: time.Date(2026, 4, 24, 0, 0, 0, 0, time.UTC)}
+	cases := []struct {
+		now      time.Time
+		expected int
+	}{
+		{time.Date(2026, 3, 20, 12, 0, 0, 0, time.UTC), 0},
+		{time.Date(2026, 3, 25, 12, 0, 0, 0, time.UTC), 1},
+		{time.Date(2026, 4, 2, 12, 0, 0, 0, time.UTC), 9},
+		{time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC), 31},
+	}
+	for _, c := range cases {
+		if days := elapsedDays(r, c.now); days != c.expected {
+			t.Errorf("elapsedDays returns %d instead of expected %d for %s", days, c.expected, c.now)
+		}
+	}
+}
@@ -0,0 +1,459 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	arango "github.com/arangodb/go-driver"
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+const (
+	COLLECTION_GOALS         = "savings_goals"
+	COLLECTION_CONTRIBUTIONS = "savings_contributions"
+
+	// Average length of a month in days
+	DAYS_PER_MONTH = 30.436875
+
+	GOAL_CATEGORY_MONTHS = 6
+)
+
+/*
+A SavingsGoal is an amount to be saved until the deadline. The linked categories are those spending is cut back on to
+reach the goal.
+*/
+type SavingsGoal struct {
+	Key        string   `json:"_key"`
+	Name       string   `json:"name"`
+	Target     string   `json:"target"`
+	Deadline   string   `json:"deadline"`
+	Categories []string `json:"categories"`
+	Created    string   `json:"created"`
+}
+
+type SavingsContribution struct {
+	Key    string `json:"_key"`
+	Goal   string `json:"goal"`
+	Amount string `json:"amount"`
+	Date   string `json:"date"`
+	Note   string `json:"note"`
+}
+
+/*
+Compares the spending of a linked category in the current month with its average of the previous months.
+*/
+type GoalCategorySpending struct {
+	Category string  `json:"category"`
+	Name     string  `json:"name"`
+	Current  float64 `json:"current"`
+	Average  float64 `json:"average"`
+	Saved    float64 `json:"saved"`
+}
+
+type GoalProgress struct {
+	Goal                SavingsGoal            `json:"goal"`
+	Saved               float64                `json:"saved"`
+	Remaining           float64                `json:"remaining"`
+	Percent             float64                `json:"percent"`
+	MonthsLeft          float64                `json:"months_left"`
+	RequiredMonthlyRate float64                `json:"required_monthly_rate"`
+	AverageMonthlyRate  float64                `json:"average_monthly_rate"`
+	ProjectedCompletion *string                `json:"projected_completion"`
+	OnTrack             bool                   `json:"on_track"`
+	Categories          []GoalCategorySpending `json:"categories"`
+}
+
+func GetSavingsGoals() (*[]SavingsGoal, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := db.Query(ctx, "FOR g IN savings_goals SORT g.deadline RETURN g", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	res := make([]SavingsGoal, 0)
+	for {
+		var g SavingsGoal
+		_, err := c.ReadDocument(ctx, &g)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		res = append(res, g)
+	}
+
+	return &res, nil
+}
+
+func GetSavingsGoal(key string) (*SavingsGoal, error) {
+	col, err := GetCollection(COLLECTION_GOALS)
+	if err != nil {
+		return nil, err
+	}
+
+	var g SavingsGoal
+	_, err = col.ReadDocument(ctx, key, &g)
+	if err != nil {
+		return nil, err
+	}
+
+	return &g, nil
+}
+
+func AddSavingsGoal(goal SavingsGoal) (*SavingsGoal, error) {
+	col, err := GetCollection(COLLECTION_GOALS)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate uuid: %s", err)
+	}
+	goal.Key = key.String()
+	goal.Created = DateToDb(Today())
+	if goal.Categories == nil {
+		goal.Categories = make([]string, 0)
+	}
+
+	err = validateSavingsGoal(&goal)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = col.CreateDocument(ctx, goal)
+	if err != nil {
+		return nil, err
+	}
+
+	return &goal, nil
+}
+
+func UpdateSavingsGoal(key string, data *map[string]interface{}) error {
+	col, err := GetCollection(COLLECTION_GOALS)
+	if err != nil {
+		return err
+	}
+
+	_, err = col.UpdateDocument(ctx, key, data)
+	return err
+}
+
+/*
+DeleteSavingsGoal removes the goal and all of its contributions.
+*/
+func DeleteSavingsGoal(key string) error {
+	db, err := GetDb()
+	if err != nil {
+		return err
+	}
+
+	c, err := db.Query(
+		ctx,
+		"FOR c IN savings_contributions FILTER c.goal == @goal REMOVE c IN savings_contributions",
+		map[string]interface{}{"goal": key},
+	)
+	if err != nil {
+		return err
+	}
+	c.Close()
+
+	col, err := db.Collection(ctx, COLLECTION_GOALS)
+	if err != nil {
+		return err
+	}
+
+	_, err = col.RemoveDocument(ctx, key)
+	return err
+}
+
+func GetSavingsContributions(goal string) (*[]SavingsContribution, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := db.Query(
+		ctx,
+		"FOR c IN savings_contributions FILTER c.goal == @goal SORT c.date DESC RETURN c",
+		map[string]interface{}{"goal": goal},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	res := make([]SavingsContribution, 0)
+	for {
+		var sc SavingsContribution
+		_, err := c.ReadDocument(ctx, &sc)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		res = append(res, sc)
+	}
+
+	return &res, nil
+}
+
+func AddSavingsContribution(contribution SavingsContribution) (*SavingsContribution, error) {
+	col, err := GetCollection(COLLECTION_CONTRIBUTIONS)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = GetSavingsGoal(contribution.Goal)
+	if err != nil {
+		return nil, fmt.Errorf("Savings goal '%s' not found", contribution.Goal)
+	}
+
+	key, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate uuid: %s", err)
+	}
+	contribution.Key = key.String()
+
+	err = validateSavingsContribution(&contribution)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = col.CreateDocument(ctx, contribution)
+	if err != nil {
+		return nil, err
+	}
+
+	return &contribution, nil
+}
+
+/*
+DeleteSavingsContribution removes a contribution, which must belong to the given goal.
+*/
+func DeleteSavingsContribution(goal string, key string) error {
+	col, err := GetCollection(COLLECTION_CONTRIBUTIONS)
+	if err != nil {
+		return err
+	}
+
+	var sc SavingsContribution
+	_, err = col.ReadDocument(ctx, key, &sc)
+	if err != nil {
+		return err
+	}
+	if sc.Goal != goal {
+		return fmt.Errorf("Contribution '%s' does not belong to savings goal '%s'", key, goal)
+	}
+
+	_, err = col.RemoveDocument(ctx, key)
+	return err
+}
+
+/*
+GetSavingsGoalProgress reports how much has been saved towards the goal, the monthly rate required to reach it by the
+deadline and, based on the average monthly rate so far, when it will be reached. For every linked category, the
+spending of the current month is compared to its average of the previous months.
+*/
+func GetSavingsGoalProgress(key string) (*GoalProgress, error) {
+	goal, err := GetSavingsGoal(key)
+	if err != nil {
+		return nil, err
+	}
+
+	contributions, err := GetSavingsContributions(key)
+	if err != nil {
+		return nil, err
+	}
+
+	now := Today()
+	progress := buildGoalProgress(*goal, *contributions, now)
+
+	categories, err := getGoalCategorySpending(goal.Categories, now)
+	if err != nil {
+		return nil, err
+	}
+	progress.Categories = categories
+
+	return progress, nil
+}
+
+func buildGoalProgress(goal SavingsGoal, contributions []SavingsContribution, now time.Time) *GoalProgress {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	res := GoalProgress{Goal: goal, Categories: make([]GoalCategorySpending, 0)}
+
+	target, _ := strconv.ParseFloat(goal.Target, 64)
+
+	// Saving starts with the goal's creation or the first contribution, whichever comes first
+	start, err := DateFromDb(goal.Created)
+	if err != nil {
+		start = today
+	}
+	for _, c := range contributions {
+		amount, _ := strconv.ParseFloat(c.Amount, 64)
+		res.Saved += amount
+		if d, err := DateFromDb(c.Date); err == nil && d.Before(start) {
+			start = d
+		}
+	}
+
+	res.Saved = roundSum(res.Saved)
+	res.Remaining = roundSum(math.Max(0, target-res.Saved))
+	if target > 0 {
+		res.Percent = math.Min(100, math.Round(res.Saved/target*10000)/100)
+	}
+
+	// ----
+	// Rates
+
+	deadline, deadlineErr := DateFromDb(goal.Deadline)
+	if deadlineErr == nil && deadline.After(today) {
+		res.MonthsLeft = math.Round(deadline.Sub(today).Hours()/24/DAYS_PER_MONTH*100) / 100
+	}
+	if res.MonthsLeft >= 1 {
+		res.RequiredMonthlyRate = roundSum(res.Remaining / res.MonthsLeft)
+	} else {
+		res.RequiredMonthlyRate = res.Remaining
+	}
+
+	monthsSaving := math.Max(1, today.Sub(start).Hours()/24/DAYS_PER_MONTH)
+	res.AverageMonthlyRate = roundSum(res.Saved / monthsSaving)
+
+	if res.Remaining == 0 {
+		completion := DateToDb(today)
+		res.ProjectedCompletion = &completion
+		res.OnTrack = true
+	} else if res.AverageMonthlyRate > 0 {
+		days := int(math.Ceil(res.Remaining / res.AverageMonthlyRate * DAYS_PER_MONTH))
+		completion := DateToDb(today.AddDate(0, 0, days))
+		res.ProjectedCompletion = &completion
+		res.OnTrack = deadlineErr == nil && !today.AddDate(0, 0, days).After(deadline)
+	}
+
+	return &res
+}
+
+func getGoalCategorySpending(categories []string, now time.Time) ([]GoalCategorySpending, error) {
+	res := make([]GoalCategorySpending, 0)
+	if len(categories) == 0 {
+		return res, nil
+	}
+
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	current := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	start := current.AddDate(0, -GOAL_CATEGORY_MONTHS, 0)
+
+	qry := `FOR category IN @categories
+		LET months = (
+			FOR a IN aggregates
+			FILTER a.category == category
+			FILTER (a.year > @startYear OR (a.year == @startYear AND a.month >= @startMonth))
+			FILTER (a.year < @year OR (a.year == @year AND a.month <= @month))
+			COLLECT year = a.year, month = a.month AGGREGATE sum = SUM(a.sum)
+			RETURN { current: year == @year AND month == @month, sum: sum }
+		)
+		RETURN {
+			category: category,
+			name: DOCUMENT("categories", category).name,
+			current: SUM(months[* FILTER CURRENT.current].sum),
+			previous: SUM(months[* FILTER !CURRENT.current].sum)
+		}`
+
+	data := map[string]interface{}{
+		"categories": categories,
+		"startYear":  start.Year(),
+		"startMonth": int(start.Month()),
+		"year":       current.Year(),
+		"month":      int(current.Month()),
+	}
+	c, err := db.Query(ctx, qry, data)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	for {
+		var s struct {
+			Category string  `json:"category"`
+			Name     string  `json:"name"`
+			Current  float64 `json:"current"`
+			Previous float64 `json:"previous"`
+		}
+		_, err := c.ReadDocument(ctx, &s)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		average := s.Previous / GOAL_CATEGORY_MONTHS
+		res = append(res, GoalCategorySpending{
+			Category: s.Category,
+			Name:     s.Name,
+			Current:  roundSum(s.Current),
+			Average:  roundSum(average),
+			Saved:    roundSum(average - s.Current),
+		})
+	}
+
+	return res, nil
+}
+
+func validateSavingsGoal(g *SavingsGoal) error {
+	if g.Key == "" {
+		return fmt.Errorf("Missing savings goal key")
+	}
+	if g.Name == "" {
+		return fmt.Errorf("Missing savings goal name")
+	}
+	target, err := strconv.ParseFloat(g.Target, 64)
+	if err != nil || target <= 0 {
+		return fmt.Errorf("Invalid savings goal target '%s'", g.Target)
+	}
+	_, err = DateFromDb(g.Deadline)
+	if err != nil {
+		return fmt.Errorf("Invalid savings goal deadline '%s'", g.Deadline)
+	}
+
+	return nil
+}
+
+func validateSavingsContribution(c *SavingsContribution) error {
+	if c.Key == "" {
+		return fmt.Errorf("Missing contribution key")
+	}
+	if c.Goal == "" {
+		return fmt.Errorf("Missing savings goal of contribution")
+	}
+	_, err := strconv.ParseFloat(c.Amount, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid contribution amount '%s'", c.Amount)
+	}
+	_, err = DateFromDb(c.Date)
+	if err != nil {
+		return fmt.Errorf("Invalid contribution date '%s'", c.Date)
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildGoalProgress(t *testing.T) {
+	goal := SavingsGoal{Key: "1", Name: "Bike", Target: "1200.00", Deadline: "2026-12-31", Created: "2026-01-01"}
+	contributions := []SavingsContribution{
+		{Goal: "1", Amount: "100.00", Date: "2026-01-15"},
+		{Goal: "1", Amount: "200.00", Date: "2026-02-15"},
+		{Goal: "1", Amount: "300.00", Date: "2026-03-15"},
+	}
+	now := time.Date(2026, 7, 2, 10, 0, 0, 0, time.UTC)
+
+	p := buildGoalProgress(goal, contributions, now)
+	if p.Saved != 600 || p.Remaining != 600 || p.Percent != 50 {
+		t.Errorf("Returns saved %f, remaining %f and percent %f", p.Saved, p.Remaining, p.Percent)
+	}
+	if p.MonthsLeft < 5.9 || p.MonthsLeft > 6.1 {
+		t.Errorf("Returns %f months left instead of about 6", p.MonthsLeft)
+	}
+	if p.RequiredMonthlyRate < 98 || p.RequiredMonthlyRate > 102 {
+		t.Errorf("Returns required monthly rate %f instead of about 100", p.RequiredMonthlyRate)
+	}
+	if p.AverageMonthlyRate < 98 || p.AverageMonthlyRate > 102 {
+		t.Errorf("Returns average monthly rate %f instead of about 100", p.AverageMonthlyRate)
+	}
+	if p.ProjectedCompletion == nil || *p.ProjectedCompletion < "2026-12-25" || *p.ProjectedCompletion > "2027-01-05" {
+		t.Errorf("Returns projected completion %v instead of about end of 2026", p.ProjectedCompletion)
+	}
+
+	// Reached goal
+	contributions = append(contributions, SavingsContribution{Goal: "1", Amount: "700.00", Date: "2026-07-01"})
+	p = buildGoalProgress(goal, contributions, now)
+	if p.Remaining != 0 || p.Percent != 100 || !p.OnTrack || p.RequiredMonthlyRate != 0 {
+		t.Errorf("Returns %+v for a reached goal", p)
+	}
+
+	// Without contributions there is no projection
+	p = buildGoalProgress(goal, nil, now)
+	if p.ProjectedCompletion != nil || p.OnTrack {
+		t.Errorf("Returns %+v without contributions", p)
+	}
+}
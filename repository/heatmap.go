@@ -0,0 +1,95 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"fmt"
+	"time"
+)
+
+type HeatmapDay struct {
+	Date    string  `json:"date"`
+	Week    int     `json:"week"`
+	Weekday int     `json:"weekday"`
+	Count   int     `json:"count"`
+	Sum     float64 `json:"sum"`
+}
+
+/*
+A Heatmap holds the spending of every date in a period, usually a year, like a contribution graph. Weeks start on monday
+and weekdays are numbered from 0 (monday) to 6 (sunday). Week 0 is the week containing the first date of the period, so
+the matrix rows contain zeros for the dates outside of it. Year is the year the period starts in.
+*/
+type Heatmap struct {
+	Period      DateRange    `json:"period"`
+	Year        int          `json:"year"`
+	Max         float64      `json:"max"`
+	Days        []HeatmapDay `json:"days"`
+	Matrix      [7][]float64 `json:"matrix"`
+	Weekdays    [7]float64   `json:"weekdays"`
+	DaysOfMonth [31]float64  `json:"days_of_month"`
+}
+
+/*
+GetHeatmapStatistics returns the spending per date of the provided year, restricted by the given filter.
+*/
+func GetHeatmapStatistics(year int, filter StatisticsFilter) (*Heatmap, error) {
+	from := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	return GetHeatmapStatisticsForRange(DateRange{Period: fmt.Sprintf("%04d", year), From: from, To: from.AddDate(1, 0, -1)}, filter)
+}
+
+/*
+GetHeatmapStatisticsForRange returns the spending per date of the provided period, restricted by the given filter.
+*/
+func GetHeatmapStatisticsForRange(r DateRange, filter StatisticsFilter) (*Heatmap, error) {
+	daily, err := getDailySums(r.From, r.To, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildHeatmap(daily, r), nil
+}
+
+func buildHeatmap(daily map[string]CountSumHolder, r DateRange) *Heatmap {
+	first := r.From
+	weekStart := bucketStart(first, GRANULARITY_WEEK)
+	last := r.To
+	weeks := int(last.Sub(weekStart).Hours()/24)/7 + 1
+
+	res := Heatmap{Period: r, Year: first.Year(), Days: make([]HeatmapDay, 0, r.Days())}
+	for i := range res.Matrix {
+		res.Matrix[i] = make([]float64, weeks)
+	}
+
+	for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+		date := DateToDb(d)
+		cs := daily[date]
+		day := HeatmapDay{
+			Date:    date,
+			Week:    int(d.Sub(weekStart).Hours()/24) / 7,
+			Weekday: (int(d.Weekday()) + 6) % 7,
+			Count:   cs.Count,
+			Sum:     roundSum(cs.Sum),
+		}
+
+		res.Matrix[day.Weekday][day.Week] = day.Sum
+		res.Weekdays[day.Weekday] += cs.Sum
+		res.DaysOfMonth[d.Day()-1] += cs.Sum
+		if day.Sum > res.Max {
+			res.Max = day.Sum
+		}
+		res.Days = append(res.Days, day)
+	}
+
+	for i := range res.Weekdays {
+		res.Weekdays[i] = roundSum(res.Weekdays[i])
+	}
+	for i := range res.DaysOfMonth {
+		res.DaysOfMonth[i] = roundSum(res.DaysOfMonth[i])
+	}
+
+	return &res
+}
@@ -0,0 +1,65 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildHeatmap(t *testing.T) {
+	daily := map[string]CountSumHolder{
+		"2026-01-01": {Count: 1, Sum: 10},
+		"2026-01-05": {Count: 2, Sum: 25.5},
+		"2026-12-31": {Count: 1, Sum: 5},
+		"2025-12-31": {Count: 1, Sum: 99},
+	}
+
+	year := DateRange{From: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC)}
+	h := buildHeatmap(daily, year)
+	if len(h.Days) != 365 {
+		t.Fatalf("Returns %d days instead of expected 365", len(h.Days))
+	}
+	if h.Max != 25.5 {
+		t.Errorf("Returns max %f instead of expected 25.5", h.Max)
+	}
+
+	// 2026-01-01 is a thursday in the first week, 2026-01-05 the monday of the second week
+	if d := h.Days[0]; d.Week != 0 || d.Weekday != 3 || d.Sum != 10 {
+		t.Errorf("Returns %+v for 2026-01-01", d)
+	}
+	if d := h.Days[4]; d.Week != 1 || d.Weekday != 0 || d.Count != 2 {
+		t.Errorf("Returns %+v for 2026-01-05", d)
+	}
+	if len(h.Matrix[0]) != 53 || h.Matrix[0][1] != 25.5 || h.Matrix[3][52] != 5 {
+		t.Errorf("Returns unexpected matrix %v", h.Matrix)
+	}
+	if h.Weekdays[3] != 15 || h.DaysOfMonth[0] != 10 || h.DaysOfMonth[30] != 5 {
+		t.Errorf("Returns weekdays %v and days of month %v", h.Weekdays, h.DaysOfMonth)
+	}
+}
+
+func TestBuildHeatmapForPeriod(t *testing.T) {
+	daily := map[string]CountSumHolder{
+		"2026-03-25": {Count: 1, Sum: 10},
+		"2026-04-24": {Count: 1, Sum: 20},
+		"2026-04-25": {Count: 1, Sum: 99},
+	}
+	r := DateRange{From: time.Date(2026, 3, 25, 0, 0, 0, 0, time.UTC), To: time.Date(2026, 4, 24, 0, 0, 0, 0, time.UTC)}
+
+	h := buildHeatmap(daily, r)
+	if len(h.Days) != 31 || h.Max != 20 {
+		t.Fatalf("Returns %d days with max %f instead of expected 31 days with max 20", len(h.Days), h.Max)
+	}
+
+	// 2026-03-25 is a wednesday in the first week, 2026-04-24 the friday of the fifth week
+	if d := h.Days[0]; d.Week != 0 || d.Weekday != 2 || d.Sum != 10 {
+		t.Errorf("Returns %+v for 2026-03-25", d)
+	}
+	if len(h.Matrix[0]) != 5 || h.Matrix[4][4] != 20 {
+		t.Errorf("Returns unexpected matrix %v", h.Matrix)
+	}
+}
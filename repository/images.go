@@ -0,0 +1,289 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	arango "github.com/arangodb/go-driver"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+
+	"github.com/mandrakey/shoptrac/config"
+)
+
+const (
+	COLLECTION_IMAGES = "images"
+
+	MAX_IMAGE_BYTES  = 10 * 1024 * 1024
+	MAX_IMAGE_PIXELS = 40 * 1000 * 1000
+	JPEG_QUALITY     = 90
+)
+
+var (
+	rxImageKey *regexp.Regexp = regexp.MustCompile("^[0-9a-f]{40}$")
+	rxDataUri  *regexp.Regexp = regexp.MustCompile("^data:[a-z/+.-]+;base64,")
+)
+
+type Image struct {
+	Key         string `json:"_key"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Size        int    `json:"size"`
+	Created     string `json:"created"`
+}
+
+func IsImageKey(key string) bool {
+	return rxImageKey.MatchString(key)
+}
+
+/*
+StoreImage validates the provided PNG, JPEG or WebP data, strips all metadata by re-encoding the pixels, generates a
+thumbnail and stores both blobs in the configured image directory. The returned key is derived from the stored content,
+so uploading the same image twice yields the same key. WebP images are stored as PNG, as there is no WebP encoder
+available.
+*/
+func StoreImage(data []byte) (*Image, error) {
+	if len(data) > MAX_IMAGE_BYTES {
+		return nil, fmt.Errorf("Image exceeds the maximum size of %d bytes", MAX_IMAGE_BYTES)
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Unsupported or invalid image data: %s", err)
+	}
+	if cfg.Width*cfg.Height > MAX_IMAGE_PIXELS {
+		return nil, fmt.Errorf("Image dimensions %dx%d are too large", cfg.Width, cfg.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode image: %s", err)
+	}
+
+	// ----
+	// Re-encode image and thumbnail
+
+	contentType := "image/png"
+	if format == "jpeg" {
+		contentType = "image/jpeg"
+	}
+
+	encoded, err := encodeImage(img, contentType)
+	if err != nil {
+		return nil, err
+	}
+	thumbnail, err := encodeImage(createThumbnail(img, config.GetAppConfig().ThumbnailSize), contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	res := Image{
+		Key:         fmt.Sprintf("%x", sha256.Sum256(encoded))[:40],
+		ContentType: contentType,
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+		Size:        len(encoded),
+		Created:     DateTimeToDb(time.Now().UTC()),
+	}
+
+	// ----
+	// Store blobs and metadata
+
+	dir := config.GetAppConfig().ImageDir
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create image directory: %s", err)
+	}
+
+	err = ioutil.WriteFile(imagePath(res.Key, false), encoded, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to write image: %s", err)
+	}
+	err = ioutil.WriteFile(imagePath(res.Key, true), thumbnail, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to write thumbnail: %s", err)
+	}
+
+	col, err := GetCollection(COLLECTION_IMAGES)
+	if err != nil {
+		return nil, err
+	}
+	exists, err := col.DocumentExists(ctx, res.Key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		_, err = col.CreateDocument(ctx, res)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &res, nil
+}
+
+/*
+StoreBase64Image decodes an image provided as base64 string, optionally in data URI form, and stores it using
+[StoreImage].
+*/
+func StoreBase64Image(value string) (*Image, error) {
+	value = rxDataUri.ReplaceAllString(strings.TrimSpace(value), "")
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode base64 image data: %s", err)
+	}
+
+	return StoreImage(data)
+}
+
+func GetImage(key string) (*Image, error) {
+	if !IsImageKey(key) {
+		return nil, fmt.Errorf("Invalid image key '%s'", key)
+	}
+
+	col, err := GetCollection(COLLECTION_IMAGES)
+	if err != nil {
+		return nil, err
+	}
+
+	var img Image
+	_, err = col.ReadDocument(ctx, key, &img)
+	if err != nil {
+		return nil, err
+	}
+
+	return &img, nil
+}
+
+/*
+ReadImageData returns the stored image or thumbnail blob for the image with the provided key.
+*/
+func ReadImageData(key string, thumbnail bool) ([]byte, error) {
+	if !IsImageKey(key) {
+		return nil, fmt.Errorf("Invalid image key '%s'", key)
+	}
+
+	return ioutil.ReadFile(imagePath(key, thumbnail))
+}
+
+func imagePath(key string, thumbnail bool) string {
+	name := key
+	if thumbnail {
+		name += "_thumb"
+	}
+	return filepath.Join(config.GetAppConfig().ImageDir, name)
+}
+
+func encodeImage(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: JPEG_QUALITY})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode image: %s", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func createThumbnail(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return img
+	}
+
+	if width > height {
+		height = height * size / width
+		width = size
+	} else {
+		width = width * size / height
+		height = size
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	thumb := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(thumb, thumb.Bounds(), img, bounds, draw.Over, nil)
+	return thumb
+}
+
+/*
+Moves base64 encoded images still embedded in documents of the provided collection to the image storage, replacing the
+embedded data with the new image key. Data which is no valid image is moved to the attribute 'invalid_image', so the
+image is removed but nothing is lost.
+*/
+func migrateEmbeddedImages(db arango.Database, collection string) error {
+	log := config.Logger()
+
+	col, err := db.Collection(ctx, collection)
+	if err != nil {
+		return err
+	}
+
+	c, err := db.Query(
+		ctx,
+		"FOR d IN @@collection FILTER d.image != null AND d.image != \"\" RETURN { _key: d._key, image: d.image }",
+		map[string]interface{}{"@collection": collection},
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to query images in '%s': %s", collection, err)
+	}
+	defer c.Close()
+
+	for {
+		var doc map[string]string
+		_, err = c.ReadDocument(ctx, &doc)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if IsImageKey(doc["image"]) {
+			continue
+		}
+
+		update := make(map[string]string)
+		img, err := StoreBase64Image(doc["image"])
+		if err != nil {
+			log.Warningf("Failed to move image of %s/%s to image storage, moving it to 'invalid_image': %s", collection, doc["_key"], err)
+			update["image"] = ""
+			update["invalid_image"] = doc["image"]
+		} else {
+			update["image"] = img.Key
+		}
+
+		_, err = col.UpdateDocument(ctx, doc["_key"], update)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
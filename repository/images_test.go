@@ -0,0 +1,37 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"image"
+	"testing"
+)
+
+func TestCreateThumbnail(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	thumb := createThumbnail(img, 128)
+	if thumb.Bounds().Dx() != 128 || thumb.Bounds().Dy() != 64 {
+		t.Errorf("400x200 returns %dx%d instead of expected 128x64", thumb.Bounds().Dx(), thumb.Bounds().Dy())
+	}
+
+	small := image.NewRGBA(image.Rect(0, 0, 50, 100))
+	thumb = createThumbnail(small, 128)
+	if thumb != small {
+		t.Error("Image smaller than the thumbnail size is scaled")
+	}
+}
+
+func TestIsImageKey(t *testing.T) {
+	if !IsImageKey("0123456789abcdef0123456789abcdef01234567") {
+		t.Error("Valid image key is not accepted")
+	}
+	if IsImageKey("../shoptrac.json") {
+		t.Error("Path is accepted as image key")
+	}
+	if IsImageKey("iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJ") {
+		t.Error("Base64 data is accepted as image key")
+	}
+}
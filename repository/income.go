@@ -0,0 +1,297 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	arango "github.com/arangodb/go-driver"
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+const (
+	COLLECTION_INCOME = "income"
+)
+
+/*
+An Income is money coming in, like a salary. Month and year are derived from the date.
+*/
+type Income struct {
+	Key       string `json:"_key"`
+	Source    string `json:"source"`
+	Amount    string `json:"amount"`
+	Date      string `json:"date"`
+	Month     int    `json:"month"`
+	Year      int    `json:"year"`
+	Recurring bool   `json:"recurring"`
+}
+
+type CashFlowMonth struct {
+	Period            string   `json:"period"`
+	Income            float64  `json:"income"`
+	Expenses          float64  `json:"expenses"`
+	Net               float64  `json:"net"`
+	SavingsRate       *float64 `json:"savings_rate"`
+	CumulativeSavings float64  `json:"cumulative_savings"`
+}
+
+type CashFlow struct {
+	From        string          `json:"from"`
+	To          string          `json:"to"`
+	Income      float64         `json:"income"`
+	Expenses    float64         `json:"expenses"`
+	Net         float64         `json:"net"`
+	SavingsRate *float64        `json:"savings_rate"`
+	Months      []CashFlowMonth `json:"months"`
+}
+
+/*
+GetIncome returns all income between from and to (both inclusive), latest first. Empty values do not limit the range.
+*/
+func GetIncome(from string, to string) (*[]Income, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := db.Query(
+		ctx,
+		`FOR i IN income
+		FILTER @from == "" OR i.date >= @from
+		FILTER @to == "" OR i.date <= @to
+		SORT i.date DESC
+		RETURN i`,
+		map[string]interface{}{"from": from, "to": to},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	res := make([]Income, 0)
+	for {
+		var i Income
+		_, err := c.ReadDocument(ctx, &i)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		res = append(res, i)
+	}
+
+	return &res, nil
+}
+
+func GetIncomeEntry(key string) (*Income, error) {
+	col, err := GetCollection(COLLECTION_INCOME)
+	if err != nil {
+		return nil, err
+	}
+
+	var i Income
+	_, err = col.ReadDocument(ctx, key, &i)
+	if err != nil {
+		return nil, err
+	}
+
+	return &i, nil
+}
+
+func AddIncome(income Income) (*Income, error) {
+	col, err := GetCollection(COLLECTION_INCOME)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate uuid: %s", err)
+	}
+	income.Key = key.String()
+
+	err = validateIncome(&income)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = col.CreateDocument(ctx, income)
+	if err != nil {
+		return nil, err
+	}
+
+	return &income, nil
+}
+
+/*
+UpdateIncome changes the provided values of an income. A changed date also updates month and year.
+*/
+func UpdateIncome(key string, data *map[string]interface{}) error {
+	col, err := GetCollection(COLLECTION_INCOME)
+	if err != nil {
+		return err
+	}
+
+	if date, ok := (*data)["date"].(string); ok {
+		d, err := DateFromDb(date)
+		if err != nil {
+			return fmt.Errorf("Invalid income date '%s'", date)
+		}
+		(*data)["month"] = int(d.Month())
+		(*data)["year"] = d.Year()
+	}
+
+	_, err = col.UpdateDocument(ctx, key, data)
+	return err
+}
+
+func DeleteIncome(key string) error {
+	col, err := GetCollection(COLLECTION_INCOME)
+	if err != nil {
+		return err
+	}
+
+	_, err = col.RemoveDocument(ctx, key)
+	return err
+}
+
+/*
+GetCashFlowStatistics combines income and purchases between from and to (both inclusive) per month into the net cash
+flow, the savings rate (net cash flow in percent of income) and the cumulative savings since the start of the range.
+Ranges which do not start or end with a month, like custom periods, only count the days within the range, so the
+purchases are read from the daily instead of the monthly aggregates.
+*/
+func GetCashFlowStatistics(from time.Time, to time.Time) (*CashFlow, error) {
+	if to.Before(from) {
+		return nil, fmt.Errorf("End of range must not be before its start")
+	}
+
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	qry := `LET income = (
+		FOR i IN income
+		FILTER i.date >= @from AND i.date <= @to
+		COLLECT period = SUBSTRING(i.date, 0, 7) AGGREGATE sum = SUM(TO_NUMBER(i.amount))
+		RETURN { period: period, sum: sum }
+	)
+	LET expenses = (
+		FOR a IN daily_aggregates
+		FILTER a.date >= @from AND a.date <= @to
+		COLLECT period = SUBSTRING(a.date, 0, 7) AGGREGATE sum = SUM(a.sum)
+		RETURN { period: period, sum: sum }
+	)
+	RETURN { income: income, expenses: expenses }`
+
+	c, err := db.Query(ctx, qry, map[string]interface{}{"from": DateToDb(from), "to": DateToDb(to)})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	type periodSum struct {
+		Period string  `json:"period"`
+		Sum    float64 `json:"sum"`
+	}
+	var res struct {
+		Income   []periodSum `json:"income"`
+		Expenses []periodSum `json:"expenses"`
+	}
+	_, err = c.ReadDocument(ctx, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	income := make(map[string]float64)
+	for _, m := range res.Income {
+		income[m.Period] = m.Sum
+	}
+	expenses := make(map[string]float64)
+	for _, m := range res.Expenses {
+		expenses[m.Period] = m.Sum
+	}
+
+	return buildCashFlow(income, expenses, from, to), nil
+}
+
+/*
+Builds the cash flow of the months between from and to out of the income and expenses per month ("YYYY-MM").
+*/
+func buildCashFlow(income map[string]float64, expenses map[string]float64, from time.Time, to time.Time) *CashFlow {
+	res := CashFlow{
+		From:   DateToDb(from),
+		To:     DateToDb(to),
+		Months: make([]CashFlowMonth, 0),
+	}
+
+	first := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+	last := time.Date(to.Year(), to.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	cumulative := 0.0
+	for m := first; !m.After(last); m = m.AddDate(0, 1, 0) {
+		period := fmt.Sprintf("%04d-%02d", m.Year(), m.Month())
+		month := CashFlowMonth{
+			Period:   period,
+			Income:   roundSum(income[period]),
+			Expenses: roundSum(expenses[period]),
+		}
+		month.Net = roundSum(month.Income - month.Expenses)
+		month.SavingsRate = savingsRate(month.Income, month.Net)
+
+		cumulative += month.Net
+		month.CumulativeSavings = roundSum(cumulative)
+
+		res.Income += month.Income
+		res.Expenses += month.Expenses
+		res.Months = append(res.Months, month)
+	}
+
+	res.Income = roundSum(res.Income)
+	res.Expenses = roundSum(res.Expenses)
+	res.Net = roundSum(res.Income - res.Expenses)
+	res.SavingsRate = savingsRate(res.Income, res.Net)
+
+	return &res
+}
+
+/*
+Returns the net cash flow in percent of the income, or nil without any income.
+*/
+func savingsRate(income float64, net float64) *float64 {
+	if income <= 0 {
+		return nil
+	}
+	rate := math.Round(net/income*10000) / 100
+	return &rate
+}
+
+func validateIncome(i *Income) error {
+	if i.Key == "" {
+		return fmt.Errorf("Missing income key")
+	}
+	if i.Source == "" {
+		return fmt.Errorf("Missing income source")
+	}
+	amount, err := strconv.ParseFloat(i.Amount, 64)
+	if err != nil || amount < 0 {
+		return fmt.Errorf("Invalid income amount '%s'", i.Amount)
+	}
+	d, err := DateFromDb(i.Date)
+	if err != nil {
+		return fmt.Errorf("Invalid income date '%s'", i.Date)
+	}
+	i.Month = int(d.Month())
+	i.Year = d.Year()
+
+	return nil
+}
@@ -0,0 +1,75 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildCashFlow(t *testing.T) {
+	income := map[string]float64{"2026-01": 3000, "2026-03": 3000}
+	expenses := map[string]float64{"2026-01": 2400, "2026-02": 500, "2026-03": 3300}
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC)
+
+	cf := buildCashFlow(income, expenses, from, to)
+	if len(cf.Months) != 3 || cf.From != "2026-01-01" || cf.To != "2026-03-31" {
+		t.Fatalf("Returns %d months from %s to %s instead of expected 3 from 2026-01-01 to 2026-03-31", len(cf.Months), cf.From, cf.To)
+	}
+
+	jan := cf.Months[0]
+	if jan.Net != 600 || jan.SavingsRate == nil || *jan.SavingsRate != 20 || jan.CumulativeSavings != 600 {
+		t.Errorf("Returns %+v for january", jan)
+	}
+	feb := cf.Months[1]
+	if feb.Net != -500 || feb.SavingsRate != nil || feb.CumulativeSavings != 100 {
+		t.Errorf("Returns %+v for february", feb)
+	}
+	mar := cf.Months[2]
+	if mar.Net != -300 || *mar.SavingsRate != -10 || mar.CumulativeSavings != -200 {
+		t.Errorf("Returns %+v for march", mar)
+	}
+
+	if cf.Net != -200 || cf.SavingsRate == nil || *cf.SavingsRate != -3.33 {
+		t.Errorf("Returns net %f and savings rate %v for the whole range", cf.Net, cf.SavingsRate)
+	}
+}
+
+func TestBuildCashFlowCustomPeriod(t *testing.T) {
+	// From payday to payday, the range is kept as it is
+	from := time.Date(2026, 2, 25, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 3, 24, 0, 0, 0, 0, time.UTC)
+
+	cf := buildCashFlow(map[string]float64{"2026-02": 3000}, map[string]float64{"2026-03": 800}, from, to)
+	if cf.From != "2026-02-25" || cf.To != "2026-03-24" || len(cf.Months) != 2 {
+		t.Fatalf("Returns %d months from %s to %s instead of expected 2 from 2026-02-25 to 2026-03-24", len(cf.Months), cf.From, cf.To)
+	}
+	if cf.Months[0].Period != "2026-02" || cf.Months[1].Period != "2026-03" || cf.Net != 2200 {
+		t.Errorf("Returns %+v with net %f instead of expected february and march with net 2200", cf.Months, cf.Net)
+	}
+}
+
+func TestValidateIncome(t *testing.T) {
+	i := Income{Key: "1", Source: "Salary", Amount: "3000.00", Date: "2026-03-25"}
+	err := validateIncome(&i)
+	if err != nil {
+		t.Fatalf("validateIncome returns error: %s", err)
+	}
+	if i.Month != 3 || i.Year != 2026 {
+		t.Errorf("validateIncome sets %d/%d instead of expected 3/2026", i.Month, i.Year)
+	}
+
+	for _, invalid := range []Income{
+		{Key: "1", Amount: "1.00", Date: "2026-03-25"},
+		{Key: "1", Source: "Salary", Amount: "abc", Date: "2026-03-25"},
+		{Key: "1", Source: "Salary", Amount: "1.00", Date: "25.03.2026"},
+	} {
+		if validateIncome(&invalid) == nil {
+			t.Errorf("validateIncome accepts %+v", invalid)
+		}
+	}
+}
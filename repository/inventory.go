@@ -0,0 +1,483 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	arango "github.com/arangodb/go-driver"
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+const (
+	COLLECTION_INVENTORY           = "inventory"
+	COLLECTION_INVENTORY_MOVEMENTS = "inventory_movements"
+
+	MOVEMENT_PURCHASE    = "purchase"
+	MOVEMENT_CONSUMPTION = "consumption"
+)
+
+type InventoryItem struct {
+	Key         string  `json:"_key"`
+	Name        string  `json:"name"`
+	Unit        string  `json:"unit"`
+	Quantity    float64 `json:"quantity"`
+	MinQuantity float64 `json:"min_quantity"`
+	BestBefore  string  `json:"best_before"`
+	Location    string  `json:"location"`
+}
+
+type InventoryMovement struct {
+	Key      string  `json:"_key"`
+	Item     string  `json:"item"`
+	Type     string  `json:"type"`
+	Quantity float64 `json:"quantity"`
+	Date     string  `json:"date"`
+	Purchase string  `json:"purchase,omitempty"`
+}
+
+type ConsumptionRate struct {
+	Item            string  `json:"item"`
+	Purchases       int     `json:"purchases"`
+	IntervalDays    float64 `json:"interval_days"`
+	RatePerDay      float64 `json:"rate_per_day"`
+	RatePerWeek     float64 `json:"rate_per_week"`
+	DaysRemaining   float64 `json:"days_remaining"`
+	NextPurchaseDue string  `json:"next_purchase_due"`
+}
+
+func GetInventoryItems() (*[]InventoryItem, error) {
+	return queryInventoryItems("FOR i IN inventory SORT i.name RETURN i", nil)
+}
+
+func GetLowStockInventoryItems() (*[]InventoryItem, error) {
+	return queryInventoryItems(
+		"FOR i IN inventory FILTER i.quantity <= i.min_quantity SORT i.quantity - i.min_quantity, i.name RETURN i",
+		nil,
+	)
+}
+
+func GetExpiringInventoryItems(until time.Time) (*[]InventoryItem, error) {
+	return queryInventoryItems(
+		"FOR i IN inventory FILTER i.quantity > 0 AND i.best_before != \"\" AND i.best_before <= @until SORT i.best_before, i.name RETURN i",
+		map[string]interface{}{"until": DateToDb(until)},
+	)
+}
+
+func GetInventoryItem(key string) (*InventoryItem, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := db.Query(
+		ctx,
+		"FOR i IN inventory FILTER i._key == @key RETURN i",
+		map[string]interface{}{"key": key},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var i InventoryItem
+	_, err = c.ReadDocument(ctx, &i)
+	if err != nil {
+		return nil, err
+	}
+
+	return &i, nil
+}
+
+func AddInventoryItem(item InventoryItem) (*InventoryItem, error) {
+	col, err := GetCollection(COLLECTION_INVENTORY)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate uuid: %s", err)
+	}
+	item.Key = key.String()
+
+	err = validateInventoryItem(&item)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = col.CreateDocument(ctx, item)
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+func UpdateInventoryItem(key string, data *map[string]interface{}) error {
+	col, err := GetCollection(COLLECTION_INVENTORY)
+	if err != nil {
+		return err
+	}
+
+	_, err = col.UpdateDocument(ctx, key, data)
+	return err
+}
+
+func DeleteInventoryItem(key string) error {
+	db, err := GetDb()
+	if err != nil {
+		return err
+	}
+	col, err := GetCollection(COLLECTION_INVENTORY)
+	if err != nil {
+		return err
+	}
+
+	_, err = col.RemoveDocument(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	c, err := db.Query(
+		ctx,
+		"FOR m IN inventory_movements FILTER m.item == @key REMOVE m IN inventory_movements",
+		map[string]interface{}{"key": key},
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to remove movements for inventory item '%s': %s", key, err)
+	}
+	c.Close()
+
+	return nil
+}
+
+/*
+ConsumeInventoryItem reduces the stock of the inventory item with the provided key by the given quantity and records the
+consumption. The stock never drops below zero. On success, the updated item is returned.
+*/
+func ConsumeInventoryItem(key string, quantity float64, date time.Time) (*InventoryItem, error) {
+	if quantity <= 0 {
+		return nil, fmt.Errorf("Consumed quantity must be greater than 0")
+	}
+
+	err := changeStock(key, -quantity, "")
+	if err != nil {
+		return nil, err
+	}
+
+	err = addInventoryMovement(InventoryMovement{
+		Item:     key,
+		Type:     MOVEMENT_CONSUMPTION,
+		Quantity: quantity,
+		Date:     DateToDb(date),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return GetInventoryItem(key)
+}
+
+/*
+GetConsumptionRate derives the consumption rate of an inventory item from how often and in which quantities it has been
+purchased.
+*/
+func GetConsumptionRate(key string) (*ConsumptionRate, error) {
+	item, err := GetInventoryItem(key)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := db.Query(
+		ctx,
+		"FOR m IN inventory_movements FILTER m.item == @key AND m.type == @type SORT m.date RETURN m",
+		map[string]interface{}{"key": key, "type": MOVEMENT_PURCHASE},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	movements := make([]InventoryMovement, 0)
+	for {
+		var m InventoryMovement
+		_, err := c.ReadDocument(ctx, &m)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		movements = append(movements, m)
+	}
+
+	rate := calculateConsumptionRate(movements, item.Quantity)
+	rate.Item = key
+	return &rate, nil
+}
+
+func calculateConsumptionRate(purchases []InventoryMovement, stock float64) ConsumptionRate {
+	rate := ConsumptionRate{Purchases: len(purchases)}
+	if len(purchases) < 2 {
+		return rate
+	}
+
+	first, err := DateFromDb(purchases[0].Date)
+	if err != nil {
+		return rate
+	}
+	last, err := DateFromDb(purchases[len(purchases)-1].Date)
+	if err != nil {
+		return rate
+	}
+
+	days := last.Sub(first).Hours() / 24
+	if days <= 0 {
+		return rate
+	}
+
+	// The last purchase has not been used up yet, so it does not count towards the consumed quantity.
+	consumed := 0.0
+	for _, p := range purchases[:len(purchases)-1] {
+		consumed += p.Quantity
+	}
+
+	rate.IntervalDays = days / float64(len(purchases)-1)
+	rate.RatePerDay = consumed / days
+	rate.RatePerWeek = rate.RatePerDay * 7
+	if rate.RatePerDay > 0 {
+		rate.DaysRemaining = stock / rate.RatePerDay
+		rate.NextPurchaseDue = DateToDb(Today().Add(time.Duration(rate.DaysRemaining*24) * time.Hour))
+	}
+
+	return rate
+}
+
+func addStockForPurchase(p *Purchase) error {
+	for _, pi := range p.Items {
+		err := changeStock(pi.Item, pi.Quantity, pi.BestBefore)
+		if err != nil {
+			return fmt.Errorf("Failed to add stock for inventory item '%s': %s", pi.Item, err)
+		}
+
+		err = addInventoryMovement(InventoryMovement{
+			Item:     pi.Item,
+			Type:     MOVEMENT_PURCHASE,
+			Quantity: pi.Quantity,
+			Date:     p.Date,
+			Purchase: p.Key,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+Inventory items may have been deleted since the purchase, their stock is gone already.
+*/
+func removeStockForPurchase(p *Purchase) error {
+	if len(p.Items) == 0 {
+		return nil
+	}
+
+	db, err := GetDb()
+	if err != nil {
+		return err
+	}
+
+	missing, err := missingInventoryItems(p.Items)
+	if err != nil {
+		return err
+	}
+
+	for _, pi := range p.Items {
+		if missing[pi.Item] {
+			continue
+		}
+		err := changeStock(pi.Item, -pi.Quantity, "")
+		if err != nil {
+			return fmt.Errorf("Failed to remove stock for inventory item '%s': %s", pi.Item, err)
+		}
+	}
+
+	c, err := db.Query(
+		ctx,
+		"FOR m IN inventory_movements FILTER m.purchase == @purchase REMOVE m IN inventory_movements",
+		map[string]interface{}{"purchase": p.Key},
+	)
+	if err != nil {
+		return err
+	}
+	c.Close()
+
+	return nil
+}
+
+/*
+checkPurchaseItems makes sure all inventory items of the purchase items exist, so no purchase is stored with stock that
+can not be added.
+*/
+func checkPurchaseItems(items []PurchaseItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	missing, err := missingInventoryItems(items)
+	if err != nil {
+		return err
+	}
+	for _, pi := range items {
+		if missing[pi.Item] {
+			return fmt.Errorf("Inventory item '%s' does not exist", pi.Item)
+		}
+	}
+
+	return nil
+}
+
+func missingInventoryItems(items []PurchaseItem) (map[string]bool, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(items))
+	for _, pi := range items {
+		keys = append(keys, pi.Item)
+	}
+
+	c, err := db.Query(
+		ctx,
+		"FOR k IN UNIQUE(@keys) FILTER DOCUMENT(\"inventory\", k) == null RETURN k",
+		map[string]interface{}{"keys": keys},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	res := make(map[string]bool)
+	for {
+		var key string
+		_, err := c.ReadDocument(ctx, &key)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		res[key] = true
+	}
+
+	return res, nil
+}
+
+func changeStock(key string, delta float64, bestBefore string) error {
+	db, err := GetDb()
+	if err != nil {
+		return err
+	}
+
+	// The earliest best-before date of the stock on hand wins; an empty stock takes the new date.
+	qry := `FOR i IN inventory
+		FILTER i._key == @key
+		LET bb = @bestBefore == "" ? i.best_before : (i.quantity <= 0 || i.best_before == "" ? @bestBefore : MIN([i.best_before, @bestBefore]))
+		UPDATE i WITH { quantity: MAX([0, i.quantity + @delta]), best_before: bb } IN inventory
+		RETURN NEW._key`
+
+	c, err := db.Query(ctx, qry, map[string]interface{}{"key": key, "delta": delta, "bestBefore": bestBefore})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var updated string
+	_, err = c.ReadDocument(ctx, &updated)
+	if arango.IsNoMoreDocuments(err) {
+		return fmt.Errorf("Inventory item '%s' does not exist", key)
+	}
+	return err
+}
+
+func addInventoryMovement(m InventoryMovement) error {
+	col, err := GetCollection(COLLECTION_INVENTORY_MOVEMENTS)
+	if err != nil {
+		return err
+	}
+
+	key, err := uuid.NewV4()
+	if err != nil {
+		return fmt.Errorf("failed to generate uuid: %s", err)
+	}
+	m.Key = key.String()
+
+	_, err = col.CreateDocument(ctx, m)
+	return err
+}
+
+func queryInventoryItems(qry string, bindVars map[string]interface{}) (*[]InventoryItem, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := db.Query(ctx, qry, bindVars)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	res := make([]InventoryItem, 0)
+	for {
+		var i InventoryItem
+		_, err := c.ReadDocument(ctx, &i)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		res = append(res, i)
+	}
+
+	return &res, nil
+}
+
+func validateInventoryItem(i *InventoryItem) error {
+	if i.Key == "" {
+		return fmt.Errorf("Missing inventory item key")
+	}
+	if i.Name == "" {
+		return fmt.Errorf("Missing inventory item name")
+	}
+	if i.Quantity < 0 {
+		return fmt.Errorf("Inventory item quantity must not be negative")
+	}
+	if i.MinQuantity < 0 {
+		return fmt.Errorf("Inventory item minimum quantity must not be negative")
+	}
+	if i.BestBefore != "" {
+		_, err := DateFromDb(i.BestBefore)
+		if err != nil {
+			return fmt.Errorf("Invalid best-before date '%s'", i.BestBefore)
+		}
+	}
+
+	return nil
+}
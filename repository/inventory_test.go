@@ -0,0 +1,33 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"testing"
+)
+
+func TestCalculateConsumptionRate(t *testing.T) {
+	rate := calculateConsumptionRate([]InventoryMovement{{Date: "2026-01-01", Quantity: 2}}, 2)
+	if rate.Purchases != 1 || rate.RatePerDay != 0 {
+		t.Errorf("Single purchase returns rate %f instead of expected 0", rate.RatePerDay)
+	}
+
+	purchases := []InventoryMovement{
+		{Date: "2026-01-01", Quantity: 2},
+		{Date: "2026-01-11", Quantity: 2},
+		{Date: "2026-01-21", Quantity: 4},
+	}
+	rate = calculateConsumptionRate(purchases, 3)
+	if rate.IntervalDays != 10 {
+		t.Errorf("Interval is %f instead of expected 10", rate.IntervalDays)
+	}
+	if rate.RatePerDay != 0.2 {
+		t.Errorf("Rate per day is %f instead of expected 0.2", rate.RatePerDay)
+	}
+	if rate.DaysRemaining != 15 {
+		t.Errorf("Days remaining is %f instead of expected 15", rate.DaysRemaining)
+	}
+}
@@ -15,6 +15,9 @@ import (
 
 const COLLECTION_SHOPTRAC_MIGRATIONS = "shoptrac_migrations"
 
+// The schema version after all migrations have run. Must be raised with every new migration.
+const LATEST_SCHEMA_VERSION = 12
+
 type Migration struct {
 	Version int    `json:"version"`
 	Created string `json:"created"`
@@ -34,6 +37,9 @@ func RunMigrations() error {
 		return err
 	}
 	log.Infof("Current database version: %d", currentVersion)
+	if currentVersion > LATEST_SCHEMA_VERSION {
+		log.Warningf("Database version %d is newer than the latest version %d known to this shoptrac.", currentVersion, LATEST_SCHEMA_VERSION)
+	}
 
 	newVersion, err := migrate(db, col, currentVersion)
 	if err != nil {
@@ -126,21 +132,94 @@ func migrate(db arango.Database, migrationsCollection arango.Collection, current
 
 	log := config.Logger()
 
-	finished := 1
+	finished := current
 	switch current {
 	case 1:
-		err := migrateFrom1(db)
+		err := runMigration(db, migrationsCollection, 2, migrateFrom1)
 		if err != nil {
 			return finished, err
 		}
-
 		finished = 2
-		err = addFinishedMigration(migrationsCollection, finished)
+		fallthrough
+
+	case 2:
+		err := runMigration(db, migrationsCollection, 3, migrateFrom2)
+		if err != nil {
+			return finished, err
+		}
+		finished = 3
+		fallthrough
+
+	case 3:
+		err := runMigration(db, migrationsCollection, 4, migrateFrom3)
+		if err != nil {
+			return finished, err
+		}
+		finished = 4
+		fallthrough
+
+	case 4:
+		err := runMigration(db, migrationsCollection, 5, migrateFrom4)
+		if err != nil {
+			return finished, err
+		}
+		finished = 5
+		fallthrough
+
+	case 5:
+		err := runMigration(db, migrationsCollection, 6, migrateFrom5)
+		if err != nil {
+			return finished, err
+		}
+		finished = 6
+		fallthrough
+
+	case 6:
+		err := runMigration(db, migrationsCollection, 7, migrateFrom6)
 		if err != nil {
-			log.Errorf("Failed to save finished migration information for version %d: %s", finished, err)
 			return finished, err
 		}
-		break
+		finished = 7
+		fallthrough
+
+	case 7:
+		err := runMigration(db, migrationsCollection, 8, migrateFrom7)
+		if err != nil {
+			return finished, err
+		}
+		finished = 8
+		fallthrough
+
+	case 8:
+		err := runMigration(db, migrationsCollection, 9, migrateFrom8)
+		if err != nil {
+			return finished, err
+		}
+		finished = 9
+		fallthrough
+
+	case 9:
+		err := runMigration(db, migrationsCollection, 10, migrateFrom9)
+		if err != nil {
+			return finished, err
+		}
+		finished = 10
+		fallthrough
+
+	case 10:
+		err := runMigration(db, migrationsCollection, 11, migrateFrom10)
+		if err != nil {
+			return finished, err
+		}
+		finished = 11
+		fallthrough
+
+	case 11:
+		err := runMigration(db, migrationsCollection, 12, migrateFrom11)
+		if err != nil {
+			return finished, err
+		}
+		finished = 12
 
 	default:
 		log.Infof("No migration from version %d.", current)
@@ -149,6 +228,46 @@ func migrate(db arango.Database, migrationsCollection arango.Collection, current
 	return finished, nil
 }
 
+func runMigration(db arango.Database, migrationsCollection arango.Collection, version int, fn func(arango.Database) error) error {
+	log := config.Logger()
+
+	err := fn(db)
+	if err != nil {
+		return err
+	}
+
+	err = addFinishedMigration(migrationsCollection, version)
+	if err != nil {
+		log.Errorf("Failed to save finished migration information for version %d: %s", version, err)
+		return err
+	}
+
+	return nil
+}
+
+func ensureCollection(db arango.Database, name string) (arango.Collection, error) {
+	exists, err := db.CollectionExists(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return db.Collection(ctx, name)
+	}
+
+	config.Logger().Infof("Creating collection '%s' ...", name)
+	opts := arango.CreateCollectionOptions{
+		KeyOptions: &arango.CollectionKeyOptions{
+			AllowUserKeys: true,
+		},
+	}
+	col, err := db.CreateCollection(ctx, name, &opts)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create collection '%s': %s", name, err)
+	}
+
+	return col, nil
+}
+
 func migrateFrom1(db arango.Database) error {
 	log := config.Logger()
 	log.Info("Starting database migration to version 2.")
@@ -223,3 +342,127 @@ func migrateFrom1(db arango.Database) error {
 
 	return nil
 }
+
+func migrateFrom2(db arango.Database) error {
+	log := config.Logger()
+	log.Info("Starting database migration to version 3.")
+
+	_, err := ensureCollection(db, COLLECTION_INVENTORY)
+	if err != nil {
+		return err
+	}
+
+	_, err = ensureCollection(db, COLLECTION_INVENTORY_MOVEMENTS)
+	return err
+}
+
+func migrateFrom3(db arango.Database) error {
+	log := config.Logger()
+	log.Info("Starting database migration to version 4.")
+
+	_, err := ensureCollection(db, COLLECTION_IMAGES)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Moving venue and shopper images to image storage ...")
+	err = migrateEmbeddedImages(db, COLLECTION_VENUES)
+	if err != nil {
+		return err
+	}
+
+	return migrateEmbeddedImages(db, COLLECTION_SHOPPERS)
+}
+
+func migrateFrom4(db arango.Database) error {
+	log := config.Logger()
+	log.Info("Starting database migration to version 5.")
+
+	_, err := ensureCollection(db, COLLECTION_BUDGETS)
+	return err
+}
+
+func migrateFrom5(db arango.Database) error {
+	log := config.Logger()
+	log.Info("Starting database migration to version 6.")
+
+	_, err := ensureCollection(db, COLLECTION_ALERT_RULES)
+	if err != nil {
+		return err
+	}
+
+	_, err = ensureCollection(db, COLLECTION_ALERT_LOG)
+	return err
+}
+
+func migrateFrom6(db arango.Database) error {
+	log := config.Logger()
+	log.Info("Starting database migration to version 7.")
+
+	_, err := ensureCollection(db, COLLECTION_BASELINES)
+	if err != nil {
+		return err
+	}
+
+	_, err = ensureCollection(db, COLLECTION_ANOMALIES)
+	return err
+}
+
+func migrateFrom7(db arango.Database) error {
+	log := config.Logger()
+	log.Info("Starting database migration to version 8.")
+
+	_, err := ensureCollection(db, COLLECTION_AGGREGATES)
+	if err != nil {
+		return err
+	}
+
+	_, err = ensureCollection(db, COLLECTION_DAILY_AGGREGATES)
+	if err != nil {
+		return err
+	}
+
+	return rebuildAggregates(db)
+}
+
+func migrateFrom8(db arango.Database) error {
+	log := config.Logger()
+	log.Info("Starting database migration to version 9.")
+
+	_, err := ensureCollection(db, COLLECTION_INCOME)
+	return err
+}
+
+func migrateFrom9(db arango.Database) error {
+	log := config.Logger()
+	log.Info("Starting database migration to version 10.")
+
+	_, err := ensureCollection(db, COLLECTION_GOALS)
+	if err != nil {
+		return err
+	}
+
+	_, err = ensureCollection(db, COLLECTION_CONTRIBUTIONS)
+	return err
+}
+
+func migrateFrom10(db arango.Database) error {
+	log := config.Logger()
+	log.Info("Starting database migration to version 11.")
+
+	_, err := ensureCollection(db, COLLECTION_REPORT_DELIVERIES)
+	return err
+}
+
+func migrateFrom11(db arango.Database) error {
+	log := config.Logger()
+	log.Info("Starting database migration to version 12.")
+
+	_, err := ensureCollection(db, COLLECTION_IMPORT_CANDIDATES)
+	if err != nil {
+		return err
+	}
+
+	_, err = ensureCollection(db, COLLECTION_VENUE_RULES)
+	return err
+}
@@ -0,0 +1,174 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	arango "github.com/arangodb/go-driver"
+)
+
+/*
+Every case of migrate runs the migration from its version to the next one, so the highest case plus one must be the
+latest schema version.
+*/
+func TestLatestSchemaVersion(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "migrations.go", nil, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse migrations.go: %s", err)
+	}
+
+	highest := 0
+	ast.Inspect(f, func(n ast.Node) bool {
+		fn, ok := n.(*ast.FuncDecl)
+		if !ok || fn.Name.Name != "migrate" {
+			return true
+		}
+
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			cc, ok := n.(*ast.CaseClause)
+			if !ok {
+				return true
+			}
+			for _, e := range cc.List {
+				if lit, ok := e.(*ast.BasicLit); ok && lit.Kind == token.INT {
+					v, _ := strconv.Atoi(lit.Value)
+					if v > highest {
+						highest = v
+					}
+				}
+			}
+			return true
+		})
+		return false
+	})
+
+	if highest == 0 {
+		t.Fatal("Found no migrations in migrate")
+	}
+	if LATEST_SCHEMA_VERSION != highest+1 {
+		t.Errorf("LATEST_SCHEMA_VERSION is %d instead of expected %d", LATEST_SCHEMA_VERSION, highest+1)
+	}
+}
+
+/*
+An in-memory database holding empty collections, sufficient for the migrations. Like ArangoDB, it fails to access or
+query collections which do not exist.
+*/
+type migrationTestDb struct {
+	arango.Database
+	collections map[string]*migrationTestCollection
+}
+
+type migrationTestCollection struct {
+	arango.Collection
+	name      string
+	documents int
+}
+
+type migrationTestCursor struct {
+	arango.Cursor
+}
+
+var queriedCollections = regexp.MustCompile(`\b(?:IN|INTO)\s+(@@\w+|[a-z_]+)\b`)
+
+func (db *migrationTestDb) CollectionExists(_ context.Context, name string) (bool, error) {
+	_, ok := db.collections[name]
+	return ok, nil
+}
+
+func (db *migrationTestDb) Collection(_ context.Context, name string) (arango.Collection, error) {
+	col, ok := db.collections[name]
+	if !ok {
+		return nil, fmt.Errorf("collection '%s' not found", name)
+	}
+	return col, nil
+}
+
+func (db *migrationTestDb) CreateCollection(_ context.Context, name string, _ *arango.CreateCollectionOptions) (arango.Collection, error) {
+	if _, ok := db.collections[name]; ok {
+		return nil, fmt.Errorf("duplicate collection '%s'", name)
+	}
+	db.collections[name] = &migrationTestCollection{name: name}
+	return db.collections[name], nil
+}
+
+func (db *migrationTestDb) Query(_ context.Context, query string, bindVars map[string]interface{}) (arango.Cursor, error) {
+	for _, m := range queriedCollections.FindAllStringSubmatch(query, -1) {
+		name := m[1]
+		if strings.HasPrefix(name, "@@") {
+			name, _ = bindVars[name[1:]].(string)
+		}
+		if _, ok := db.collections[name]; !ok {
+			return nil, fmt.Errorf("collection '%s' not found in query: %s", name, query)
+		}
+	}
+	return &migrationTestCursor{}, nil
+}
+
+func (c *migrationTestCollection) Name() string {
+	return c.name
+}
+
+func (c *migrationTestCollection) CreateDocument(_ context.Context, _ interface{}) (arango.DocumentMeta, error) {
+	c.documents++
+	return arango.DocumentMeta{}, nil
+}
+
+func (c *migrationTestCollection) Count(_ context.Context) (int64, error) {
+	return int64(c.documents), nil
+}
+
+func (c *migrationTestCollection) Truncate(_ context.Context) error {
+	c.documents = 0
+	return nil
+}
+
+func (c *migrationTestCursor) ReadDocument(_ context.Context, _ interface{}) (arango.DocumentMeta, error) {
+	return arango.DocumentMeta{}, arango.NoMoreDocumentsError{}
+}
+
+func (c *migrationTestCursor) Close() error {
+	return nil
+}
+
+/*
+Runs all migrations on an empty database with the collections of the initial version, as for a fresh install.
+*/
+func TestMigrateEmptyDatabase(t *testing.T) {
+	db := &migrationTestDb{collections: make(map[string]*migrationTestCollection)}
+	for _, name := range []string{COLLECTION_CATEGORIES, COLLECTION_PURCHASES, COLLECTION_SESSIONS, COLLECTION_USERS, COLLECTION_VENUES} {
+		db.collections[name] = &migrationTestCollection{name: name}
+	}
+
+	col, err := createMigrationsCollection(db)
+	if err != nil {
+		t.Fatalf("createMigrationsCollection returns error: %s", err)
+	}
+
+	version, err := migrate(db, col, 1)
+	if err != nil {
+		t.Fatalf("migrate returns error at version %d: %s", version, err)
+	}
+	if version != LATEST_SCHEMA_VERSION {
+		t.Errorf("migrate finishes at version %d instead of expected %d", version, LATEST_SCHEMA_VERSION)
+	}
+	if cnt, _ := col.Count(ctx); cnt != int64(LATEST_SCHEMA_VERSION) {
+		t.Errorf("migrate records %d migrations instead of expected %d", cnt, LATEST_SCHEMA_VERSION)
+	}
+	if _, ok := db.collections[COLLECTION_DAILY_AGGREGATES]; !ok {
+		t.Errorf("migrate does not create collection '%s'", COLLECTION_DAILY_AGGREGATES)
+	}
+}
@@ -0,0 +1,262 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mandrakey/shoptrac/config"
+)
+
+var (
+	rxPeriodYear    = regexp.MustCompile("^(\\d{4})$")
+	rxPeriodQuarter = regexp.MustCompile("^(\\d{4})-Q([1-4])$")
+	rxPeriodMonth   = regexp.MustCompile("^(\\d{4})-(\\d{2})$")
+	rxPeriodNamed   = regexp.MustCompile("^([a-z][a-z0-9_-]*):(current|(\\d{4})-(\\d{2}))$")
+)
+
+/*
+A range of dates, both ends inclusive.
+*/
+type DateRange struct {
+	Period string
+	From   time.Time
+	To     time.Time
+
+	// Set for configured periods, to find the neighbouring periods
+	name       string
+	definition *config.PeriodDefinition
+}
+
+func (r DateRange) FromDb() string {
+	return DateToDb(r.From)
+}
+
+func (r DateRange) ToDb() string {
+	return DateToDb(r.To)
+}
+
+/*
+Returns the number of days in the range, including both ends.
+*/
+func (r DateRange) Days() int {
+	return int(r.To.Sub(r.From).Hours()/24) + 1
+}
+
+/*
+Returns true if the range starts on the first of a month and ends on the last day of a month.
+*/
+func (r DateRange) spansWholeMonths() bool {
+	return r.From.Day() == 1 && r.To.AddDate(0, 0, 1).Day() == 1
+}
+
+func (r DateRange) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"period": r.Period, "from": r.FromDb(), "to": r.ToDb()})
+}
+
+/*
+ParsePeriod resolves a period identifier to a date range. Supported are years ("2026"), quarters ("2026-Q1"), months
+("2026-03"), explicit ranges of two dates separated by two dots ("2026-03-01..2026-03-15") and the periods configured in
+the application configuration, addressed by their name and either "current" or the year and number of the period
+("payday:2026-03").
+*/
+func ParsePeriod(period string) (*DateRange, error) {
+	return parsePeriod(period, config.GetAppConfig().Periods, Today())
+}
+
+func parsePeriod(period string, definitions map[string]config.PeriodDefinition, now time.Time) (*DateRange, error) {
+	period = strings.TrimSpace(period)
+
+	if m := rxPeriodNamed.FindStringSubmatch(period); m != nil {
+		def, ok := definitions[m[1]]
+		if !ok {
+			return nil, fmt.Errorf("Unknown period definition '%s'", m[1])
+		}
+		if m[2] == "current" {
+			return periodContaining(m[1], &def, now)
+		}
+		year, _ := strconv.Atoi(m[3])
+		number, _ := strconv.Atoi(m[4])
+		return periodByNumber(m[1], &def, year, number)
+	}
+
+	if m := rxPeriodYear.FindStringSubmatch(period); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		from := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+		return &DateRange{Period: period, From: from, To: from.AddDate(1, 0, -1)}, nil
+	}
+
+	if m := rxPeriodQuarter.FindStringSubmatch(period); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		quarter, _ := strconv.Atoi(m[2])
+		from := time.Date(year, time.Month((quarter-1)*3+1), 1, 0, 0, 0, 0, time.UTC)
+		return &DateRange{Period: period, From: from, To: from.AddDate(0, 3, -1)}, nil
+	}
+
+	if m := rxPeriodMonth.FindStringSubmatch(period); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		month, _ := strconv.Atoi(m[2])
+		if month < 1 || month > 12 {
+			return nil, fmt.Errorf("Invalid month in period '%s'", period)
+		}
+		from := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+		return &DateRange{Period: period, From: from, To: from.AddDate(0, 1, -1)}, nil
+	}
+
+	if parts := strings.Split(period, ".."); len(parts) == 2 {
+		from, err := DateFromDb(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid start of period '%s': %s", period, err)
+		}
+		to, err := DateFromDb(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid end of period '%s': %s", period, err)
+		}
+		if to.Before(from) {
+			return nil, fmt.Errorf("End of period '%s' is before its start", period)
+		}
+		return &DateRange{Period: period, From: from, To: to}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown period '%s'", period)
+}
+
+/*
+PreviousPeriod returns the period right before the provided one. For calendar based ranges spanning whole months, the
+previous range spans the same number of months, otherwise the same number of days.
+*/
+func PreviousPeriod(r DateRange) (*DateRange, error) {
+	if r.definition != nil {
+		return periodContaining(r.name, r.definition, r.From.AddDate(0, 0, -1))
+	}
+
+	if r.spansWholeMonths() {
+		months := (r.To.Year()-r.From.Year())*12 + int(r.To.Month()) - int(r.From.Month()) + 1
+		from := r.From.AddDate(0, -months, 0)
+		return &DateRange{Period: periodLabel(from, r.From.AddDate(0, 0, -1)), From: from, To: r.From.AddDate(0, 0, -1)}, nil
+	}
+
+	from := r.From.AddDate(0, 0, -r.Days())
+	return &DateRange{Period: periodLabel(from, r.From.AddDate(0, 0, -1)), From: from, To: r.From.AddDate(0, 0, -1)}, nil
+}
+
+/*
+Returns the range of a calendar month.
+*/
+func monthRange(month int, year int) DateRange {
+	from := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	return DateRange{Period: fmt.Sprintf("%04d-%02d", year, month), From: from, To: from.AddDate(0, 1, -1)}
+}
+
+func periodLabel(from time.Time, to time.Time) string {
+	return fmt.Sprintf("%s..%s", DateToDb(from), DateToDb(to))
+}
+
+/*
+Returns the number-th period of the given year, which is the month for calendar and custom start day periods, or the
+number-th four-week period starting in that year.
+*/
+func periodByNumber(name string, def *config.PeriodDefinition, year int, number int) (*DateRange, error) {
+	if number < 1 {
+		return nil, fmt.Errorf("Invalid number of period '%s:%04d-%02d'", name, year, number)
+	}
+
+	var from, to time.Time
+	switch def.Type {
+	case config.PeriodTypeCalendar, "":
+		if number > 12 {
+			return nil, fmt.Errorf("Invalid number of period '%s:%04d-%02d'", name, year, number)
+		}
+		from = time.Date(year, time.Month(number), 1, 0, 0, 0, 0, time.UTC)
+		to = from.AddDate(0, 1, -1)
+
+	case config.PeriodTypeStartDay:
+		if def.StartDay < 1 || def.StartDay > 28 {
+			return nil, fmt.Errorf("Invalid start day %d of period definition '%s'", def.StartDay, name)
+		}
+		if number > 12 {
+			return nil, fmt.Errorf("Invalid number of period '%s:%04d-%02d'", name, year, number)
+		}
+		from = time.Date(year, time.Month(number), def.StartDay, 0, 0, 0, 0, time.UTC)
+		to = from.AddDate(0, 1, -1)
+
+	case config.PeriodTypeFourWeek:
+		anchor, err := DateFromDb(def.Anchor)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid anchor of period definition '%s': %s", name, err)
+		}
+		first := fourWeekIndex(anchor, time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC))
+		if anchor.AddDate(0, 0, first*28).Year() < year {
+			first++
+		}
+		from = anchor.AddDate(0, 0, (first+number-1)*28)
+		if from.Year() != year {
+			return nil, fmt.Errorf("Invalid number of period '%s:%04d-%02d'", name, year, number)
+		}
+		to = from.AddDate(0, 0, 27)
+
+	default:
+		return nil, fmt.Errorf("Unknown type '%s' of period definition '%s'", def.Type, name)
+	}
+
+	return &DateRange{
+		Period:     fmt.Sprintf("%s:%04d-%02d", name, year, number),
+		From:       from,
+		To:         to,
+		name:       name,
+		definition: def,
+	}, nil
+}
+
+/*
+Returns the configured period the provided date belongs to.
+*/
+func periodContaining(name string, def *config.PeriodDefinition, date time.Time) (*DateRange, error) {
+	date = time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch def.Type {
+	case config.PeriodTypeCalendar, "":
+		return periodByNumber(name, def, date.Year(), int(date.Month()))
+
+	case config.PeriodTypeStartDay:
+		if date.Day() < def.StartDay {
+			date = time.Date(date.Year(), date.Month()-1, 1, 0, 0, 0, 0, time.UTC)
+		}
+		return periodByNumber(name, def, date.Year(), int(date.Month()))
+
+	case config.PeriodTypeFourWeek:
+		anchor, err := DateFromDb(def.Anchor)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid anchor of period definition '%s': %s", name, err)
+		}
+		from := anchor.AddDate(0, 0, fourWeekIndex(anchor, date)*28)
+		first := fourWeekIndex(anchor, time.Date(from.Year(), 1, 1, 0, 0, 0, 0, time.UTC))
+		if anchor.AddDate(0, 0, first*28).Year() < from.Year() {
+			first++
+		}
+		return periodByNumber(name, def, from.Year(), fourWeekIndex(anchor, from)-first+1)
+
+	default:
+		return nil, fmt.Errorf("Unknown type '%s' of period definition '%s'", def.Type, name)
+	}
+}
+
+/*
+Returns the index of the four-week period starting at anchor which contains the date, negative for dates before it.
+*/
+func fourWeekIndex(anchor time.Time, date time.Time) int {
+	days := int(math.Floor(date.Sub(anchor).Hours() / 24))
+	if days < 0 {
+		return -((-days + 27) / 28)
+	}
+	return days / 28
+}
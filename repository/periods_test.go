@@ -0,0 +1,101 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mandrakey/shoptrac/config"
+)
+
+func TestParsePeriod(t *testing.T) {
+	expected := map[string][2]string{
+		"2025":                   {"2025-01-01", "2025-12-31"},
+		"2026-Q1":                {"2026-01-01", "2026-03-31"},
+		"2024-02":                {"2024-02-01", "2024-02-29"},
+		"2026-03-01..2026-03-15": {"2026-03-01", "2026-03-15"},
+	}
+	for period, exp := range expected {
+		r, err := ParsePeriod(period)
+		if err != nil {
+			t.Errorf("'%s' returns error: %s", period, err)
+			continue
+		}
+		if r.FromDb() != exp[0] || r.ToDb() != exp[1] {
+			t.Errorf("'%s' returns %s..%s instead of expected %s..%s", period, r.FromDb(), r.ToDb(), exp[0], exp[1])
+		}
+	}
+
+	for _, period := range []string{"2026-13", "2026-Q5", "2026-03-15..2026-03-01", "march"} {
+		_, err := ParsePeriod(period)
+		if err == nil {
+			t.Errorf("'%s' returns no error", period)
+		}
+	}
+}
+
+func TestParseConfiguredPeriod(t *testing.T) {
+	definitions := map[string]config.PeriodDefinition{
+		"month":  {Type: config.PeriodTypeCalendar},
+		"payday": {Type: config.PeriodTypeStartDay, StartDay: 25},
+		"four":   {Type: config.PeriodTypeFourWeek, Anchor: "2026-01-05"},
+	}
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	expected := map[string][3]string{
+		"month:2024-02":  {"month:2024-02", "2024-02-01", "2024-02-29"},
+		"payday:2026-03": {"payday:2026-03", "2026-03-25", "2026-04-24"},
+		"payday:2026-12": {"payday:2026-12", "2026-12-25", "2027-01-24"},
+		"payday:current": {"payday:2026-02", "2026-02-25", "2026-03-24"},
+		"four:2026-01":   {"four:2026-01", "2026-01-05", "2026-02-01"},
+		"four:2026-13":   {"four:2026-13", "2026-12-07", "2027-01-03"},
+		"four:current":   {"four:2026-03", "2026-03-02", "2026-03-29"},
+	}
+	for period, exp := range expected {
+		r, err := parsePeriod(period, definitions, now)
+		if err != nil {
+			t.Errorf("'%s' returns error: %s", period, err)
+			continue
+		}
+		if r.Period != exp[0] || r.FromDb() != exp[1] || r.ToDb() != exp[2] {
+			t.Errorf("'%s' returns %s %s..%s instead of expected %s %s..%s", period, r.Period, r.FromDb(), r.ToDb(), exp[0], exp[1], exp[2])
+		}
+	}
+
+	for _, period := range []string{"payday:2026-13", "four:2026-14", "four:2026-00", "unknown:2026-01"} {
+		_, err := parsePeriod(period, definitions, now)
+		if err == nil {
+			t.Errorf("'%s' returns no error", period)
+		}
+	}
+}
+
+func TestPreviousPeriod(t *testing.T) {
+	definitions := map[string]config.PeriodDefinition{
+		"payday": {Type: config.PeriodTypeStartDay, StartDay: 25},
+		"four":   {Type: config.PeriodTypeFourWeek, Anchor: "2026-01-05"},
+	}
+
+	expected := map[string][2]string{
+		"2026-Q1":                {"2025-10-01", "2025-12-31"},
+		"2026-03":                {"2026-02-01", "2026-02-28"},
+		"2026-03-01..2026-03-10": {"2026-02-19", "2026-02-28"},
+		"payday:2026-03":         {"2026-02-25", "2026-03-24"},
+		"four:2026-01":           {"2025-12-08", "2026-01-04"},
+	}
+	for period, exp := range expected {
+		r, _ := parsePeriod(period, definitions, time.Now())
+		prev, err := PreviousPeriod(*r)
+		if err != nil {
+			t.Errorf("Previous of '%s' returns error: %s", period, err)
+			continue
+		}
+		if prev.FromDb() != exp[0] || prev.ToDb() != exp[1] {
+			t.Errorf("Previous of '%s' returns %s..%s instead of expected %s..%s", period, prev.FromDb(), prev.ToDb(), exp[0], exp[1])
+		}
+	}
+}
@@ -0,0 +1,279 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	arango "github.com/arangodb/go-driver"
+)
+
+const (
+	PIVOT_DEFAULT_LIMIT = 1000
+	PIVOT_MAX_LIMIT     = 10000
+)
+
+type pivotField struct {
+	expression string
+	kind       string
+	groupable  bool
+	// collection holding the names for keys of this field
+	lookup string
+	// expression on the monthly aggregates, empty if they do not hold this field
+	aggregate string
+}
+
+/*
+The schema of all fields a pivot query may use. Field and measure names are only ever taken from this schema, values are
+always passed as bind parameters. Weekdays are numbered from 0 (sunday) to 6 (saturday).
+*/
+var pivotFields = map[string]pivotField{
+	"category": {expression: "p.category", kind: FILTER_KIND_STRING, groupable: true, lookup: COLLECTION_CATEGORIES, aggregate: "a.category"},
+	"venue":    {expression: "p.venue", kind: FILTER_KIND_STRING, groupable: true, lookup: COLLECTION_VENUES, aggregate: "a.venue"},
+	"shopper":  {expression: "p.shopper", kind: FILTER_KIND_STRING, groupable: true, lookup: COLLECTION_SHOPPERS, aggregate: "a.shopper"},
+	"year":     {expression: "p.year", kind: FILTER_KIND_NUMBER, groupable: true, aggregate: "a.year"},
+	"month":    {expression: "p.month", kind: FILTER_KIND_NUMBER, groupable: true, aggregate: "a.month"},
+	"weekday":  {expression: "DATE_DAYOFWEEK(p.date)", kind: FILTER_KIND_NUMBER, groupable: true},
+	"date":     {expression: "p.date", kind: FILTER_KIND_STRING},
+	"sum":      {expression: "TO_NUMBER(p.sum)", kind: FILTER_KIND_NUMBER},
+}
+
+var pivotMeasures = map[string]string{
+	"sum":   "SUM(value)",
+	"count": "COUNT(value)",
+	"avg":   "AVG(value)",
+	"min":   "MIN(value)",
+	"max":   "MAX(value)",
+}
+
+// Measures which can be calculated from the monthly aggregates
+var pivotAggregateMeasures = map[string]string{
+	"sum":   "SUM(a.sum)",
+	"count": "SUM(a.count)",
+}
+
+type PivotSort struct {
+	Field string `json:"field"`
+	Desc  bool   `json:"desc"`
+}
+
+/*
+A PivotQuery declares how purchases are grouped, which measures of their sums are calculated and how the result is
+filtered, sorted and limited.
+*/
+type PivotQuery struct {
+	GroupBy  []string    `json:"group_by"`
+	Measures []string    `json:"measures"`
+	Filters  []Filter    `json:"filters"`
+	Sort     []PivotSort `json:"sort"`
+	Limit    int         `json:"limit"`
+}
+
+type PivotResult struct {
+	Columns []string                 `json:"columns"`
+	Rows    []map[string]interface{} `json:"rows"`
+}
+
+/*
+ExecutePivotQuery compiles the query to AQL and returns the resulting rows. Keys of categories, venues and shoppers are
+accompanied by their names.
+*/
+func ExecutePivotQuery(q PivotQuery) (*PivotResult, error) {
+	qry, data, columns, err := compilePivotQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := db.Query(ctx, qry, data)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	res := PivotResult{Columns: columns, Rows: make([]map[string]interface{}, 0)}
+	for {
+		var row map[string]interface{}
+		_, err := c.ReadDocument(ctx, &row)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		res.Rows = append(res.Rows, row)
+	}
+
+	return &res, nil
+}
+
+/*
+ValidatePivotQuery checks the query against the schema without running it.
+*/
+func ValidatePivotQuery(q PivotQuery) error {
+	_, _, _, err := compilePivotQuery(q)
+	return err
+}
+
+/*
+Validates the query against the schema and compiles it to AQL with bind parameters. Also returns the result columns.
+Filters are compiled by BuildFilterString. Queries which only use fields and measures held by the monthly aggregates
+are run on these instead of the purchases.
+*/
+func compilePivotQuery(q PivotQuery) (string, map[string]interface{}, []string, error) {
+	data := make(map[string]interface{})
+	columns := make([]string, 0)
+
+	measures := q.Measures
+	if len(measures) == 0 {
+		measures = []string{"sum", "count"}
+	}
+
+	aggregated := usesPivotAggregates(q, measures)
+	fields := make(map[string]FilterField, len(pivotFields))
+	for name, field := range pivotFields {
+		expression := field.expression
+		if aggregated {
+			expression = field.aggregate
+		}
+		fields[name] = FilterField{Expression: expression, Kind: field.kind}
+	}
+
+	lines := []string{"FOR p IN purchases", "LET value = TO_NUMBER(p.sum)"}
+	measureExpressions := pivotMeasures
+	if aggregated {
+		lines = []string{"FOR a IN aggregates"}
+		measureExpressions = pivotAggregateMeasures
+	}
+
+	// ----
+	// Filters
+
+	filter, err := BuildFilterString(q.Filters, fields, data)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if filter != "" {
+		lines = append(lines, "FILTER "+filter)
+	}
+
+	// ----
+	// Grouping and measures, prefixed to avoid clashes with AQL keywords
+
+	groups := make([]string, 0, len(q.GroupBy))
+	output := make([]string, 0)
+	for _, name := range q.GroupBy {
+		field, ok := pivotFields[name]
+		if !ok || !field.groupable {
+			return "", nil, nil, fmt.Errorf("Can not group by '%s'", name)
+		}
+		if containsString(columns, name) {
+			return "", nil, nil, fmt.Errorf("Duplicate group by '%s'", name)
+		}
+		groups = append(groups, fmt.Sprintf("g_%s = %s", name, fields[name].Expression))
+		output = append(output, fmt.Sprintf("%s: g_%s", name, name))
+		columns = append(columns, name)
+
+		if field.lookup != "" {
+			output = append(output, fmt.Sprintf("%s_name: DOCUMENT(\"%s\", g_%s).name", name, field.lookup, name))
+			columns = append(columns, name+"_name")
+		}
+	}
+
+	aggregates := make([]string, 0, len(measures))
+	for _, name := range measures {
+		expression, ok := measureExpressions[name]
+		if !ok {
+			return "", nil, nil, fmt.Errorf("Unknown measure '%s'", name)
+		}
+		if containsString(columns, name) {
+			return "", nil, nil, fmt.Errorf("Duplicate measure '%s'", name)
+		}
+		aggregates = append(aggregates, fmt.Sprintf("m_%s = %s", name, expression))
+		if name == "count" {
+			output = append(output, "count: m_count")
+		} else {
+			output = append(output, fmt.Sprintf("%s: ROUND(m_%s * 100) / 100", name, name))
+		}
+		columns = append(columns, name)
+	}
+
+	lines = append(lines, fmt.Sprintf("COLLECT %s AGGREGATE %s", strings.Join(groups, ", "), strings.Join(aggregates, ", ")))
+
+	// ----
+	// Sort and limit
+
+	if len(q.Sort) > 0 {
+		sorts := make([]string, 0, len(q.Sort))
+		for _, s := range q.Sort {
+			variable := ""
+			if containsString(q.GroupBy, s.Field) {
+				variable = "g_" + s.Field
+			} else if containsString(measures, s.Field) {
+				variable = "m_" + s.Field
+			} else {
+				return "", nil, nil, fmt.Errorf("Can not sort by '%s', which is neither grouped by nor a measure", s.Field)
+			}
+			direction := "ASC"
+			if s.Desc {
+				direction = "DESC"
+			}
+			sorts = append(sorts, fmt.Sprintf("%s %s", variable, direction))
+		}
+		lines = append(lines, "SORT "+strings.Join(sorts, ", "))
+	}
+
+	limit := q.Limit
+	if limit == 0 {
+		limit = PIVOT_DEFAULT_LIMIT
+	}
+	if limit < 0 || limit > PIVOT_MAX_LIMIT {
+		return "", nil, nil, fmt.Errorf("Limit must be between 1 and %d", PIVOT_MAX_LIMIT)
+	}
+	data["limit"] = limit
+	lines = append(lines, "LIMIT @limit")
+
+	lines = append(lines, fmt.Sprintf("RETURN { %s }", strings.Join(output, ", ")))
+	return strings.Join(lines, "\n"), data, columns, nil
+}
+
+/*
+Returns whether all fields and measures of the query are held by the monthly aggregates.
+*/
+func usesPivotAggregates(q PivotQuery, measures []string) bool {
+	names := make([]string, 0, len(q.GroupBy)+len(q.Filters))
+	names = append(names, q.GroupBy...)
+	for _, f := range q.Filters {
+		names = append(names, f.Field)
+	}
+	for _, name := range names {
+		if pivotFields[name].aggregate == "" {
+			return false
+		}
+	}
+
+	for _, name := range measures {
+		if _, ok := pivotAggregateMeasures[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
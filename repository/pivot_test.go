@@ -0,0 +1,130 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompilePivotQuery(t *testing.T) {
+	q := PivotQuery{
+		GroupBy:  []string{"category", "month"},
+		Measures: []string{"sum", "count"},
+		Filters: []Filter{
+			{Field: "year", Op: "eq", Value: float64(2026)},
+			{Field: "venue", Op: "in", Value: []interface{}{"1", "2"}},
+		},
+		Sort:  []PivotSort{{Field: "sum", Desc: true}},
+		Limit: 10,
+	}
+
+	qry, data, columns, err := compilePivotQuery(q)
+	if err != nil {
+		t.Fatalf("compilePivotQuery returns error: %s", err)
+	}
+
+	expected := `FOR a IN aggregates
+FILTER a.year == @f0 AND a.venue IN @f1
+COLLECT g_category = a.category, g_month = a.month AGGREGATE m_sum = SUM(a.sum), m_count = SUM(a.count)
+SORT m_sum DESC
+LIMIT @limit
+RETURN { category: g_category, category_name: DOCUMENT("categories", g_category).name, month: g_month, sum: ROUND(m_sum * 100) / 100, count: m_count }`
+	if qry != expected {
+		t.Errorf("compilePivotQuery returns\n%s\ninstead of expected\n%s", qry, expected)
+	}
+	if data["f0"] != float64(2026) || data["limit"] != 10 || len(data) != 3 {
+		t.Errorf("compilePivotQuery returns bind parameters %v", data)
+	}
+	if strings.Join(columns, ",") != "category,category_name,month,sum,count" {
+		t.Errorf("compilePivotQuery returns columns %v", columns)
+	}
+}
+
+func TestCompilePivotQueryOnPurchases(t *testing.T) {
+	// Weekdays, dates and averages are not held by the aggregates
+	queries := map[string]PivotQuery{
+		"weekday": {GroupBy: []string{"weekday"}},
+		"date":    {GroupBy: []string{"venue"}, Filters: []Filter{{Field: "date", Op: "ge", Value: "2026-01-01"}}},
+		"avg":     {GroupBy: []string{"venue"}, Measures: []string{"avg"}},
+	}
+	for name, q := range queries {
+		qry, _, _, err := compilePivotQuery(q)
+		if err != nil {
+			t.Errorf("compilePivotQuery returns error for query by %s: %s", name, err)
+			continue
+		}
+		if !strings.HasPrefix(qry, "FOR p IN purchases\n") {
+			t.Errorf("compilePivotQuery does not read purchases for query by %s:\n%s", name, qry)
+		}
+	}
+
+	qry, _, _, err := compilePivotQuery(queries["date"])
+	if err != nil || !strings.Contains(qry, "FILTER p.date >= @f0\nCOLLECT g_venue = p.venue AGGREGATE m_sum = SUM(value), m_count = COUNT(value)") {
+		t.Errorf("compilePivotQuery returns\n%s", qry)
+	}
+}
+
+func TestBuildFilterString(t *testing.T) {
+	fields := map[string]FilterField{
+		"venue": {Expression: "p.venue", Kind: FILTER_KIND_STRING},
+		"sum":   {Expression: "TO_NUMBER(p.sum)", Kind: FILTER_KIND_NUMBER},
+	}
+	data := make(map[string]interface{})
+	filters := []Filter{
+		{Field: "venue", Op: "like", Value: "Super%"},
+		{Field: "sum", Op: "gt", Value: float64(10)},
+		{Field: "venue", Op: "ne", Value: nil},
+	}
+
+	res, err := BuildFilterString(filters, fields, data)
+	if err != nil {
+		t.Fatalf("BuildFilterString returns error: %s", err)
+	}
+	if res != "p.venue LIKE @f0 AND TO_NUMBER(p.sum) > @f1 AND p.venue != @f2" {
+		t.Errorf("BuildFilterString returns '%s'", res)
+	}
+	if data["f0"] != "Super%" || data["f1"] != float64(10) || len(data) != 3 {
+		t.Errorf("BuildFilterString returns bind parameters %v", data)
+	}
+
+	if res, _ := BuildFilterString(nil, fields, data); res != "" {
+		t.Errorf("BuildFilterString returns '%s' without filters", res)
+	}
+
+	invalid := [][]Filter{
+		{{Field: "sum", Op: "like", Value: "1%"}},
+		{{Field: "sum", Op: "lt", Value: nil}},
+	}
+	for _, f := range invalid {
+		if _, err := BuildFilterString(f, fields, make(map[string]interface{})); err == nil {
+			t.Errorf("BuildFilterString accepts %+v", f[0])
+		}
+	}
+}
+
+func TestCompilePivotQueryRejectsInvalid(t *testing.T) {
+	invalid := map[string]PivotQuery{
+		"unknown group":          {GroupBy: []string{"p._key"}},
+		"group by date":          {GroupBy: []string{"date"}},
+		"unknown measure":        {Measures: []string{"median"}},
+		"unknown filter field":   {Filters: []Filter{{Field: "password", Op: "eq", Value: "x"}}},
+		"unknown operator":       {Filters: []Filter{{Field: "year", Op: "LIKE", Value: float64(1)}}},
+		"wrong value type":       {Filters: []Filter{{Field: "year", Op: "eq", Value: "2026 OR 1 == 1"}}},
+		"in without list":        {Filters: []Filter{{Field: "venue", Op: "in", Value: "1"}}},
+		"sort by ungrouped":      {GroupBy: []string{"venue"}, Sort: []PivotSort{{Field: "category"}}},
+		"limit too large":        {Limit: PIVOT_MAX_LIMIT + 1},
+		"duplicate group by":     {GroupBy: []string{"venue", "venue"}},
+		"object as filter value": {Filters: []Filter{{Field: "venue", Op: "eq", Value: map[string]interface{}{}}}},
+	}
+
+	for name, q := range invalid {
+		_, _, _, err := compilePivotQuery(q)
+		if err == nil {
+			t.Errorf("compilePivotQuery accepts query with %s", name)
+		}
+	}
+}
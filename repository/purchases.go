@@ -17,14 +17,22 @@ const (
 )
 
 type Purchase struct {
-	Key      string `json:"_key"`
-	Category string `json:"category"`
-	Venue    string `json:"venue"`
-	Shopper  string `json:"shopper"`
-	Date     string `json:"date"`
-	Month    int    `json:"month"`
-	Year     int    `json:"year"`
-	Sum      string `json:"sum"`
+	Key      string         `json:"_key"`
+	Category string         `json:"category"`
+	Venue    string         `json:"venue"`
+	Shopper  string         `json:"shopper"`
+	Date     string         `json:"date"`
+	Month    int            `json:"month"`
+	Year     int            `json:"year"`
+	Sum      string         `json:"sum"`
+	Items    []PurchaseItem `json:"items,omitempty"`
+	Anomaly  bool           `json:"anomaly,omitempty"`
+}
+
+type PurchaseItem struct {
+	Item       string  `json:"item"`
+	Quantity   float64 `json:"quantity"`
+	BestBefore string  `json:"best_before,omitempty"`
 }
 
 type PurchaseTimestamp struct {
@@ -33,6 +41,23 @@ type PurchaseTimestamp struct {
 }
 
 func GetPurchases(month int, year int) (*[]Purchase, error) {
+	return queryPurchases(
+		"FILTER p.month == @month AND p.year == @year",
+		map[string]interface{}{"month": month, "year": year},
+	)
+}
+
+/*
+GetPurchasesInRange returns all purchases dated within the provided range, regardless of their month and year fields.
+*/
+func GetPurchasesInRange(r DateRange) (*[]Purchase, error) {
+	return queryPurchases(
+		"FILTER p.date >= @from AND p.date <= @to",
+		map[string]interface{}{"from": r.FromDb(), "to": r.ToDb()},
+	)
+}
+
+func queryPurchases(filter string, data map[string]interface{}) (*[]Purchase, error) {
 	db, err := GetDb()
 	if err != nil {
 		return nil, err
@@ -43,9 +68,12 @@ func GetPurchases(month int, year int) (*[]Purchase, error) {
 
 	c, err := db.Query(
 		ctx,
-		"FOR p IN purchases FILTER p.month == @month AND p.year == @year SORT p.date DESC RETURN p",
-		map[string]interface{}{"month": month, "year": year},
+		"FOR p IN purchases "+filter+" SORT p.date DESC RETURN MERGE(p, { anomaly: DOCUMENT(\"anomalies\", p._key) != null })",
+		data,
 	)
+	if err != nil {
+		return nil, err
+	}
 	defer c.Close()
 
 	res := make([]Purchase, c.Count())
@@ -172,23 +200,130 @@ func AddPurchase(purchase Purchase) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	err = checkPurchaseItems(purchase.Items)
+	if err != nil {
+		return "", err
+	}
 
 	_, err = col.CreateDocument(ctx, purchase)
 	if err != nil {
 		return "", err
 	}
 
+	err = applyAggregate(&purchase, 1)
+	if err != nil {
+		return purchase.Key, fmt.Errorf("Failed to update aggregates: %s", err)
+	}
+
+	err = addStockForPurchase(&purchase)
+	if err != nil {
+		return purchase.Key, err
+	}
+
 	return purchase.Key, nil
 }
 
+/*
+AddPurchases stores all provided purchases in a single batch and adds them to the aggregates. If any purchase is invalid,
+none is stored. Returns the keys of the new purchases in the order of the purchases.
+*/
+func AddPurchases(purchases []Purchase) ([]string, error) {
+	col, err := GetCollection(COLLECTION_PURCHASES)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(purchases))
+	for i := range purchases {
+		key, err := uuid.NewV4()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate uuid: %s", err)
+		}
+		purchases[i].Key = key.String()
+		keys[i] = purchases[i].Key
+
+		err = validatePurchase(&purchases[i])
+		if err == nil {
+			err = checkPurchaseItems(purchases[i].Items)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Purchase %d: %s", i+1, err)
+		}
+	}
+
+	_, errs, err := col.CreateDocuments(ctx, purchases)
+	if err == nil {
+		err = errs.FirstNonNil()
+	}
+	if err != nil {
+		// Remove the purchases which have been stored, so the batch is either stored completely or not at all
+		col.RemoveDocuments(ctx, keys)
+		return nil, err
+	}
+
+	err = applyAggregates(keys)
+	if err != nil {
+		return keys, fmt.Errorf("Failed to update aggregates: %s", err)
+	}
+
+	for i := range purchases {
+		err = addStockForPurchase(&purchases[i])
+		if err != nil {
+			return keys, err
+		}
+	}
+
+	return keys, nil
+}
+
+/*
+UpdatePurchase changes the provided values of a purchase. If items or date change, the stock added by the purchase is
+booked again.
+*/
 func UpdatePurchase(key string, data *map[string]interface{}) error {
 	col, err := GetCollection(COLLECTION_PURCHASES)
 	if err != nil {
 		return err
 	}
 
-	_, err = col.UpdateDocument(ctx, key, data)
-	return err
+	items, hasItems := (*data)["items"]
+	if hasItems {
+		list, ok := items.([]PurchaseItem)
+		if !ok {
+			return fmt.Errorf("Invalid purchase items")
+		}
+		err = checkPurchaseItems(list)
+		if err != nil {
+			return err
+		}
+	}
+
+	var old, updated Purchase
+	_, err = col.UpdateDocument(arango.WithReturnNew(arango.WithReturnOld(ctx, &old), &updated), key, data)
+	if err != nil {
+		return err
+	}
+
+	err = applyAggregate(&old, -1)
+	if err == nil {
+		err = applyAggregate(&updated, 1)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to update aggregates: %s", err)
+	}
+
+	_, hasDate := (*data)["date"]
+	if hasItems || (hasDate && len(old.Items) > 0) {
+		err = removeStockForPurchase(&old)
+		if err == nil {
+			err = addStockForPurchase(&updated)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to update stock: %s", err)
+		}
+	}
+
+	return nil
 }
 
 func DeletePurchase(key string) error {
@@ -197,8 +332,26 @@ func DeletePurchase(key string) error {
 		return err
 	}
 
-	_, err = col.RemoveDocument(ctx, key)
-	return err
+	var p Purchase
+	_, err = col.RemoveDocument(arango.WithReturnOld(ctx, &p), key)
+	if err != nil {
+		return err
+	}
+
+	err = applyAggregate(&p, -1)
+	if err != nil {
+		return fmt.Errorf("Failed to update aggregates: %s", err)
+	}
+
+	return removeStockForPurchase(&p)
+}
+
+/*
+ValidatePurchase checks the purchase like AddPurchase does before storing it. Missing month and year are set from the
+date.
+*/
+func ValidatePurchase(p *Purchase) error {
+	return validatePurchase(p)
 }
 
 func validatePurchase(p *Purchase) error {
@@ -217,12 +370,28 @@ func validatePurchase(p *Purchase) error {
 	if p.Date == "" {
 		return fmt.Errorf("Missing purchase date")
 	}
+	d, err := DateFromDb(p.Date)
+	if err != nil {
+		return fmt.Errorf("Invalid purchase date '%s'", p.Date)
+	}
+	if p.Month == 0 && p.Year == 0 {
+		p.Month = int(d.Month())
+		p.Year = d.Year()
+	}
 	if p.Month < 0 || p.Month > 12 {
 		return fmt.Errorf("Invalid purchase month '%d'", p.Month)
 	}
 	if p.Sum == "" {
 		return fmt.Errorf("Missing purchase sum")
 	}
+	for _, pi := range p.Items {
+		if pi.Item == "" {
+			return fmt.Errorf("Missing inventory item for purchase item")
+		}
+		if pi.Quantity <= 0 {
+			return fmt.Errorf("Invalid quantity '%f' for inventory item '%s'", pi.Quantity, pi.Item)
+		}
+	}
 
 	return nil
 }
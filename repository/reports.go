@@ -0,0 +1,290 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/mail"
+	"time"
+
+	arango "github.com/arangodb/go-driver"
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+const (
+	COLLECTION_REPORT_DELIVERIES = "report_deliveries"
+
+	REPORT_SCHEDULE_WEEKLY  = "weekly"
+	REPORT_SCHEDULE_MONTHLY = "monthly"
+
+	REPORT_FORMAT_HTML = "html"
+	REPORT_FORMAT_CSV  = "csv"
+	REPORT_FORMAT_PDF  = "pdf"
+
+	REPORT_DELIVERY_EMAIL     = "email"
+	REPORT_DELIVERY_DIRECTORY = "directory"
+
+	REPORT_STATUS_DELIVERED = "delivered"
+	REPORT_STATUS_FAILED    = "failed"
+)
+
+/*
+A ReportSubscription is stored with its user and asks for a summary of every past week or month. Email reports are sent
+to the target address or, if there is none, to the user's address. Directory reports are written to the configured report
+directory.
+*/
+type ReportSubscription struct {
+	Key      string `json:"key"`
+	Schedule string `json:"schedule"`
+	Format   string `json:"format"`
+	Delivery string `json:"delivery"`
+	Target   string `json:"target,omitempty"`
+	Created  string `json:"created"`
+}
+
+/*
+A ReportDelivery records the delivery of a subscription for one period. Failed deliveries are retried until the maximum
+number of attempts is reached.
+*/
+type ReportDelivery struct {
+	Key          string `json:"_key"`
+	UserKey      string `json:"user_key"`
+	Subscription string `json:"subscription"`
+	Period       string `json:"period"`
+	Status       string `json:"status"`
+	Attempts     int    `json:"attempts"`
+	Error        string `json:"error,omitempty"`
+	LastAttempt  string `json:"last_attempt"`
+	NextAttempt  string `json:"next_attempt,omitempty"`
+}
+
+func GetReportSubscriptions(userKey string) ([]ReportSubscription, error) {
+	user, err := GetUser(userKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Reports == nil {
+		return make([]ReportSubscription, 0), nil
+	}
+	return user.Reports, nil
+}
+
+/*
+GetUsersWithReports returns all users with at least one report subscription.
+*/
+func GetUsersWithReports() ([]User, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := db.Query(ctx, "FOR u IN users FILTER LENGTH(u.reports) > 0 RETURN u", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	res := make([]User, 0)
+	for {
+		var u User
+		_, err := c.ReadDocument(ctx, &u)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		res = append(res, u)
+	}
+
+	return res, nil
+}
+
+func AddReportSubscription(userKey string, sub ReportSubscription) (*ReportSubscription, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate uuid: %s", err)
+	}
+	sub.Key = key.String()
+	sub.Created = DateTimeToDb(time.Now().UTC())
+
+	err = validateReportSubscription(&sub)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := db.Query(
+		ctx,
+		`FOR u IN users FILTER u._key == @user
+		UPDATE u WITH { reports: APPEND(NOT_NULL(u.reports, []), [@sub]) } IN users
+		RETURN NEW._key`,
+		map[string]interface{}{"user": userKey, "sub": sub},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var updated string
+	_, err = c.ReadDocument(ctx, &updated)
+	if arango.IsNoMoreDocuments(err) {
+		return nil, fmt.Errorf("Unknown user '%s'", userKey)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &sub, nil
+}
+
+/*
+Removes the report subscription with the provided key from the user. The delivery history is kept.
+*/
+func DeleteReportSubscription(userKey string, key string) error {
+	db, err := GetDb()
+	if err != nil {
+		return err
+	}
+
+	c, err := db.Query(
+		ctx,
+		`FOR u IN users FILTER u._key == @user
+		FILTER @key IN u.reports[*].key
+		UPDATE u WITH { reports: (FOR r IN u.reports FILTER r.key != @key RETURN r) } IN users
+		RETURN NEW._key`,
+		map[string]interface{}{"user": userKey, "key": key},
+	)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var updated string
+	_, err = c.ReadDocument(ctx, &updated)
+	if arango.IsNoMoreDocuments(err) {
+		return fmt.Errorf("Unknown report subscription '%s'", key)
+	}
+	return err
+}
+
+/*
+GetReportDelivery returns the recorded delivery of a subscription for the given period, or nil if there is none yet.
+*/
+func GetReportDelivery(userKey string, subscription string, period string) (*ReportDelivery, error) {
+	col, err := GetCollection(COLLECTION_REPORT_DELIVERIES)
+	if err != nil {
+		return nil, err
+	}
+
+	var d ReportDelivery
+	_, err = col.ReadDocument(ctx, ReportDeliveryKey(userKey, subscription, period), &d)
+	if arango.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+/*
+GetReportDeliveries returns the latest deliveries of all subscriptions of a user.
+*/
+func GetReportDeliveries(userKey string, limit int) (*[]ReportDelivery, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := db.Query(
+		ctx,
+		`FOR d IN report_deliveries
+		FILTER d.user_key == @user
+		SORT d.last_attempt DESC
+		LIMIT @limit
+		RETURN d`,
+		map[string]interface{}{"user": userKey, "limit": limit},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	res := make([]ReportDelivery, 0)
+	for {
+		var d ReportDelivery
+		_, err := c.ReadDocument(ctx, &d)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		res = append(res, d)
+	}
+
+	return &res, nil
+}
+
+func SaveReportDelivery(d ReportDelivery) error {
+	db, err := GetDb()
+	if err != nil {
+		return err
+	}
+
+	d.Key = ReportDeliveryKey(d.UserKey, d.Subscription, d.Period)
+	c, err := db.Query(
+		ctx,
+		"UPSERT { _key: @key } INSERT @delivery REPLACE @delivery IN report_deliveries",
+		map[string]interface{}{"key": d.Key, "delivery": d},
+	)
+	if err != nil {
+		return err
+	}
+	c.Close()
+
+	return nil
+}
+
+func ReportDeliveryKey(userKey string, subscription string, period string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(userKey+"/"+subscription+"/"+period)))
+}
+
+func validateReportSubscription(s *ReportSubscription) error {
+	if s.Key == "" {
+		return fmt.Errorf("Missing report subscription key")
+	}
+	if s.Schedule != REPORT_SCHEDULE_WEEKLY && s.Schedule != REPORT_SCHEDULE_MONTHLY {
+		return fmt.Errorf("Invalid report schedule '%s'", s.Schedule)
+	}
+	if s.Format != REPORT_FORMAT_HTML && s.Format != REPORT_FORMAT_CSV && s.Format != REPORT_FORMAT_PDF {
+		return fmt.Errorf("Invalid report format '%s'", s.Format)
+	}
+	switch s.Delivery {
+	case REPORT_DELIVERY_EMAIL:
+		if s.Target != "" {
+			addr, err := mail.ParseAddress(s.Target)
+			if err != nil || addr.Address != s.Target {
+				return fmt.Errorf("Invalid report email address '%s'", s.Target)
+			}
+		}
+	case REPORT_DELIVERY_DIRECTORY:
+		// Reports are always written to the configured directory
+		s.Target = ""
+	default:
+		return fmt.Errorf("Invalid report delivery '%s'", s.Delivery)
+	}
+
+	return nil
+}
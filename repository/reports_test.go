@@ -0,0 +1,28 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"testing"
+)
+
+func TestValidateReportSubscription(t *testing.T) {
+	s := ReportSubscription{Key: "1", Schedule: REPORT_SCHEDULE_WEEKLY, Format: REPORT_FORMAT_HTML, Delivery: REPORT_DELIVERY_EMAIL}
+
+	for _, target := range []string{"", "jane@example.org"} {
+		s.Target = target
+		if err := validateReportSubscription(&s); err != nil {
+			t.Errorf("validateReportSubscription returns error for target '%s': %s", target, err)
+		}
+	}
+
+	for _, target := range []string{"jane", "Jane <jane@example.org>", "jane@example.org, joe@example.org", "jane@example.org\r\nBcc: joe@example.org"} {
+		s.Target = target
+		if err := validateReportSubscription(&s); err == nil {
+			t.Errorf("validateReportSubscription accepts invalid target '%s'", target)
+		}
+	}
+}
@@ -61,8 +61,8 @@ func GetUnexpiredSessionById(sessionId string) (*Session, error) {
 
 	c, err := db.Query(
 		ctx,
-		"FOR s IN sessions FILTER s._key == @sessionId AND DATE_DIFF(DATE_NOW(), s.expires, \"s\") >= 0 RETURN s",
-		map[string]interface{}{"sessionId": sessionId},
+		"FOR s IN sessions FILTER s._key == @sessionId AND s.expires >= @now RETURN s",
+		map[string]interface{}{"sessionId": sessionId, "now": dbNow()},
 	)
 	if err != nil {
 		return nil, err
@@ -100,8 +100,8 @@ func GetSessionWithToken(sessionId string, token string) (*Session, error) {
 
 	c, err := db.Query(
 		ctx,
-		"FOR s IN sessions FILTER s._key == @sessionid AND s.remember_me_token == @token AND DATE_DIFF(DATE_NOW(), s.remember_me_expires, \"s\") >= 0 RETURN s",
-		map[string]interface{}{"sessionid": sessionId, "token": hashed},
+		"FOR s IN sessions FILTER s._key == @sessionid AND s.remember_me_token == @token AND s.remember_me_expires >= @now RETURN s",
+		map[string]interface{}{"sessionid": sessionId, "token": hashed, "now": dbNow()},
 	)
 	if err != nil {
 		return nil, err
@@ -180,8 +180,8 @@ func DeleteExpiredSessions() error {
 
 	c, err := db.Query(
 		ctx,
-		"FOR s IN sessions FILTER DATE_DIFF(DATE_NOW(), s.expires, \"s\") < 0 return s._key",
-		nil,
+		"FOR s IN sessions FILTER s.expires < @now return s._key",
+		map[string]interface{}{"now": dbNow()},
 	)
 	if err != nil {
 		return err
@@ -7,6 +7,7 @@ package repository
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 
 	arango "github.com/arangodb/go-driver"
@@ -27,21 +28,21 @@ func GetOverviewStatistics(month int, year int) (map[string]*CountSumHolder, err
 	// Query database
 
 	qry := `LET currentMonth = (
-		FOR p IN purchases
-		FILTER p.month == @month AND p.year == @year
-		COLLECT AGGREGATE sum = SUM(to_number(p.sum)), cnt = COUNT(p)
-		RETURN { count: cnt, sum: sum != null ? sum : 0 }
+		FOR a IN aggregates
+		FILTER a.month == @month AND a.year == @year
+		COLLECT AGGREGATE sum = SUM(a.sum), cnt = SUM(a.count)
+		RETURN { count: cnt != null ? cnt : 0, sum: sum != null ? sum : 0 }
 	)
 	LET lastMonth = (
-		FOR p IN purchases
-		FILTER p.month == @lastMonth AND p.year == @lastYear
-		COLLECT AGGREGATE sum = SUM(to_number(p.sum)), cnt = COUNT(p)
-		RETURN { count: cnt, sum: sum != null ? sum : 0 }
+		FOR a IN aggregates
+		FILTER a.month == @lastMonth AND a.year == @lastYear
+		COLLECT AGGREGATE sum = SUM(a.sum), cnt = SUM(a.count)
+		RETURN { count: cnt != null ? cnt : 0, sum: sum != null ? sum : 0 }
 	)
 	LET allTime = (
-		FOR p IN purchases
-		COLLECT AGGREGATE sum = SUM(to_number(p.sum)), cnt = COUNT(p)
-		RETURN { count: cnt, sum: sum != null ? sum : 0 }
+		FOR a IN aggregates
+		COLLECT AGGREGATE sum = SUM(a.sum), cnt = SUM(a.count)
+		RETURN { count: cnt != null ? cnt : 0, sum: sum != null ? sum : 0 }
 	)
 	RETURN {
 		lastMonth: lastMonth[0],
@@ -49,13 +50,7 @@ func GetOverviewStatistics(month int, year int) (map[string]*CountSumHolder, err
 		allTime: allTime[0]
 	}`
 
-	lastMonth := month - 1
-	lastYear := year
-	if month < 1 {
-		month = 12
-		lastYear -= 1
-	}
-
+	lastMonth, lastYear := previousMonth(month, year)
 	data := map[string]interface{}{"month": month, "year": year, "lastMonth": lastMonth, "lastYear": lastYear}
 	c, err := db.Query(ctx, qry, data)
 	defer c.Close()
@@ -78,23 +73,72 @@ func GetOverviewStatistics(month int, year int) (map[string]*CountSumHolder, err
 	return res, nil
 }
 
-func GetPurchasesUnfiltered() (map[string]interface{}, error) {
+/*
+GetOverviewStatisticsForRange works like GetOverviewStatistics, but for an arbitrary period and the period before it,
+which are read from the daily aggregates.
+*/
+func GetOverviewStatisticsForRange(current DateRange, previous DateRange) (map[string]*CountSumHolder, error) {
 	db, err := GetDb()
 	if err != nil {
 		return nil, err
 	}
 
-	//----
-	// Query database
-
-	qry := `LET years = (
-		FOR p1 IN purchases
-		COLLECT data = p1.year
-		SORT data
-		RETURN data
+	qry := `LET currentPeriod = (
+		FOR a IN daily_aggregates
+		FILTER a.date >= @from AND a.date <= @to
+		COLLECT AGGREGATE sum = SUM(a.sum), cnt = SUM(a.count)
+		RETURN { count: cnt != null ? cnt : 0, sum: sum != null ? sum : 0 }
+	)
+	LET lastPeriod = (
+		FOR a IN daily_aggregates
+		FILTER a.date >= @lastFrom AND a.date <= @lastTo
+		COLLECT AGGREGATE sum = SUM(a.sum), cnt = SUM(a.count)
+		RETURN { count: cnt != null ? cnt : 0, sum: sum != null ? sum : 0 }
+	)
+	LET allTime = (
+		FOR a IN aggregates
+		COLLECT AGGREGATE sum = SUM(a.sum), cnt = SUM(a.count)
+		RETURN { count: cnt != null ? cnt : 0, sum: sum != null ? sum : 0 }
 	)
-	LET purchaselist = (
+	RETURN {
+		lastMonth: lastPeriod[0],
+		currentMonth: currentPeriod[0],
+		allTime: allTime[0]
+	}`
+
+	data := map[string]interface{}{
+		"from":     current.FromDb(),
+		"to":       current.ToDb(),
+		"lastFrom": previous.FromDb(),
+		"lastTo":   previous.ToDb(),
+	}
+	c, err := db.Query(ctx, qry, data)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var res map[string]*CountSumHolder
+	_, err = c.ReadDocument(ctx, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cs := range res {
+		cs.Sum = roundSum(cs.Sum)
+	}
+
+	return res, nil
+}
+
+/*
+GetPurchasesUnfiltered returns one row per purchase between the dates from and to (both inclusive, either may be empty
+for an open range), along with the years they fall in.
+*/
+func GetPurchasesUnfiltered(from string, to string) (map[string]interface{}, error) {
+	qry := `LET purchaselist = (
 		FOR p IN purchases
+		FILTER (@from == "" OR p.date >= @from) AND (@to == "" OR p.date <= @to)
 		RETURN {
 			"month": p.month,
 			"year": p.year,
@@ -105,19 +149,78 @@ func GetPurchasesUnfiltered() (map[string]interface{}, error) {
 	)
 	RETURN {
 		"meta": {
-			"years": years
+			"years": SORTED_UNIQUE(purchaselist[*].year)
+		},
+		"purchases": purchaselist
+	}`
+
+	return queryPurchaseList(qry, map[string]interface{}{"from": from, "to": to})
+}
+
+/*
+GetPurchasesAggregated returns the number and sum of the purchases between the dates from and to (both inclusive, either
+may be empty for an open range) per month, venue and category, along with the years they fall in. Unlike
+GetPurchasesUnfiltered, it is read from the aggregates and every row stands for count purchases.
+*/
+func GetPurchasesAggregated(from string, to string) (map[string]interface{}, error) {
+	qry := `LET purchaselist = (
+		FOR a IN aggregates
+		COLLECT year = a.year, month = a.month, venue = a.venue, category = a.category
+		AGGREGATE cnt = SUM(a.count), sum = SUM(a.sum)
+		RETURN {
+			"month": month,
+			"year": year,
+			"venue": venue,
+			"category": category,
+			"count": cnt,
+			"sum": ROUND(sum * 100) / 100
+		}
+	)
+	RETURN {
+		"meta": {
+			"years": SORTED_UNIQUE(purchaselist[*].year)
 		},
 		"purchases": purchaselist
 	}`
+	data := map[string]interface{}{}
+	if from != "" || to != "" {
+		qry = `LET purchaselist = (
+			FOR a IN daily_aggregates
+			FILTER (@from == "" OR a.date >= @from) AND (@to == "" OR a.date <= @to)
+			COLLECT year = DATE_YEAR(a.date), month = DATE_MONTH(a.date), venue = a.venue, category = a.category
+			AGGREGATE cnt = SUM(a.count), sum = SUM(a.sum)
+			RETURN {
+				"month": month,
+				"year": year,
+				"venue": venue,
+				"category": category,
+				"count": cnt,
+				"sum": ROUND(sum * 100) / 100
+			}
+		)
+		RETURN {
+			"meta": {
+				"years": SORTED_UNIQUE(purchaselist[*].year)
+			},
+			"purchases": purchaselist
+		}`
+		data = map[string]interface{}{"from": from, "to": to}
+	}
+
+	return queryPurchaseList(qry, data)
+}
 
-	c, err := db.Query(ctx, qry, nil)
+func queryPurchaseList(qry string, data map[string]interface{}) (map[string]interface{}, error) {
+	db, err := GetDb()
 	if err != nil {
 		return nil, err
 	}
-	defer c.Close()
 
-	// ----
-	// Read result
+	c, err := db.Query(ctx, qry, data)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
 
 	qryResult := make(map[string]interface{})
 	_, err = c.ReadDocument(ctx, &qryResult)
@@ -127,3 +230,114 @@ func GetPurchasesUnfiltered() (map[string]interface{}, error) {
 
 	return qryResult, nil
 }
+
+type BreakdownEntry struct {
+	Key   string  `json:"key"`
+	Name  string  `json:"name"`
+	Count int     `json:"count"`
+	Sum   float64 `json:"sum"`
+	Share float64 `json:"share"`
+}
+
+type Breakdown struct {
+	By      string           `json:"by"`
+	From    string           `json:"from"`
+	To      string           `json:"to"`
+	Count   int              `json:"count"`
+	Sum     float64          `json:"sum"`
+	Entries []BreakdownEntry `json:"entries"`
+}
+
+var (
+	// Maps the purchase fields statistics can be grouped by to the collection holding their documents.
+	breakdownDimensions = map[string]string{
+		"category": COLLECTION_CATEGORIES,
+		"venue":    COLLECTION_VENUES,
+		"shopper":  COLLECTION_SHOPPERS,
+	}
+)
+
+func IsBreakdownDimension(by string) bool {
+	_, ok := breakdownDimensions[by]
+	return ok
+}
+
+/*
+GetBreakdownStatistics groups the spending between the dates from and to (both inclusive, either may be empty for an
+open range) by category, venue or shopper, reading the daily aggregates. Each entry contains the absolute sum, the
+number of purchases and its share of the total sum.
+*/
+func GetBreakdownStatistics(by string, from string, to string) (*Breakdown, error) {
+	lookup, ok := breakdownDimensions[by]
+	if !ok {
+		return nil, fmt.Errorf("Cannot group statistics by '%s'", by)
+	}
+
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	// ----
+	// Query database
+
+	qry := `LET total = FIRST(
+		FOR a IN daily_aggregates
+		FILTER (@from == "" OR a.date >= @from) AND (@to == "" OR a.date <= @to)
+		COLLECT AGGREGATE sum = SUM(a.sum), cnt = SUM(a.count)
+		RETURN { count: cnt != null ? cnt : 0, sum: sum != null ? sum : 0 }
+	)
+	LET entries = (
+		FOR a IN daily_aggregates
+		FILTER (@from == "" OR a.date >= @from) AND (@to == "" OR a.date <= @to)
+		COLLECT key = a[@field] AGGREGATE sum = SUM(a.sum), cnt = SUM(a.count)
+		SORT sum DESC
+		RETURN {
+			key: key,
+			name: DOCUMENT(@lookup, key).name,
+			count: cnt,
+			sum: sum,
+			share: total.sum > 0 ? sum / total.sum : 0
+		}
+	)
+	RETURN { count: total.count, sum: total.sum, entries: entries }`
+
+	data := map[string]interface{}{"from": from, "to": to, "field": by, "lookup": lookup}
+	c, err := db.Query(ctx, qry, data)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	// ----
+	// Read results
+
+	res := Breakdown{By: by, From: from, To: to}
+	_, err = c.ReadDocument(ctx, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	res.Sum = roundSum(res.Sum)
+	for i := range res.Entries {
+		res.Entries[i].Sum = roundSum(res.Entries[i].Sum)
+		res.Entries[i].Share = math.Round(res.Entries[i].Share*10000) / 10000
+	}
+
+	return &res, nil
+}
+
+/*
+Returns month and year of the month before the provided one, which is December of the previous year for January.
+*/
+func previousMonth(month int, year int) (int, int) {
+	if month <= 1 {
+		return 12, year - 1
+	}
+	return month - 1, year
+}
+
+func roundSum(sum float64) float64 {
+	res, _ := strconv.ParseFloat(fmt.Sprintf("%.2f", sum), 64)
+	return res
+}
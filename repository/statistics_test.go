@@ -0,0 +1,27 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import "testing"
+
+func TestPreviousMonth(t *testing.T) {
+	cases := []struct {
+		month, year       int
+		expMonth, expYear int
+	}{
+		{1, 2026, 12, 2025},
+		{2, 2026, 1, 2026},
+		{12, 2025, 11, 2025},
+		{1, 2000, 12, 1999},
+	}
+	for _, c := range cases {
+		month, year := previousMonth(c.month, c.year)
+		if month != c.expMonth || year != c.expYear {
+			t.Errorf("previousMonth(%d, %d) returns %02d/%d instead of expected %02d/%d",
+				c.month, c.year, month, year, c.expMonth, c.expYear)
+		}
+	}
+}
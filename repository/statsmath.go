@@ -0,0 +1,103 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// Scales the median absolute deviation to be comparable to the standard deviation of normally distributed data.
+	MAD_SCALE = 0.6745
+)
+
+func meanStdDev(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
+
+/*
+Returns the p-th percentile (0-100) of the values using linear interpolation between the closest ranks.
+*/
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+func median(values []float64) float64 {
+	return percentile(values, 50)
+}
+
+/*
+Returns the median absolute deviation of the values from their median.
+*/
+func medianAbsoluteDeviation(values []float64, med float64) float64 {
+	deviations := make([]float64, len(values))
+	for i, v := range values {
+		deviations[i] = math.Abs(v - med)
+	}
+	return median(deviations)
+}
+
+/*
+Returns the mean absolute deviation of the values from their median.
+*/
+func meanAbsoluteDeviation(values []float64, med float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sum := 0.0
+	for _, v := range values {
+		sum += math.Abs(v - med)
+	}
+	return sum / float64(len(values))
+}
+
+/*
+Returns the modified z-score of the value, measuring its distance from the median in multiples of the median absolute
+deviation. If more than half of the values are equal, the MAD is zero and the mean absolute deviation is used instead.
+*/
+func robustScore(value float64, med float64, mad float64, values []float64) float64 {
+	return deviationScore(value, med, mad, meanAbsoluteDeviation(values, med))
+}
+
+/*
+Same as robustScore, with the mean absolute deviation of the values already computed.
+*/
+func deviationScore(value float64, med float64, mad float64, meanAbs float64) float64 {
+	if mad > 0 {
+		return MAD_SCALE * (value - med) / mad
+	}
+	if meanAbs == 0 {
+		return 0
+	}
+	return (value - med) / (1.253314 * meanAbs)
+}
@@ -0,0 +1,262 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	arango "github.com/arangodb/go-driver"
+)
+
+const (
+	GRANULARITY_DAY     = "day"
+	GRANULARITY_WEEK    = "week"
+	GRANULARITY_MONTH   = "month"
+	GRANULARITY_QUARTER = "quarter"
+	GRANULARITY_YEAR    = "year"
+
+	// Time series with more buckets are rejected, e.g. a daily series over decades
+	TIMESERIES_MAX_POINTS = 1000
+)
+
+type TimeSeriesPoint struct {
+	Period        string  `json:"period"`
+	Start         string  `json:"start"`
+	Count         int     `json:"count"`
+	Sum           float64 `json:"sum"`
+	RunningTotal  float64 `json:"running_total"`
+	MovingAverage float64 `json:"moving_average"`
+}
+
+type TimeSeries struct {
+	Granularity string            `json:"granularity"`
+	From        string            `json:"from"`
+	To          string            `json:"to"`
+	Window      int               `json:"window"`
+	Points      []TimeSeriesPoint `json:"points"`
+}
+
+/*
+Holds the optional category, venue and shopper keys statistics are restricted to. Empty values do not filter.
+*/
+type StatisticsFilter struct {
+	Category string
+	Venue    string
+	Shopper  string
+}
+
+func IsGranularity(granularity string) bool {
+	switch granularity {
+	case GRANULARITY_DAY, GRANULARITY_WEEK, GRANULARITY_MONTH, GRANULARITY_QUARTER, GRANULARITY_YEAR:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+GetTimeSeriesStatistics returns the spending between from and to (both inclusive) bucketed by the provided granularity.
+Buckets without purchases are included with a sum of zero. The moving average is calculated over the given number of
+buckets, ending with the current one.
+*/
+func GetTimeSeriesStatistics(granularity string, from time.Time, to time.Time, filter StatisticsFilter, window int) (*TimeSeries, error) {
+	if !IsGranularity(granularity) {
+		return nil, fmt.Errorf("Invalid granularity '%s'", granularity)
+	}
+	if to.Before(from) {
+		return nil, fmt.Errorf("End of range must not be before its start")
+	}
+	if n := CountBuckets(from, to, granularity); n > TIMESERIES_MAX_POINTS {
+		return nil, fmt.Errorf("Range spans %d buckets, more than the maximum of %d", n, TIMESERIES_MAX_POINTS)
+	}
+	if window < 1 {
+		window = 1
+	}
+
+	daily, err := getDailySums(from, to, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TimeSeries{
+		Granularity: granularity,
+		From:        DateToDb(from),
+		To:          DateToDb(to),
+		Window:      window,
+		Points:      buildTimeSeries(daily, from, to, granularity, window),
+	}, nil
+}
+
+/*
+Returns the number and sum of all purchases per date between from and to (both inclusive) matching the filter, read
+from the daily aggregates.
+*/
+func getDailySums(from time.Time, to time.Time, filter StatisticsFilter) (map[string]CountSumHolder, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	// ----
+	// Query database
+
+	qry := `FOR a IN daily_aggregates
+		FILTER a.date >= @from AND a.date <= @to
+		FILTER @category == "" OR a.category == @category
+		FILTER @venue == "" OR a.venue == @venue
+		FILTER @shopper == "" OR a.shopper == @shopper
+		COLLECT date = a.date AGGREGATE sum = SUM(a.sum), cnt = SUM(a.count)
+		RETURN { date: date, count: cnt, sum: sum }`
+
+	data := map[string]interface{}{
+		"from":     DateToDb(from),
+		"to":       DateToDb(to),
+		"category": filter.Category,
+		"venue":    filter.Venue,
+		"shopper":  filter.Shopper,
+	}
+	c, err := db.Query(ctx, qry, data)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	daily := make(map[string]CountSumHolder)
+	for {
+		var d struct {
+			Date string `json:"date"`
+			CountSumHolder
+		}
+		_, err := c.ReadDocument(ctx, &d)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		daily[d.Date] = d.CountSumHolder
+	}
+
+	return daily, nil
+}
+
+func buildTimeSeries(daily map[string]CountSumHolder, from time.Time, to time.Time, granularity string, window int) []TimeSeriesPoint {
+	points := make([]TimeSeriesPoint, 0)
+	index := make(map[string]int)
+	for start := bucketStart(from, granularity); !start.After(to); start = nextBucket(start, granularity) {
+		index[DateToDb(start)] = len(points)
+		points = append(points, TimeSeriesPoint{Period: bucketLabel(start, granularity), Start: DateToDb(start)})
+	}
+
+	for date, cs := range daily {
+		d, err := DateFromDb(date)
+		if err != nil {
+			continue
+		}
+		i, ok := index[DateToDb(bucketStart(d, granularity))]
+		if !ok {
+			continue
+		}
+		points[i].Count += cs.Count
+		points[i].Sum += cs.Sum
+	}
+
+	running := 0.0
+	for i := range points {
+		running += points[i].Sum
+		points[i].Sum = roundSum(points[i].Sum)
+		points[i].RunningTotal = roundSum(running)
+
+		first := i - window + 1
+		if first < 0 {
+			first = 0
+		}
+		windowSum := 0.0
+		for _, p := range points[first : i+1] {
+			windowSum += p.Sum
+		}
+		points[i].MovingAverage = roundSum(windowSum / float64(i-first+1))
+	}
+
+	return points
+}
+
+/*
+Returns the first day of the bucket the provided date belongs to. Weeks start on monday.
+*/
+func bucketStart(t time.Time, granularity string) time.Time {
+	y, m, d := t.Date()
+	switch granularity {
+	case GRANULARITY_WEEK:
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, time.UTC)
+	case GRANULARITY_MONTH:
+		return time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+	case GRANULARITY_QUARTER:
+		return time.Date(y, m-(m-1)%3, 1, 0, 0, 0, 0, time.UTC)
+	case GRANULARITY_YEAR:
+		return time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+/*
+CountBuckets returns the number of buckets of the provided granularity between from and to (both inclusive).
+*/
+func CountBuckets(from time.Time, to time.Time, granularity string) int {
+	first, last := bucketStart(from, granularity), bucketStart(to, granularity)
+	if last.Before(first) {
+		return 0
+	}
+
+	months := (last.Year()-first.Year())*12 + int(last.Month()) - int(first.Month())
+	switch granularity {
+	case GRANULARITY_WEEK:
+		return int(last.Sub(first).Hours()/24)/7 + 1
+	case GRANULARITY_MONTH:
+		return months + 1
+	case GRANULARITY_QUARTER:
+		return months/3 + 1
+	case GRANULARITY_YEAR:
+		return last.Year() - first.Year() + 1
+	default:
+		return int(last.Sub(first).Hours()/24) + 1
+	}
+}
+
+func nextBucket(start time.Time, granularity string) time.Time {
+	switch granularity {
+	case GRANULARITY_WEEK:
+		return start.AddDate(0, 0, 7)
+	case GRANULARITY_MONTH:
+		return start.AddDate(0, 1, 0)
+	case GRANULARITY_QUARTER:
+		return start.AddDate(0, 3, 0)
+	case GRANULARITY_YEAR:
+		return start.AddDate(1, 0, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+func bucketLabel(start time.Time, granularity string) string {
+	switch granularity {
+	case GRANULARITY_WEEK:
+		year, week := start.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case GRANULARITY_MONTH:
+		return start.Format("2006-01")
+	case GRANULARITY_QUARTER:
+		return fmt.Sprintf("%d-Q%d", start.Year(), (int(start.Month())-1)/3+1)
+	case GRANULARITY_YEAR:
+		return start.Format("2006")
+	default:
+		return DateToDb(start)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketStart(t *testing.T) {
+	d := time.Date(2026, 8, 13, 0, 0, 0, 0, time.UTC) // a thursday
+	expected := map[string]string{
+		GRANULARITY_DAY:     "2026-08-13",
+		GRANULARITY_WEEK:    "2026-08-10",
+		GRANULARITY_MONTH:   "2026-08-01",
+		GRANULARITY_QUARTER: "2026-07-01",
+		GRANULARITY_YEAR:    "2026-01-01",
+	}
+	for granularity, exp := range expected {
+		res := DateToDb(bucketStart(d, granularity))
+		if res != exp {
+			t.Errorf("%s bucket returns '%s' instead of expected '%s'", granularity, res, exp)
+		}
+	}
+}
+
+func TestBuildTimeSeries(t *testing.T) {
+	daily := map[string]CountSumHolder{
+		"2026-01-05": {Count: 1, Sum: 10},
+		"2026-01-20": {Count: 2, Sum: 20},
+		"2026-03-02": {Count: 1, Sum: 60},
+	}
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC)
+
+	points := buildTimeSeries(daily, from, to, GRANULARITY_MONTH, 2)
+	if len(points) != 3 {
+		t.Fatalf("Returns %d points instead of expected 3", len(points))
+	}
+	if points[0].Period != "2026-01" || points[0].Sum != 30 || points[0].Count != 3 {
+		t.Errorf("January returns %+v", points[0])
+	}
+	if points[1].Sum != 0 || points[1].MovingAverage != 15 {
+		t.Errorf("Empty february is not zero-filled: %+v", points[1])
+	}
+	if points[2].RunningTotal != 90 || points[2].MovingAverage != 30 {
+		t.Errorf("March returns %+v", points[2])
+	}
+}
+
+func TestCountBuckets(t *testing.T) {
+	from := time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC) // a wednesday
+	to := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)     // a monday
+	expected := map[string]int{
+		GRANULARITY_DAY:     62,
+		GRANULARITY_WEEK:    10,
+		GRANULARITY_MONTH:   4,
+		GRANULARITY_QUARTER: 2,
+		GRANULARITY_YEAR:    2,
+	}
+	for granularity, exp := range expected {
+		if n := CountBuckets(from, to, granularity); n != exp {
+			t.Errorf("%s returns %d buckets instead of expected %d", granularity, n, exp)
+		}
+		if n := len(buildTimeSeries(nil, from, to, granularity, 1)); n != exp {
+			t.Errorf("%s builds %d points instead of expected %d", granularity, n, exp)
+		}
+	}
+
+	if n := CountBuckets(to, from, GRANULARITY_DAY); n != 0 {
+		t.Errorf("Reversed range returns %d buckets instead of expected 0", n)
+	}
+	decades := CountBuckets(time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC), to, GRANULARITY_DAY)
+	if decades <= TIMESERIES_MAX_POINTS {
+		t.Errorf("Daily buckets over decades return %d, not more than the maximum", decades)
+	}
+}
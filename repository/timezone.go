@@ -0,0 +1,113 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mandrakey/shoptrac/config"
+)
+
+/*
+Dates of purchases, income and the like are stored as local dates of the household (or the user who entered them)
+without a time zone. Points in time, like the expiry of sessions, are stored in UTC.
+*/
+var (
+	locations   = make(map[string]*time.Location)
+	locationsMu sync.Mutex
+)
+
+/*
+Location returns the configured household time zone, or the time zone of the server if none is configured.
+*/
+func Location() *time.Location {
+	name := config.GetAppConfig().TimeZone
+	if name == "" {
+		return time.Local
+	}
+
+	loc, err := LoadLocation(name)
+	if err != nil {
+		config.Logger().Warningf("Invalid household time zone, using server time zone: %s", err)
+		return time.Local
+	}
+	return loc
+}
+
+/*
+UserLocation returns the time zone of the user, which defaults to the household time zone.
+*/
+func UserLocation(u *User) *time.Location {
+	if u == nil || u.TimeZone == "" {
+		return Location()
+	}
+
+	loc, err := LoadLocation(u.TimeZone)
+	if err != nil {
+		return Location()
+	}
+	return loc
+}
+
+/*
+LoadLocation returns the time zone with the provided IANA name, e.g. "Europe/Berlin".
+*/
+func LoadLocation(name string) (*time.Location, error) {
+	locationsMu.Lock()
+	defer locationsMu.Unlock()
+
+	if loc, ok := locations[name]; ok {
+		return loc, nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("Unknown time zone '%s'", name)
+	}
+	locations[name] = loc
+	return loc, nil
+}
+
+/*
+LocalDate returns the date t falls on in the provided time zone, as midnight UTC like all dates read by DateFromDb.
+*/
+func LocalDate(t time.Time, loc *time.Location) time.Time {
+	local := t.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+/*
+Today returns the current date of the household.
+*/
+func Today() time.Time {
+	return LocalDate(time.Now(), Location())
+}
+
+/*
+ParseLocalDate accepts either a plain date, which is taken as is, or an RFC 3339 timestamp, which is converted to the date
+it falls on in the provided time zone.
+*/
+func ParseLocalDate(value string, loc *time.Location) (time.Time, error) {
+	d, err := DateFromDb(value)
+	if err == nil {
+		return d, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Date '%s' is neither a date nor a timestamp", value)
+	}
+	return LocalDate(t, loc), nil
+}
+
+/*
+Returns the current time in the format of stored points in time, to be compared in queries.
+*/
+func dbNow() string {
+	return time.Now().UTC().Format(DB_TIME_FORMAT)
+}
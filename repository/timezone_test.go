@@ -0,0 +1,74 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLocalDate(t *testing.T) {
+	berlin, err := LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("Time zone data not available: %s", err)
+	}
+
+	// Shortly after midnight in Berlin, still the previous day in UTC
+	ts := time.Date(2026, 3, 31, 22, 30, 0, 0, time.UTC)
+	if d := DateToDb(LocalDate(ts, berlin)); d != "2026-04-01" {
+		t.Errorf("LocalDate returns %s instead of expected 2026-04-01", d)
+	}
+	if d := DateToDb(LocalDate(ts, time.UTC)); d != "2026-03-31" {
+		t.Errorf("LocalDate returns %s instead of expected 2026-03-31", d)
+	}
+	if loc := LocalDate(ts, berlin).Location(); loc != time.UTC {
+		t.Errorf("LocalDate returns date in %s instead of expected UTC", loc)
+	}
+}
+
+func TestParseLocalDate(t *testing.T) {
+	berlin, err := LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("Time zone data not available: %s", err)
+	}
+
+	tests := map[string]string{
+		"2026-04-01":                "2026-04-01",
+		"2026-03-31T22:30:00Z":      "2026-04-01",
+		"2026-04-01T00:30:00+02:00": "2026-04-01",
+		"2026-03-31T23:30:00-04:00": "2026-04-01",
+		"2026-03-31T21:59:59Z":      "2026-03-31",
+	}
+	for value, expected := range tests {
+		d, err := ParseLocalDate(value, berlin)
+		if err != nil {
+			t.Errorf("ParseLocalDate returns error for '%s': %s", value, err)
+		} else if DateToDb(d) != expected {
+			t.Errorf("ParseLocalDate returns %s instead of expected %s for '%s'", DateToDb(d), expected, value)
+		}
+	}
+
+	if _, err := ParseLocalDate("01.04.2026", berlin); err == nil {
+		t.Error("ParseLocalDate returns no error for invalid date")
+	}
+}
+
+func TestUserLocation(t *testing.T) {
+	tokyo, err := LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("Time zone data not available: %s", err)
+	}
+
+	if loc := UserLocation(&User{TimeZone: "Asia/Tokyo"}); loc != tokyo {
+		t.Errorf("UserLocation returns %s instead of expected Asia/Tokyo", loc)
+	}
+	if loc := UserLocation(&User{}); loc != Location() {
+		t.Errorf("UserLocation returns %s instead of expected household time zone", loc)
+	}
+	if loc := UserLocation(&User{TimeZone: "Mars/Olympus"}); loc != Location() {
+		t.Errorf("UserLocation returns %s instead of expected household time zone for invalid time zone", loc)
+	}
+}
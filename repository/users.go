@@ -22,7 +22,8 @@ const (
 )
 
 var (
-	rxUuid *regexp.Regexp = regexp.MustCompile("^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$")
+	rxUuid  *regexp.Regexp = regexp.MustCompile("^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$")
+	rxEmail *regexp.Regexp = regexp.MustCompile("^[^@\\s]+@[^@\\s]+$")
 )
 
 type User struct {
@@ -31,6 +32,9 @@ type User struct {
 	Email    string `json:"email"`
 	Name     string `json:"name"`
 	Level    int    `json:"level"`
+	TimeZone string `json:"time_zone,omitempty"`
+
+	Reports []ReportSubscription `json:"reports,omitempty"`
 }
 
 func NewUser() *User {
@@ -317,6 +321,30 @@ func UserUpdatePassword(key string, newpass string) error {
 	return err
 }
 
+/*
+Sets the time zone of the user identified by the provided key. An empty time zone resets it to the household time zone.
+*/
+func UserUpdateTimeZone(key string, timeZone string) error {
+	if timeZone != "" {
+		_, err := LoadLocation(timeZone)
+		if err != nil {
+			return err
+		}
+	}
+
+	col, err := GetCollection(COLLECTION_USERS)
+	if err != nil {
+		return err
+	}
+
+	_, err = col.UpdateDocument(
+		ctx,
+		key,
+		map[string]interface{}{"time_zone": timeZone},
+	)
+	return err
+}
+
 func validateUser(u *User, forUpdate bool) error {
 	if u.Key == "" {
 		return fmt.Errorf("Missing user key")
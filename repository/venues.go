@@ -54,6 +54,21 @@ func GetVenues() (*[]Venue, error) {
 	return &res, nil
 }
 
+func GetVenue(key string) (*Venue, error) {
+	col, err := GetCollection(COLLECTION_VENUES)
+	if err != nil {
+		return nil, err
+	}
+
+	var v Venue
+	_, err = col.ReadDocument(ctx, key, &v)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v, nil
+}
+
 func AddVenue(name string, image string) (*Venue, error) {
 	col, err := GetCollection(COLLECTION_VENUES)
 	if err != nil {
@@ -0,0 +1,153 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	arango "github.com/arangodb/go-driver"
+)
+
+/*
+VenueStatistics describes the basket size and shopping frequency of a single venue. Every purchase counts as one visit.
+*/
+type VenueStatistics struct {
+	Venue              Venue   `json:"venue"`
+	Visits             int     `json:"visits"`
+	Total              float64 `json:"total"`
+	Average            float64 `json:"average"`
+	Median             float64 `json:"median"`
+	P25                float64 `json:"p25"`
+	P75                float64 `json:"p75"`
+	P90                float64 `json:"p90"`
+	VisitsPerMonth     float64 `json:"visits_per_month"`
+	Weekdays           [7]int  `json:"weekdays"`
+	TypicalWeekday     string  `json:"typical_weekday"`
+	FirstVisit         string  `json:"first_visit"`
+	LastVisit          string  `json:"last_visit"`
+	DaysSinceLastVisit *int    `json:"days_since_last_visit"`
+}
+
+type venuePurchase struct {
+	Date string  `json:"date"`
+	Sum  float64 `json:"sum"`
+}
+
+/*
+GetVenueStatistics returns the statistics of the venue for the purchases between the dates from and to (both inclusive,
+either may be empty for an open range).
+*/
+func GetVenueStatistics(venue Venue, from string, to string) (*VenueStatistics, error) {
+	db, err := GetDb()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := db.Query(
+		ctx,
+		`FOR p IN purchases
+		FILTER p.venue == @venue AND (@from == "" OR p.date >= @from) AND (@to == "" OR p.date <= @to)
+		SORT p.date
+		RETURN { date: p.date, sum: TO_NUMBER(p.sum) }`,
+		map[string]interface{}{"venue": venue.Key, "from": from, "to": to},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	purchases := make([]venuePurchase, 0)
+	for {
+		var p venuePurchase
+		_, err := c.ReadDocument(ctx, &p)
+
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		purchases = append(purchases, p)
+	}
+
+	// Visits per month are counted over the range, but not beyond the current date
+	var since time.Time
+	if from != "" {
+		since, _ = DateFromDb(from)
+	}
+	now := Today()
+	until := now
+	if to != "" && to < DateToDb(now) {
+		until, _ = DateFromDb(to)
+	}
+
+	return buildVenueStatistics(venue, purchases, since, until, now)
+}
+
+/*
+Builds the statistics from the purchases of a venue, which must be sorted by date. Visits per month are averaged over
+all months from since, or the first visit if since is zero, up to and including the month of until. Days since the last
+visit are counted up to now.
+*/
+func buildVenueStatistics(venue Venue, purchases []venuePurchase, since time.Time, until time.Time, now time.Time) (*VenueStatistics, error) {
+	res := VenueStatistics{Venue: venue}
+	if len(purchases) == 0 {
+		return &res, nil
+	}
+
+	sums := make([]float64, len(purchases))
+	for i, p := range purchases {
+		date, err := DateFromDb(p.Date)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid purchase date '%s': %s", p.Date, err)
+		}
+		res.Weekdays[date.Weekday()]++
+
+		sums[i] = p.Sum
+		res.Total += p.Sum
+	}
+
+	res.Visits = len(purchases)
+	res.Average = roundSum(res.Total / float64(res.Visits))
+	res.Total = roundSum(res.Total)
+	res.Median = roundSum(median(sums))
+	res.P25 = roundSum(percentile(sums, 25))
+	res.P75 = roundSum(percentile(sums, 75))
+	res.P90 = roundSum(percentile(sums, 90))
+
+	typical := 0
+	for day, count := range res.Weekdays {
+		if count > res.Weekdays[typical] {
+			typical = day
+		}
+	}
+	res.TypicalWeekday = time.Weekday(typical).String()
+
+	// ----
+	// Frequency
+
+	res.FirstVisit = purchases[0].Date
+	res.LastVisit = purchases[len(purchases)-1].Date
+
+	first, _ := DateFromDb(res.FirstVisit)
+	last, _ := DateFromDb(res.LastVisit)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if !since.IsZero() {
+		first = since
+	}
+
+	months := (until.Year()-first.Year())*12 + int(until.Month()) - int(first.Month()) + 1
+	if months < 1 {
+		months = 1
+	}
+	res.VisitsPerMonth = roundSum(float64(res.Visits) / float64(months))
+
+	days := int(today.Sub(last).Hours() / 24)
+	res.DaysSinceLastVisit = &days
+
+	return &res, nil
+}
@@ -0,0 +1,67 @@
+/*
+SPDX-FileCopyrightText: Maurice Bleuel <mandrakey@litir.de>
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildVenueStatistics(t *testing.T) {
+	purchases := []venuePurchase{
+		{Date: "2026-01-03", Sum: 10},
+		{Date: "2026-01-10", Sum: 20},
+		{Date: "2026-02-07", Sum: 30},
+		{Date: "2026-03-04", Sum: 40},
+	}
+	now := time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC)
+
+	s, err := buildVenueStatistics(Venue{Key: "1", Name: "Market"}, purchases, time.Time{}, now, now)
+	if err != nil {
+		t.Fatalf("buildVenueStatistics returns error: %s", err)
+	}
+	if s.Visits != 4 || s.Total != 100 || s.Average != 25 || s.Median != 25 {
+		t.Errorf("Returns visits %d, total %f, average %f and median %f", s.Visits, s.Total, s.Average, s.Median)
+	}
+	if s.P25 != 17.5 || s.P90 != 37 {
+		t.Errorf("Returns percentiles %f and %f instead of expected 17.5 and 37", s.P25, s.P90)
+	}
+	if s.TypicalWeekday != "Saturday" {
+		t.Errorf("Returns typical weekday %s instead of expected Saturday", s.TypicalWeekday)
+	}
+	if s.VisitsPerMonth != 1.33 {
+		t.Errorf("Returns %f visits per month instead of expected 1.33", s.VisitsPerMonth)
+	}
+	if s.DaysSinceLastVisit == nil || *s.DaysSinceLastVisit != 10 {
+		t.Errorf("Returns %v days since last visit instead of expected 10", s.DaysSinceLastVisit)
+	}
+
+	s, err = buildVenueStatistics(Venue{Key: "2"}, nil, time.Time{}, now, now)
+	if err != nil || s.Visits != 0 || s.DaysSinceLastVisit != nil {
+		t.Errorf("Returns %+v for a venue without purchases", s)
+	}
+}
+
+func TestBuildVenueStatisticsForPeriod(t *testing.T) {
+	purchases := []venuePurchase{
+		{Date: "2025-12-06", Sum: 10},
+		{Date: "2025-12-20", Sum: 20},
+	}
+	since := time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC)
+
+	s, err := buildVenueStatistics(Venue{Key: "1"}, purchases, since, until, now)
+	if err != nil {
+		t.Fatalf("buildVenueStatistics returns error: %s", err)
+	}
+	if s.VisitsPerMonth != 0.67 {
+		t.Errorf("Returns %f visits per month instead of expected 0.67", s.VisitsPerMonth)
+	}
+	if s.DaysSinceLastVisit == nil || *s.DaysSinceLastVisit != 84 {
+		t.Errorf("Returns %v days since last visit instead of expected 84", s.DaysSinceLastVisit)
+	}
+}
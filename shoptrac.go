@@ -9,13 +9,19 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/urfave/cli"
 	"gopkg.in/macaron.v1"
 
+	"github.com/mandrakey/shoptrac/backup"
 	"github.com/mandrakey/shoptrac/config"
 	"github.com/mandrakey/shoptrac/handler"
+	"github.com/mandrakey/shoptrac/importer"
 	"github.com/mandrakey/shoptrac/middleware"
+	"github.com/mandrakey/shoptrac/notification"
+	"github.com/mandrakey/shoptrac/report"
 	"github.com/mandrakey/shoptrac/repository"
 )
 
@@ -53,6 +59,51 @@ func main() {
 				},
 			},
 		},
+		{
+			Name:   "rebuild-aggregates",
+			Usage:  "recalculate the monthly and daily purchase aggregates used for statistics",
+			Action: runRebuildAggregates,
+		},
+		{
+			Name:      "import-purchases",
+			Usage:     "import purchases from a CSV file",
+			ArgsUsage: "FILE",
+			Action:    runImportPurchases,
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "date-column", Value: "date", Usage: "read dates from column NAME"},
+				cli.StringFlag{Name: "sum-column", Value: "sum", Usage: "read sums from column NAME"},
+				cli.StringFlag{Name: "category-column", Usage: "read category names from column NAME"},
+				cli.StringFlag{Name: "venue-column", Usage: "read venue names from column NAME"},
+				cli.StringFlag{Name: "shopper-column", Usage: "read shopper names from column NAME"},
+				cli.StringFlag{Name: "category", Usage: "use category NAME for rows without one"},
+				cli.StringFlag{Name: "venue", Usage: "use venue NAME for rows without one"},
+				cli.StringFlag{Name: "shopper", Usage: "use shopper NAME for rows without one"},
+				cli.StringFlag{Name: "delimiter", Value: ",", Usage: "columns are separated by CHAR"},
+				cli.StringFlag{Name: "date-format", Value: importer.DEFAULT_DATE_FORMAT, Usage: "dates are written like FORMAT, using YYYY, YY, MM, M, DD and D"},
+				cli.StringFlag{Name: "decimal-separator", Value: ".", Usage: "decimals are separated by CHAR"},
+				cli.StringFlag{Name: "thousands-separator", Usage: "thousands are separated by CHAR"},
+				cli.BoolFlag{Name: "dry-run", Usage: "only validate the file, do not import anything"},
+			},
+		},
+		{
+			Name:      "backup",
+			Usage:     "write a backup of all collections into a compressed archive",
+			ArgsUsage: "FILE",
+			Action:    runBackup,
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "sessions", Usage: "include the sessions of logged in users"},
+				cli.BoolFlag{Name: "images", Usage: "include the files of the image directory"},
+			},
+		},
+		{
+			Name:      "restore",
+			Usage:     "restore a backup into an empty database and migrate it to the current version",
+			ArgsUsage: "FILE",
+			Action:    runRestore,
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "force", Usage: "overwrite the collections of the backup even if they contain documents"},
+			},
+		},
 	}
 
 	// Run
@@ -63,14 +114,10 @@ func main() {
 }
 
 func runServe(ctx *cli.Context) error {
-	configFile := ctx.GlobalString("config")
-
-	cfg := config.GetAppConfig()
-	err := cfg.LoadFromFile(configFile)
+	cfg, err := loadConfig(ctx)
 	if err != nil {
-		return fmt.Errorf("Failed to load configuration: %s", err)
+		return err
 	}
-	config.SetupLogging(cfg.Logfile, cfg.Loglevel)
 	log := config.Logger()
 
 	// Run migrations
@@ -81,6 +128,11 @@ func runServe(ctx *cli.Context) error {
 		return err
 	}
 
+	notification.SetupChannels(cfg)
+
+	go runPeriodically(time.Hour, "anomaly detection", repository.RefreshAnomalies)
+	go runPeriodically(time.Hour, "report delivery", report.DeliverScheduled)
+
 	// Create server and set routing
 	m := macaron.Classic()
 	m.Use(config.IpFilterer(cfg))
@@ -120,6 +172,7 @@ func runServe(ctx *cli.Context) error {
 			m.Options("/*", handler.OptionsShoppers)
 		})
 		m.Group("/purchases", func() {
+			m.Get("/", handler.GetPurchases)
 			m.Get("/:year(\\d{4})/:month(\\d{1,2})", handler.GetPurchases)
 			m.Put("/", handler.PutPurchase)
 			m.Post("/:key", handler.PostPurchase)
@@ -130,11 +183,112 @@ func runServe(ctx *cli.Context) error {
 			m.Options("/", handler.OptionsPurchase)
 			m.Options("/*", handler.OptionsPurchase)
 		})
+		m.Group("/images", func() {
+			m.Put("/", handler.PutImage)
+			m.Get("/:key", handler.GetImage)
+			m.Get("/:key/thumbnail", handler.GetImageThumbnail)
+
+			m.Options("/", handler.OptionsImage)
+			m.Options("/*", handler.OptionsImage)
+		})
+		m.Group("/inventory", func() {
+			m.Get("/", handler.GetInventory)
+			m.Get("/lowstock", handler.GetInventoryLowStock)
+			m.Get("/expiring", handler.GetInventoryExpiring)
+			m.Get("/:key/rate", handler.GetInventoryRate)
+			m.Put("/", handler.PutInventory)
+			m.Post("/:key", handler.PostInventory)
+			m.Post("/:key/consume", handler.PostInventoryConsume)
+			m.Delete("/:key", handler.DeleteInventory)
+
+			m.Options("/", handler.OptionsInventory)
+			m.Options("/*", handler.OptionsInventory)
+		})
+		m.Group("/budgets", func() {
+			m.Get("/", handler.GetBudgets)
+			m.Get("/report", handler.GetBudgetReport)
+			m.Get("/:year(\\d{4})/:month(\\d{1,2})", handler.GetBudgetReport)
+			m.Put("/", handler.PutBudget)
+			m.Post("/:key", handler.PostBudget)
+			m.Delete("/:key", handler.DeleteBudget)
+
+			m.Options("/", handler.OptionsBudget)
+			m.Options("/*", handler.OptionsBudget)
+		})
+		m.Group("/alerts", func() {
+			m.Get("/", handler.GetAlerts)
+			m.Put("/", handler.PutAlert)
+			m.Post("/:key/test", handler.PostAlertTest)
+			m.Delete("/:key", handler.DeleteAlert)
+
+			m.Options("/", handler.OptionsAlerts)
+			m.Options("/*", handler.OptionsAlerts)
+		})
+		m.Group("/income", func() {
+			m.Get("/", handler.GetIncome)
+			m.Put("/", handler.PutIncome)
+			m.Post("/:key", handler.PostIncome)
+			m.Delete("/:key", handler.DeleteIncome)
+
+			m.Options("/", handler.OptionsIncome)
+			m.Options("/*", handler.OptionsIncome)
+		})
+		m.Group("/goals", func() {
+			m.Get("/", handler.GetGoals)
+			m.Put("/", handler.PutGoal)
+			m.Post("/:key", handler.PostGoal)
+			m.Delete("/:key", handler.DeleteGoal)
+			m.Get("/:key/progress", handler.GetGoalProgress)
+			m.Get("/:key/contributions", handler.GetGoalContributions)
+			m.Put("/:key/contributions", handler.PutGoalContribution)
+			m.Delete("/:key/contributions/:contribution", handler.DeleteGoalContribution)
+
+			m.Options("/", handler.OptionsGoals)
+			m.Options("/*", handler.OptionsGoals)
+		})
 		m.Group("/statistics", func() {
+			m.Get("/overview", handler.GetOverviewStatistics)
 			m.Get("/overview/:year(\\d{4})/:month(\\d{1,2})", handler.GetOverviewStatistics)
 			m.Get("/purchases_unfiltered", handler.GetPurchasesUnfiltered)
+			m.Get("/purchases_aggregated", handler.GetPurchasesAggregated)
+			m.Get("/breakdown", handler.GetBreakdownStatistics)
+			m.Get("/timeseries", handler.GetTimeSeriesStatistics)
+			m.Get("/compare", handler.GetComparisonStatistics)
+			m.Get("/forecast", handler.GetForecast)
+			m.Get("/forecast/:year(\\d{4})/:month(\\d{1,2})", handler.GetForecast)
+			m.Get("/heatmap", handler.GetHeatmapStatistics)
+			m.Get("/heatmap/:year(\\d{4})", handler.GetHeatmapStatistics)
+			m.Get("/cashflow", handler.GetCashFlowStatistics)
+			m.Post("/query", handler.PostStatisticsQuery)
+			m.Get("/anomalies", handler.GetAnomalies)
+			m.Get("/venues/:key", handler.GetVenueStatistics)
 			m.Options("/*", handler.OptionsStatistics)
 		})
+		m.Group("/export", func() {
+			m.Get("/purchases", handler.GetPurchasesExport)
+			m.Get("/purchases.csv", handler.GetPurchasesCsvExport)
+			m.Get("/ledger", handler.GetPurchasesLedgerExport)
+
+			m.Options("/*", handler.OptionsExport)
+		})
+		m.Group("/import", func() {
+			m.Post("/purchases", handler.PostImportPurchases)
+			m.Post("/statements", handler.PostImportStatement)
+			m.Get("/candidates", handler.GetImportCandidates)
+			m.Post("/candidates/:key", handler.PostImportCandidate)
+			m.Post("/candidates/:key/confirm", handler.PostImportCandidateConfirm)
+			m.Delete("/candidates/:key", handler.DeleteImportCandidate)
+			m.Get("/rules", handler.GetVenueRules)
+			m.Put("/rules", handler.PutVenueRule)
+			m.Delete("/rules/:key", handler.DeleteVenueRule)
+
+			m.Options("/*", handler.OptionsImport)
+		})
+		m.Group("/reports", func() {
+			m.Get("/monthly/:year(\\d{4})/:month(\\d{1,2}).pdf", handler.GetMonthlyReportPdf)
+
+			m.Options("/*", handler.OptionsReports)
+		})
 		m.Group("/auth", func() {
 			m.Get("/logout", handler.GetLogout)
 			m.Post("/login", handler.PostLogin)
@@ -154,6 +308,10 @@ func runServe(ctx *cli.Context) error {
 			m.Get("/", handler.GetProfile)
 			m.Patch("/", handler.PatchProfile)
 			m.Post("/updatePassword", handler.PostProfileUpdatePassword)
+			m.Get("/reports", handler.GetProfileReports)
+			m.Put("/reports", handler.PutProfileReport)
+			m.Get("/reports/deliveries", handler.GetProfileReportDeliveries)
+			m.Delete("/reports/:key", handler.DeleteProfileReport)
 			m.Options("/", handler.OptionsProfile)
 			m.Options("/*", handler.OptionsProfile)
 		})
@@ -165,3 +323,215 @@ func runServe(ctx *cli.Context) error {
 
 	return nil
 }
+
+/*
+Runs the provided job right away and then again after every interval. Failures are logged only.
+*/
+func runPeriodically(interval time.Duration, name string, job func() error) {
+	log := config.Logger()
+
+	for {
+		err := job()
+		if err != nil {
+			log.Errorf("Failed to run %s: %s", name, err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func runRebuildAggregates(ctx *cli.Context) error {
+	_, err := loadConfig(ctx)
+	if err != nil {
+		return err
+	}
+	log := config.Logger()
+
+	err = repository.RunMigrations()
+	if err != nil {
+		err = fmt.Errorf("Failed to migrate database: %s", err)
+		log.Error(err)
+		return err
+	}
+
+	err = repository.RebuildAggregates()
+	if err != nil {
+		err = fmt.Errorf("Failed to rebuild aggregates: %s", err)
+		log.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+func runImportPurchases(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return fmt.Errorf("Expected the CSV file to import as argument")
+	}
+
+	cfg, err := loadConfig(ctx)
+	if err != nil {
+		return err
+	}
+	log := config.Logger()
+
+	err = repository.RunMigrations()
+	if err != nil {
+		err = fmt.Errorf("Failed to migrate database: %s", err)
+		log.Error(err)
+		return err
+	}
+
+	f, err := os.Open(ctx.Args().First())
+	if err != nil {
+		return fmt.Errorf("Failed to open import file: %s", err)
+	}
+	defer f.Close()
+
+	opts := importer.CsvOptions{
+		Mapping: importer.CsvMapping{
+			Date:     ctx.String("date-column"),
+			Sum:      ctx.String("sum-column"),
+			Category: ctx.String("category-column"),
+			Venue:    ctx.String("venue-column"),
+			Shopper:  ctx.String("shopper-column"),
+		},
+		Defaults: importer.CsvDefaults{
+			Category: ctx.String("category"),
+			Venue:    ctx.String("venue"),
+			Shopper:  ctx.String("shopper"),
+		},
+		Delimiter:          ctx.String("delimiter"),
+		DateFormat:         ctx.String("date-format"),
+		DecimalSeparator:   ctx.String("decimal-separator"),
+		ThousandsSeparator: ctx.String("thousands-separator"),
+		DryRun:             ctx.Bool("dry-run"),
+	}
+
+	res, err := importer.ImportCsv(f, opts)
+	if err != nil {
+		return fmt.Errorf("Failed to import purchases: %s", err)
+	}
+
+	for _, e := range res.Errors {
+		fmt.Printf("Row %d: %s\n", e.Line, e.Message)
+	}
+	printNames := func(kind string, names []string) {
+		if len(names) > 0 {
+			fmt.Printf("New %s: %s\n", kind, strings.Join(names, ", "))
+		}
+	}
+	printNames("categories", res.Created.Categories)
+	printNames("venues", res.Created.Venues)
+	printNames("shoppers", res.Created.Shoppers)
+
+	if len(res.Errors) > 0 {
+		return fmt.Errorf("%d of %d rows are invalid, nothing has been imported", len(res.Errors), res.Rows)
+	}
+	if res.DryRun {
+		fmt.Printf("%d rows are valid, nothing has been imported (dry run)\n", res.Valid)
+		return nil
+	}
+	fmt.Printf("Imported %d purchases\n", res.Imported)
+	if res.Warning != "" {
+		fmt.Printf("Warning: %s\nRun rebuild-aggregates to recalculate the statistics.\n", res.Warning)
+	}
+
+	// Alerts are evaluated before exiting, as there is no server to do it in the background
+	notification.SetupChannels(cfg)
+	notification.EvaluatePurchases(res.Purchases)
+	return nil
+}
+
+/*
+Writes the backup to a temporary file first, so an incomplete backup never replaces an existing one.
+*/
+func runBackup(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return fmt.Errorf("Expected the backup file to write as argument")
+	}
+	file := ctx.Args().First()
+
+	_, err := loadConfig(ctx)
+	if err != nil {
+		return err
+	}
+	log := config.Logger()
+
+	tmp := file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to create backup file: %s", err)
+	}
+
+	m, err := backup.Create(f, backup.Options{Sessions: ctx.Bool("sessions"), Images: ctx.Bool("images")})
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		err = fmt.Errorf("Failed to create backup: %s", err)
+		log.Error(err)
+		return err
+	}
+
+	documents := 0
+	for _, c := range m.Collections {
+		documents += c.Documents
+	}
+	fmt.Printf("Backed up %d documents in %d collections (schema version %d) to %s\n", documents, len(m.Collections), m.SchemaVersion, file)
+	return nil
+}
+
+func runRestore(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return fmt.Errorf("Expected the backup file to restore as argument")
+	}
+
+	_, err := loadConfig(ctx)
+	if err != nil {
+		return err
+	}
+	log := config.Logger()
+
+	f, err := os.Open(ctx.Args().First())
+	if err != nil {
+		return fmt.Errorf("Failed to open backup file: %s", err)
+	}
+	defer f.Close()
+
+	m, err := backup.Restore(f, ctx.Bool("force"))
+	if err != nil {
+		err = fmt.Errorf("Failed to restore backup: %s", err)
+		log.Error(err)
+		return err
+	}
+
+	fmt.Printf("Restored %d collections from backup of %s (schema version %d)\n", len(m.Collections), m.Created, m.SchemaVersion)
+	return nil
+}
+
+/*
+Loads the configuration file given on the command line and sets up logging.
+*/
+func loadConfig(ctx *cli.Context) (*config.AppConfig, error) {
+	cfg := config.GetAppConfig()
+	err := cfg.LoadFromFile(ctx.GlobalString("config"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load configuration: %s", err)
+	}
+	config.SetupLogging(cfg.Logfile, cfg.Loglevel)
+
+	if cfg.TimeZone != "" {
+		_, err = repository.LoadLocation(cfg.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid configuration: %s", err)
+		}
+	}
+
+	return cfg, nil
+}